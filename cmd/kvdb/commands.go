@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// prefixes of the keys written by the hold package
+const (
+	holdRecordPrefix = "bh_"
+	holdIndexPrefix  = "_bhIndex:"
+	holdExpiryPrefix = "_bhExpiry:"
+)
+
+func (c *cli) prefixes(args []string) error {
+	counts := make(map[string]uint64)
+	c.storage.IterateRange(nil, nil, interfaces.RangeOptions{KeysOnly: true}, func(key, _ []byte) {
+		counts[keyGroup(key)]++
+	})
+
+	groups := make([]string, 0, len(counts))
+	for group := range counts {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		fmt.Fprintf(c.out, "%s\t%d\n", group, counts[group])
+	}
+	return nil
+}
+
+// keyGroup returns the group a key is counted in by prefixes
+func keyGroup(key []byte) string {
+	if typeName, _, ok := holdRecord(key); ok {
+		return "type " + typeName
+	}
+	if typeName, indexName, _, ok := holdIndex(key); ok {
+		return "index " + typeName + ":" + indexName
+	}
+	if i := bytes.IndexByte(key, ':'); i > 0 {
+		return "prefix " + showString(key[:i+1])
+	}
+	return "key " + showString(key)
+}
+
+func (c *cli) count(args []string) error {
+	var prefix []byte
+	if len(args) > 0 {
+		var err error
+		if prefix, err = c.arg(args[0]); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(c.out, c.storage.KeysByPrefixCount(prefix))
+	return nil
+}
+
+func (c *cli) dump(args []string) error {
+	var prefix []byte
+	if len(args) > 0 {
+		var err error
+		if prefix, err = c.arg(args[0]); err != nil {
+			return err
+		}
+	}
+
+	c.storage.IterateByPrefix(prefix, c.limit, func(key, value []byte) {
+		fmt.Fprintf(c.out, "%s\t%s\n", c.showKey(key), c.showEntry(key, value))
+	})
+	return nil
+}
+
+func (c *cli) get(args []string) error {
+	key, err := c.arg(args[0])
+	if err != nil {
+		return err
+	}
+
+	value, err := c.storage.Get(string(key))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, c.showValue(value))
+	return nil
+}
+
+func (c *cli) set(args []string) error {
+	key, err := c.arg(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := c.arg(args[1])
+	if err != nil {
+		return err
+	}
+
+	return c.storage.Set(string(key), value)
+}
+
+func (c *cli) del(args []string) error {
+	key, err := c.arg(args[0])
+	if err != nil {
+		return err
+	}
+
+	return c.storage.Del(string(key))
+}
+
+func (c *cli) gc(args []string) error {
+	ratio := 0.5
+	if len(args) > 0 {
+		var err error
+		if ratio, err = strconv.ParseFloat(args[0], 64); err != nil {
+			return err
+		}
+	}
+
+	rewritten := 0
+	for {
+		err := c.storage.DB.RunValueLogGC(ratio)
+		if err == badger.ErrNoRewrite {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		rewritten++
+	}
+
+	fmt.Fprintf(c.out, "rewrote %d value log files\n", rewritten)
+	return nil
+}
+
+func (c *cli) flatten(args []string) error {
+	workers := 1
+	if len(args) > 0 {
+		var err error
+		if workers, err = strconv.Atoi(args[0]); err != nil {
+			return err
+		}
+	}
+
+	return c.storage.DB.Flatten(workers)
+}
+
+func (c *cli) backup(args []string) error {
+	var since uint64
+	if len(args) > 1 {
+		var err error
+		if since, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+
+	version, err := c.storage.Backup(f, since)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "backed up to version %d, pass it as since to back up incrementally\n", version)
+	return nil
+}
+
+func (c *cli) restore(args []string) error {
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return c.storage.Restore(f)
+}
+
+func (c *cli) verify(args []string) error {
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := db.VerifyBackup(f)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "%d keys up to version %d\n", info.Keys, info.MaxVersion)
+	return nil
+}
+
+// check reports the index entries pointing to missing records and the records missing from an index of their type
+// Index values are expected to be Gob encoded, as with the default hold encoder. Entries pointing to records
+// which expired and weren't swept yet are reported but aren't problems. Index funcs returning nil leave records
+// out of their index, so a record is only reported missing from an index named after one of its fields holding
+// a value, as the indexes of struct tags are.
+func (c *cli) check(args []string) error {
+	records := make(map[string]string)
+	// indexed keys by index, by type
+	indexed := make(map[string]map[string]map[string]bool)
+	problems := 0
+
+	expiring := make(map[string]bool)
+	c.storage.IterateRange(nil, nil, interfaces.RangeOptions{KeysOnly: true}, func(key, _ []byte) {
+		if typeName, _, ok := holdRecord(key); ok {
+			records[string(key)] = typeName
+		}
+		if bytes.HasPrefix(key, []byte(holdExpiryPrefix)) {
+			expiring[string(key[len(holdExpiryPrefix):])] = true
+		}
+	})
+
+	var err error
+	c.storage.IterateByPrefix([]byte(holdIndexPrefix), 0, func(key, value []byte) {
+		typeName, indexName, _, ok := holdIndex(key)
+		if !ok || err != nil {
+			return
+		}
+
+		var keys [][]byte
+		if err = gob.NewDecoder(bytes.NewReader(value)).Decode(&keys); err != nil {
+			err = fmt.Errorf("index entry %s: %s", c.showKey(key), err)
+			return
+		}
+
+		if indexed[typeName] == nil {
+			indexed[typeName] = make(map[string]map[string]bool)
+		}
+		if indexed[typeName][indexName] == nil {
+			indexed[typeName][indexName] = make(map[string]bool)
+		}
+
+		for _, recordKey := range keys {
+			indexed[typeName][indexName][string(recordKey)] = true
+			if records[string(recordKey)] == typeName {
+				continue
+			}
+			if expiring[string(recordKey)] {
+				fmt.Fprintf(c.out, "expired\t%s\t%s\n", c.showKey(key), c.showKey(recordKey))
+				continue
+			}
+			fmt.Fprintf(c.out, "dangling\t%s\t%s\n", c.showKey(key), c.showKey(recordKey))
+			problems++
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	recordKeys := make([]string, 0, len(records))
+	for key := range records {
+		recordKeys = append(recordKeys, key)
+	}
+	sort.Strings(recordKeys)
+
+	for _, key := range recordKeys {
+		typeName := records[key]
+		var fields map[string]interface{}
+		for indexName, keys := range indexed[typeName] {
+			if keys[key] {
+				continue
+			}
+			if fields == nil {
+				fields = c.recordFields(key)
+			}
+			if fields[indexName] == nil {
+				continue
+			}
+			fmt.Fprintf(c.out, "unindexed\t%s:%s\t%s\n", typeName, indexName, c.showKey([]byte(key)))
+			problems++
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("found %d index problems", problems)
+	}
+	fmt.Fprintf(c.out, "checked %d records\n", len(records))
+	return nil
+}
+
+// recordFields returns the fields of the hold record stored in key, empty if it isn't a Gob encoded struct
+func (c *cli) recordFields(key string) map[string]interface{} {
+	value, err := c.storage.Get(key)
+	if err != nil {
+		return map[string]interface{}{}
+	}
+	decoded, err := newGobDecoder().decode(value)
+	if fields, ok := decoded.(map[string]interface{}); ok && err == nil {
+		return fields
+	}
+	return map[string]interface{}{}
+}
+
+// arg returns the bytes of a key or value argument
+func (c *cli) arg(arg string) ([]byte, error) {
+	if c.hex {
+		return hex.DecodeString(arg)
+	}
+	return []byte(arg), nil
+}
+
+// showKey formats a key, the keys of hold records are shown with their type and decoded key
+func (c *cli) showKey(key []byte) string {
+	if c.hex {
+		return hex.EncodeToString(key)
+	}
+	if typeName, encoded, ok := holdRecord(key); ok {
+		return holdRecordPrefix + typeName + " " + showGob(encoded)
+	}
+	if typeName, indexName, encoded, ok := holdIndex(key); ok {
+		return holdIndexPrefix + typeName + ":" + indexName + " " + showGob(encoded)
+	}
+	return showString(key)
+}
+
+// showEntry formats the value of key, the values of hold index entries are shown as the keys they point to
+func (c *cli) showEntry(key, value []byte) string {
+	if _, _, _, ok := holdIndex(key); !ok || c.hex {
+		return c.showValue(value)
+	}
+
+	var keys [][]byte
+	if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&keys); err != nil {
+		return c.showValue(value)
+	}
+
+	shown := make([]string, len(keys))
+	for i := range keys {
+		shown[i] = c.showKey(keys[i])
+	}
+	list, _ := json.Marshal(shown)
+	return string(list)
+}
+
+// showValue formats a value, decoded from Gob if it can be
+func (c *cli) showValue(value []byte) string {
+	if c.hex {
+		return hex.EncodeToString(value)
+	}
+	return showGob(value)
+}
+
+func showGob(data []byte) string {
+	value, err := newGobDecoder().decode(data)
+	if err != nil {
+		return showString(data)
+	}
+
+	shown, err := json.Marshal(value)
+	if err != nil {
+		return hex.EncodeToString(data)
+	}
+	return string(shown)
+}
+
+// showString quotes data if it's text, and shows it as hex otherwise
+func showString(data []byte) string {
+	if utf8.Valid(data) {
+		return strconv.Quote(string(data))
+	}
+	return "0x" + hex.EncodeToString(data)
+}
+
+// holdRecord splits the key of a hold record into its type name and encoded key
+func holdRecord(key []byte) (typeName string, encoded []byte, ok bool) {
+	if !bytes.HasPrefix(key, []byte(holdRecordPrefix)) {
+		return "", nil, false
+	}
+	return splitName(key[len(holdRecordPrefix):])
+}
+
+// holdIndex splits the key of a hold index entry into its type name, index name and encoded value
+func holdIndex(key []byte) (typeName, indexName string, encoded []byte, ok bool) {
+	if !bytes.HasPrefix(key, []byte(holdIndexPrefix)) {
+		return "", "", nil, false
+	}
+
+	rest := key[len(holdIndexPrefix):]
+	i := bytes.IndexByte(rest, ':')
+	if i <= 0 {
+		return "", "", nil, false
+	}
+
+	indexName, encoded, ok = splitName(rest[i+1:])
+	return string(rest[:i]), indexName, encoded, ok
+}
+
+// splitName splits a go identifier from the Gob encoded value written right after it
+// The first byte of the value can be an identifier character too, the longest name leaving a valid
+// Gob value is used.
+func splitName(data []byte) (name string, encoded []byte, ok bool) {
+	n := 0
+	for n < len(data) && isIdentByte(data[n]) {
+		n++
+	}
+
+	for i := n; i > 0; i-- {
+		if _, err := newGobDecoder().decode(data[i:]); err == nil {
+			return string(data[:i]), data[i:], true
+		}
+	}
+	return "", nil, false
+}
+
+func isIdentByte(b byte) bool {
+	return b == '_' || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9')
+}
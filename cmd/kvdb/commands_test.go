@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/hold"
+)
+
+type checkItem struct {
+	Name     string
+	Category string
+	Group    string
+}
+
+// Type implements hold.Storer, records without a group are left out of the Group index
+func (i *checkItem) Type() string {
+	return "checkItem"
+}
+
+func (i *checkItem) Indexes() map[string]hold.Index {
+	return map[string]hold.Index{
+		"Category": {
+			IndexFunc: func(name string, value interface{}) ([]byte, error) {
+				return gobEncode(value.(*checkItem).Category)
+			},
+		},
+		"Group": {
+			IndexFunc: func(name string, value interface{}) ([]byte, error) {
+				if value.(*checkItem).Group == "" {
+					return nil, nil
+				}
+				return gobEncode(value.(*checkItem).Group)
+			},
+		},
+	}
+}
+
+func gobEncode(value interface{}) ([]byte, error) {
+	var buff bytes.Buffer
+	err := gob.NewEncoder(&buff).Encode(value)
+	return buff.Bytes(), err
+}
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvdb-")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := hold.DefaultOptions
+	opts.Dir = dir
+	opts.ValueDir = dir
+	store, err := hold.Open(opts)
+	if err != nil {
+		t.Fatalf("Error opening store: %s", err)
+	}
+	defer store.Close()
+
+	items := map[string]*checkItem{
+		"grouped":   {Name: "grouped", Category: "a", Group: "g"},
+		"ungrouped": {Name: "ungrouped", Category: "a"},
+	}
+	for key, item := range items {
+		if err = store.Insert(key, item); err != nil {
+			t.Fatalf("Error inserting: %s", err)
+		}
+	}
+
+	var out bytes.Buffer
+	c := &cli{storage: &db.Badger{DB: store.Badger()}, out: &out}
+	if err = c.check(nil); err != nil {
+		t.Fatalf("Got %v checking a record left out of an index, output:\n%s", err, out.String())
+	}
+
+	// drop the Group index entry of the grouped record
+	var indexKey []byte
+	c.storage.IterateByPrefix([]byte(holdIndexPrefix+"checkItem:Group"), 0, func(key, _ []byte) {
+		indexKey = key
+	})
+	err = store.Badger().Update(func(txn *badger.Txn) error {
+		return txn.Delete(indexKey)
+	})
+	if err != nil {
+		t.Fatalf("Error deleting index entry: %s", err)
+	}
+	if err = store.Insert("other", &checkItem{Name: "other", Category: "b", Group: "h"}); err != nil {
+		t.Fatalf("Error inserting: %s", err)
+	}
+
+	out.Reset()
+	if err = c.check(nil); err == nil || strings.Count(out.String(), "unindexed") != 1 {
+		t.Fatalf("Got %v checking a record missing from an index, output:\n%s", err, out.String())
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"runtime"
+	"time"
+)
+
+// ids of the types every gob stream knows without them being sent
+const (
+	gobBool      = 1
+	gobInt       = 2
+	gobUint      = 3
+	gobFloat     = 4
+	gobBytes     = 5
+	gobString    = 6
+	gobComplex   = 7
+	gobInterface = 8
+
+	gobWireType       = 16
+	gobArrayType      = 17
+	gobCommonType     = 18
+	gobSliceType      = 19
+	gobStructType     = 20
+	gobFieldType      = 21
+	gobFieldTypeSlice = 22
+	gobMapType        = 23
+	gobEncoderType    = 24
+)
+
+// kinds of the types sent in a gob stream
+const (
+	kindBuiltin = iota
+	kindStruct
+	kindSlice
+	kindArray
+	kindMap
+	kindEncoded
+)
+
+var errGobCorrupt = errors.New("gob: corrupted data")
+
+type gobField struct {
+	name string
+	id   int
+}
+
+type gobType struct {
+	name   string
+	kind   int
+	elem   int
+	key    int
+	fields []gobField
+}
+
+// gobDecoder decodes gob streams without the go types they were encoded from, into generic values:
+// structs become maps of their sent fields, values of types with their own encoding become bytes,
+// except times
+type gobDecoder struct {
+	types map[int]*gobType
+}
+
+func newGobDecoder() *gobDecoder {
+	common := gobField{"CommonType", gobCommonType}
+	d := &gobDecoder{types: map[int]*gobType{
+		gobWireType: {name: "wireType", kind: kindStruct, fields: []gobField{
+			{"ArrayT", gobArrayType}, {"SliceT", gobSliceType}, {"StructT", gobStructType}, {"MapT", gobMapType},
+			{"GobEncoderT", gobEncoderType}, {"BinaryMarshalerT", gobEncoderType}, {"TextMarshalerT", gobEncoderType},
+		}},
+		gobArrayType:      {name: "arrayType", kind: kindStruct, fields: []gobField{common, {"Elem", gobInt}, {"Len", gobInt}}},
+		gobCommonType:     {name: "CommonType", kind: kindStruct, fields: []gobField{{"Name", gobString}, {"Id", gobInt}}},
+		gobSliceType:      {name: "sliceType", kind: kindStruct, fields: []gobField{common, {"Elem", gobInt}}},
+		gobStructType:     {name: "structType", kind: kindStruct, fields: []gobField{common, {"Field", gobFieldTypeSlice}}},
+		gobFieldType:      {name: "fieldType", kind: kindStruct, fields: []gobField{{"Name", gobString}, {"Id", gobInt}}},
+		gobFieldTypeSlice: {name: "[]fieldType", kind: kindSlice, elem: gobFieldType},
+		gobMapType:        {name: "mapType", kind: kindStruct, fields: []gobField{common, {"Key", gobInt}, {"Elem", gobInt}}},
+		gobEncoderType:    {name: "gobEncoderType", kind: kindStruct, fields: []gobField{common}},
+	}}
+	for id := gobBool; id <= gobInterface; id++ {
+		d.types[id] = &gobType{kind: kindBuiltin}
+	}
+	return d
+}
+
+// decode decodes the single value of a gob stream
+func (d *gobDecoder) decode(data []byte) (value interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(gobError); ok {
+				value, err = nil, e.err
+				return
+			}
+			// corrupt data can still trip over the decoder, it must never crash the commands reading a store
+			if _, ok := r.(runtime.Error); ok {
+				value, err = nil, errGobCorrupt
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	r := &gobReader{data: data}
+	for {
+		if r.empty() {
+			return nil, errGobCorrupt
+		}
+		msg := &gobReader{data: r.next(int(r.uint()))}
+
+		id := int(msg.int())
+		if id < 0 {
+			d.define(-id, msg)
+			continue
+		}
+
+		value = d.value(msg, id)
+		if !msg.empty() || !r.empty() {
+			return nil, errGobCorrupt
+		}
+		return value, nil
+	}
+}
+
+// value decodes a value sent at top level, values not of a struct type are sent as a struct's only field
+func (d *gobDecoder) value(r *gobReader, id int) interface{} {
+	t := d.typ(id)
+	if t.kind == kindStruct {
+		return d.structValue(r, t)
+	}
+	if r.uint() != 0 {
+		gobFail(errGobCorrupt)
+	}
+	return d.field(r, id)
+}
+
+func (d *gobDecoder) field(r *gobReader, id int) interface{} {
+	switch id {
+	case gobBool:
+		return r.uint() != 0
+	case gobInt:
+		return r.int()
+	case gobUint:
+		return r.uint()
+	case gobFloat:
+		return r.float()
+	case gobBytes:
+		return r.next(int(r.uint()))
+	case gobString:
+		return string(r.next(int(r.uint())))
+	case gobComplex:
+		return complex(r.float(), r.float())
+	case gobInterface:
+		return d.iface(r)
+	}
+
+	t := d.typ(id)
+	switch t.kind {
+	case kindStruct:
+		return d.structValue(r, t)
+	case kindSlice, kindArray:
+		values := make([]interface{}, r.count())
+		for i := range values {
+			values[i] = d.field(r, t.elem)
+		}
+		return values
+	case kindMap:
+		values := make(map[string]interface{})
+		for n := r.count(); n > 0; n-- {
+			key := d.field(r, t.key)
+			values[fmt.Sprint(key)] = d.field(r, t.elem)
+		}
+		return values
+	case kindEncoded:
+		data := r.next(int(r.uint()))
+		if t.name == "Time" {
+			var tm time.Time
+			if tm.UnmarshalBinary(data) == nil {
+				return tm
+			}
+		}
+		return data
+	}
+
+	gobFail(fmt.Errorf("gob: unknown type id %d", id))
+	return nil
+}
+
+func (d *gobDecoder) structValue(r *gobReader, t *gobType) map[string]interface{} {
+	values := make(map[string]interface{})
+	field := -1
+	for {
+		delta := r.uint()
+		if delta == 0 {
+			return values
+		}
+		// checked before adding, a huge delta would overflow field
+		if delta > uint64(len(t.fields)-1-field) {
+			gobFail(errGobCorrupt)
+		}
+		field += int(delta)
+		values[t.fields[field].name] = d.field(r, t.fields[field].id)
+	}
+}
+
+// iface decodes an interface value, the types it uses can be sent right before it
+func (d *gobDecoder) iface(r *gobReader) interface{} {
+	name := string(r.next(int(r.uint())))
+	if name == "" {
+		return nil
+	}
+
+	id := int(r.int())
+	for id < 0 {
+		d.define(-id, r)
+		if !r.empty() {
+			r.uint()
+		}
+		id = int(r.int())
+	}
+
+	r.uint()
+	return d.value(r, id)
+}
+
+// define decodes the definition of the type id sent in the stream, types are only defined once and never
+// replace the types every stream knows
+func (d *gobDecoder) define(id int, r *gobReader) {
+	if _, ok := d.types[id]; ok || id <= gobEncoderType {
+		gobFail(errGobCorrupt)
+	}
+	d.types[id] = d.wireType(r)
+}
+
+// wireType decodes the definition of a type sent in the stream
+func (d *gobDecoder) wireType(r *gobReader) *gobType {
+	wire := d.structValue(r, d.types[gobWireType])
+	for kind, def := range wire {
+		def, ok := def.(map[string]interface{})
+		if !ok {
+			gobFail(errGobCorrupt)
+		}
+		t := &gobType{elem: intValue(def["Elem"]), key: intValue(def["Key"])}
+		if common, ok := def["CommonType"].(map[string]interface{}); ok {
+			t.name, _ = common["Name"].(string)
+		}
+
+		switch kind {
+		case "ArrayT":
+			t.kind = kindArray
+		case "SliceT":
+			t.kind = kindSlice
+		case "MapT":
+			t.kind = kindMap
+		case "StructT":
+			t.kind = kindStruct
+			fields, _ := def["Field"].([]interface{})
+			for _, f := range fields {
+				f, ok := f.(map[string]interface{})
+				if !ok {
+					gobFail(errGobCorrupt)
+				}
+				name, _ := f["Name"].(string)
+				t.fields = append(t.fields, gobField{name: name, id: intValue(f["Id"])})
+			}
+		default:
+			t.kind = kindEncoded
+		}
+		return t
+	}
+
+	gobFail(errGobCorrupt)
+	return nil
+}
+
+func (d *gobDecoder) typ(id int) *gobType {
+	t, ok := d.types[id]
+	if !ok {
+		gobFail(fmt.Errorf("gob: unknown type id %d", id))
+	}
+	return t
+}
+
+func intValue(v interface{}) int {
+	i, _ := v.(int64)
+	return int(i)
+}
+
+// gobError carries decoding errors up the stack, like encoding/gob does
+type gobError struct {
+	err error
+}
+
+func gobFail(err error) {
+	panic(gobError{err})
+}
+
+type gobReader struct {
+	data []byte
+}
+
+func (r *gobReader) empty() bool {
+	return len(r.data) == 0
+}
+
+func (r *gobReader) next(n int) []byte {
+	if n < 0 || n > len(r.data) {
+		gobFail(errGobCorrupt)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// uint reads an unsigned integer, sent as a single byte below 128 or as its negated byte count
+// followed by its big endian bytes
+func (r *gobReader) uint() uint64 {
+	b := r.next(1)[0]
+	if b < 0x80 {
+		return uint64(b)
+	}
+
+	n := -int(int8(b))
+	if n > 8 {
+		gobFail(errGobCorrupt)
+	}
+	var x uint64
+	for _, c := range r.next(n) {
+		x = x<<8 | uint64(c)
+	}
+	return x
+}
+
+// count reads the number of elements of a slice, array or map, every element takes at least a byte
+func (r *gobReader) count() int {
+	n := r.uint()
+	if n > uint64(len(r.data)) {
+		gobFail(errGobCorrupt)
+	}
+	return int(n)
+}
+
+// int reads a signed integer, sent as an unsigned one with its sign in the lowest bit
+func (r *gobReader) int() int64 {
+	u := r.uint()
+	if u&1 != 0 {
+		return ^int64(u >> 1)
+	}
+	return int64(u >> 1)
+}
+
+// float reads a float, sent as an unsigned integer of its byte reversed bits
+func (r *gobReader) float() float64 {
+	return math.Float64frombits(bits.ReverseBytes64(r.uint()))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+type gobNested struct {
+	Weight float64
+	Labels map[string]int
+}
+
+type gobRecord struct {
+	Name    string
+	Count   int
+	Size    uint32
+	Enabled bool
+	Tags    []string
+	Grid    [2]int8
+	Created time.Time
+	Nested  gobNested
+	Any     interface{}
+	Skipped int
+}
+
+func TestGobDecoder(t *testing.T) {
+	gob.Register(gobNested{})
+
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value interface{}
+		json  string
+	}{
+		{"String", "config:version", `"config:version"`},
+		{"Negative Int", -1234567, `-1234567`},
+		{"Large Uint", uint64(1) << 60, `1152921504606846976`},
+		{"Float", 2.5, `2.5`},
+		{"Bytes", []byte{1, 2}, `"AQI="`},
+		{"Struct", gobRecord{
+			Name:    "record",
+			Count:   -3,
+			Size:    300,
+			Enabled: true,
+			Tags:    []string{"a", "b"},
+			Grid:    [2]int8{1, -1},
+			Created: created,
+			Nested:  gobNested{Weight: 0.5, Labels: map[string]int{"x": 1}},
+			Any:     gobNested{Weight: 1},
+		}, `{"Any":{"Weight":1},"Count":-3,"Created":"2020-01-02T03:04:05Z","Enabled":true,` +
+			`"Grid":[1,-1],"Name":"record","Nested":{"Labels":{"x":1},"Weight":0.5},"Size":300,"Tags":["a","b"]}`},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			var buff bytes.Buffer
+			err := gob.NewEncoder(&buff).Encode(tst.value)
+			if err != nil {
+				t.Fatalf("Error encoding: %s", err)
+			}
+
+			value, err := newGobDecoder().decode(buff.Bytes())
+			if err != nil {
+				t.Fatalf("Error decoding: %s", err)
+			}
+
+			got, err := json.Marshal(value)
+			if err != nil {
+				t.Fatalf("Error marshalling: %s", err)
+			}
+			if string(got) != tst.json {
+				t.Fatalf("Got %s wanted %s", got, tst.json)
+			}
+
+			_, err = newGobDecoder().decode(buff.Bytes()[:buff.Len()-1])
+			if err == nil {
+				t.Fatalf("Decoding a truncated value succeeded")
+			}
+		})
+	}
+}
+
+func TestGobDecoderCorrupt(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		// a field delta overflowing the field number
+		{"Field Delta", "01\x03\x01\x01\x03000\xf8\xff" + strings.Repeat("0", 40)},
+		{"Zero Length", "\x00"},
+		{"Builtin Redefined", "\x02\x1f\x00"},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			value, err := newGobDecoder().decode([]byte(tst.data))
+			if err != errGobCorrupt {
+				t.Fatalf("Decoded %v, %v, wanted errGobCorrupt", value, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+// Command kvdb inspects and maintains the badger directories written by the db and hold packages
+//
+// Usage:
+//
+//	kvdb -dir <path> [-hex] [-limit n] <command> [arguments]
+//
+// The directory is opened read-only unless the command writes to it. Keys and values given as arguments
+// are raw strings, or hex with -hex. Values are shown decoded from Gob when they can be, as hex otherwise.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+)
+
+// command is an operation of the tool
+type command struct {
+	args    string
+	help    string
+	writes  bool
+	noStore bool
+	minArgs int
+	maxArgs int
+	run     func(c *cli, args []string) error
+}
+
+var commands = map[string]*command{
+	"prefixes": {help: "list hold types, hold indexes and other key prefixes with their key counts",
+		run: (*cli).prefixes},
+	"count": {args: "[prefix]", help: "count the keys starting with prefix", maxArgs: 1,
+		run: (*cli).count},
+	"dump": {args: "[prefix]", help: "print the keys starting with prefix and their values", maxArgs: 1,
+		run: (*cli).dump},
+	"get": {args: "<key>", help: "print the value of a key", minArgs: 1, maxArgs: 1,
+		run: (*cli).get},
+	"set": {args: "<key> <value>", help: "set the value of a key", writes: true, minArgs: 2, maxArgs: 2,
+		run: (*cli).set},
+	"del": {args: "<key>", help: "delete a key", writes: true, minArgs: 1, maxArgs: 1,
+		run: (*cli).del},
+	"gc": {args: "[ratio]", help: "rewrite the value log files with at least ratio of stale data, 0.5 by default",
+		writes: true, maxArgs: 1, run: (*cli).gc},
+	"flatten": {args: "[workers]", help: "compact every level of the LSM tree into the last one", writes: true,
+		maxArgs: 1, run: (*cli).flatten},
+	"backup": {args: "<file> [since]", help: "write a backup of the keys changed after version since",
+		minArgs: 1, maxArgs: 2, run: (*cli).backup},
+	"restore": {args: "<file>", help: "load a backup", writes: true, minArgs: 1, maxArgs: 1,
+		run: (*cli).restore},
+	"verify": {args: "<file>", help: "check a backup can be read, -dir isn't needed", noStore: true,
+		minArgs: 1, maxArgs: 1, run: (*cli).verify},
+	"check": {help: "check every hold index points to existing records and every record is indexed",
+		run: (*cli).check},
+}
+
+// flags are the flags of the tool only, not the ones dependencies add to flag.CommandLine
+var flags = flag.NewFlagSet("kvdb", flag.ExitOnError)
+
+// cli holds the state shared by the commands
+type cli struct {
+	storage *db.Badger
+	hex     bool
+	limit   uint64
+	out     io.Writer
+}
+
+func main() {
+	dir := flags.String("dir", "", "badger directory")
+	hexArgs := flags.Bool("hex", false, "read keys and values from arguments as hex, and print them as hex")
+	limit := flags.Uint64("limit", 0, "maximum number of keys dump prints, 0 means no limit")
+	flags.Usage = usage
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	args := flags.Args()[1:]
+	if !ok || len(args) < cmd.minArgs || len(args) > cmd.maxArgs || (*dir == "" && !cmd.noStore) {
+		usage()
+		os.Exit(2)
+	}
+
+	c := &cli{hex: *hexArgs, limit: *limit, out: os.Stdout}
+	if !cmd.noStore {
+		storage, err := open(*dir, cmd.writes)
+		if err != nil {
+			fail(err)
+		}
+		c.storage = storage
+	}
+
+	err := cmd.run(c, args)
+	if c.storage != nil {
+		if closeErr := c.storage.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+// open opens the badger directory, read-only unless writes is set
+func open(dir string, writes bool) (*db.Badger, error) {
+	opts := badger.DefaultOptions(dir).WithLoggingLevel(badger.WARNING)
+	if writes {
+		opts.SyncWrites = true
+	} else {
+		opts.ReadOnly = true
+	}
+
+	bdb, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &db.Badger{DB: bdb}, nil
+}
+
+func usage() {
+	fmt.Fprintf(flags.Output(), "Usage: kvdb -dir <path> [-hex] [-limit n] <command> [arguments]\n\n")
+	flags.PrintDefaults()
+	fmt.Fprintf(flags.Output(), "\nCommands:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(flags.Output(), "  %s %s\n    \t%s\n", name, cmd.args, cmd.help)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "kvdb: %s\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/dgraph-io/badger/v3/pb"
+)
+
+// DefaultRestorePendingWrites is the number of writes a restore keeps in flight
+const DefaultRestorePendingWrites = 256
+
+// maxBackupBatch bounds the size of a batch of keys read from a backup, badger writes far smaller ones
+const maxBackupBatch = 1 << 30
+
+// ErrBackupCorrupted is returned by VerifyBackup when a backup is truncated or corrupted
+var ErrBackupCorrupted = errors.New("backup is truncated or corrupted")
+
+// ErrRestoreNotEmpty is returned by RestoreTo when the directory to restore into already holds files
+var ErrRestoreNotEmpty = errors.New("directory to restore into is not empty")
+
+// BackupInfo describes the content of a backup
+type BackupInfo struct {
+	Keys       uint64
+	MaxVersion uint64
+}
+
+// Backup writes every key changed after version since to w, since 0 is a full backup
+// It returns the version to pass as since to the next, incremental, backup. The database stays
+// usable while it runs, the backup is a consistent snapshot taken when it starts.
+func (storage *Badger) Backup(w io.Writer, since uint64) (uint64, error) {
+	return storage.DB.Backup(w, since)
+}
+
+// Restore loads a backup written by Backup, incremental backups have to be restored in the order
+// they were taken, after the full backup they're based on
+// Nothing else should write to the database while it runs.
+func (storage *Badger) Restore(r io.Reader) error {
+	return storage.DB.Load(r, DefaultRestorePendingWrites)
+}
+
+// RestoreTo restores a backup into a new database in dir, which must be empty or not exist,
+// and returns it opened
+func RestoreTo(dir string, r io.Reader) (*Badger, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		return nil, ErrRestoreNotEmpty
+	}
+
+	storage := NewBadger(dir)
+	if err = storage.Restore(r); err != nil {
+		storage.Close()
+		return nil, err
+	}
+	return storage, nil
+}
+
+// VerifyBackup reads a backup written by Backup without restoring it, and returns what it holds
+// ErrBackupCorrupted is returned if the backup is truncated or corrupted.
+func VerifyBackup(r io.Reader) (BackupInfo, error) {
+	var info BackupInfo
+	br := bufio.NewReader(r)
+	var buf bytes.Buffer
+
+	for {
+		var size uint64
+		err := binary.Read(br, binary.LittleEndian, &size)
+		if err == io.EOF {
+			return info, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return info, ErrBackupCorrupted
+		}
+		if err != nil {
+			return info, err
+		}
+		if size > maxBackupBatch {
+			return info, ErrBackupCorrupted
+		}
+
+		// the buffer grows with the bytes actually read, a corrupted size can't allocate more than the backup holds
+		buf.Reset()
+		_, err = io.CopyN(&buf, br, int64(size))
+		if err == io.EOF {
+			return info, ErrBackupCorrupted
+		}
+		if err != nil {
+			return info, err
+		}
+
+		list := &pb.KVList{}
+		if err = list.Unmarshal(buf.Bytes()); err != nil {
+			return info, ErrBackupCorrupted
+		}
+
+		for _, kv := range list.Kv {
+			info.Keys++
+			if kv.Version > info.MaxVersion {
+				info.MaxVersion = kv.Version
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package db_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+)
+
+func TestBackupRestore(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		for _, key := range []string{"a", "b", "c"} {
+			if err := storage.Set(key, []byte(key)); err != nil {
+				t.Fatalf("Error setting: %s", err)
+			}
+		}
+
+		var full bytes.Buffer
+		since, err := storage.Backup(&full, 0)
+		if err != nil {
+			t.Fatalf("Error backing up: %s", err)
+		}
+
+		if err = storage.Set("d", []byte("d")); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+		if err = storage.Del("a"); err != nil {
+			t.Fatalf("Error deleting: %s", err)
+		}
+
+		var incremental bytes.Buffer
+		if _, err = storage.Backup(&incremental, since); err != nil {
+			t.Fatalf("Error backing up incrementally: %s", err)
+		}
+
+		info, err := db.VerifyBackup(bytes.NewReader(full.Bytes()))
+		if err != nil {
+			t.Fatalf("Error verifying backup: %s", err)
+		}
+		if info.Keys != 3 || info.MaxVersion == 0 {
+			t.Fatalf("Got %+v verifying the full backup wanted 3 keys", info)
+		}
+
+		truncated := full.Bytes()[:full.Len()-1]
+		if _, err = db.VerifyBackup(bytes.NewReader(truncated)); err != db.ErrBackupCorrupted {
+			t.Fatalf("Verifying a truncated backup returned %v", err)
+		}
+
+		// a corrupted length
+		corrupted := append([]byte{}, full.Bytes()...)
+		binary.LittleEndian.PutUint64(corrupted, 1<<62)
+		if _, err = db.VerifyBackup(bytes.NewReader(corrupted)); err != db.ErrBackupCorrupted {
+			t.Fatalf("Verifying a backup with a corrupted length returned %v", err)
+		}
+		binary.LittleEndian.PutUint64(corrupted, 1<<29)
+		if _, err = db.VerifyBackup(bytes.NewReader(corrupted)); err != db.ErrBackupCorrupted {
+			t.Fatalf("Verifying a backup with a length past its end returned %v", err)
+		}
+
+		dir, err := ioutil.TempDir("", "kvdb-restore-")
+		if err != nil {
+			t.Fatalf("Error creating temp dir: %s", err)
+		}
+		defer os.RemoveAll(dir)
+
+		restored, err := db.RestoreTo(filepath.Join(dir, "db"), &full)
+		if err != nil {
+			t.Fatalf("Error restoring: %s", err)
+		}
+		defer restored.Close()
+
+		if err = restored.Restore(&incremental); err != nil {
+			t.Fatalf("Error restoring incremental backup: %s", err)
+		}
+
+		for key, wanted := range map[string]string{"b": "b", "c": "c", "d": "d"} {
+			value, err := restored.Get(key)
+			if err != nil {
+				t.Fatalf("Error getting %s from the restored database: %s", key, err)
+			}
+			if string(value) != wanted {
+				t.Fatalf("Got %q for %s wanted %q", value, key, wanted)
+			}
+		}
+		if _, err = restored.Get("a"); err != badger.ErrKeyNotFound {
+			t.Fatalf("Got %v getting a key deleted after the full backup wanted %s", err, badger.ErrKeyNotFound)
+		}
+
+		if _, err = db.RestoreTo(filepath.Join(dir, "db"), bytes.NewReader(nil)); err != db.ErrRestoreNotEmpty {
+			t.Fatalf("Got %v restoring into a used directory wanted %s", err, db.ErrRestoreNotEmpty)
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"bytes"
 	"time"
 
 	"github.com/dgraph-io/badger/v3"
@@ -22,6 +23,20 @@ func NewBadger(storageDir string) *Badger {
 	opts.Dir = storageDir
 	opts.ValueDir = storageDir
 	opts.WithDetectConflicts(false)
+
+	return newBadger(storage, opts)
+}
+
+// NewBadgerInMemory returns new instance of badger wrapper which keeps all data in memory,
+// nothing is persisted once it is closed
+func NewBadgerInMemory() *Badger {
+	storage := &Badger{}
+	opts := badger.DefaultOptions("").WithInMemory(true)
+
+	return newBadger(storage, opts)
+}
+
+func newBadger(storage *Badger, opts badger.Options) *Badger {
 	var err error
 	storage.DB, err = badger.Open(opts)
 	if err != nil {
@@ -34,24 +49,112 @@ func NewBadger(storageDir string) *Badger {
 }
 
 // ProcessBatch process batch of operations
+// Precondition operations are evaluated in order against the batch transaction, if any of them fails
+// the batch is aborted with an *interfaces.ErrPreconditionFailed and nothing is written. A batch conflicting
+// with other transactions is retried after a backoff, badger.ErrConflict is returned once DefaultTxnRetries
+// attempts conflicted.
 func (storage *Badger) ProcessBatch(batch []*interfaces.Operation) (err error) {
-	return storage.DB.Update(func(txn *badger.Txn) error {
+	return storage.update(func(txn *badger.Txn) error {
 		for _, op := range batch {
-			if op.Op == interfaces.OpSet {
-				if err = txn.Set([]byte(op.Key), op.Value); err != nil {
-					return err
-				}
-			}
-			if op.Op == interfaces.OpDel {
-				if err = txn.Delete([]byte(op.Key)); err != nil {
-					return err
-				}
+			if err := applyOperation(txn, op); err != nil {
+				return err
 			}
 		}
 		return nil
 	})
 }
 
+// CompareAndSwap sets key to new only if it currently holds old, a nil old means the key must not exist
+func (storage *Badger) CompareAndSwap(key string, old, new []byte) (swapped bool, err error) {
+	return compareAndSwap(storage, key, old, new)
+}
+
+// compareAndSwap implements CompareAndSwap with a conditional batch of storage
+func compareAndSwap(storage interfaces.DbStorage, key string, old, new []byte) (swapped bool, err error) {
+	check := &interfaces.Operation{Key: key, Value: old, Op: interfaces.OpCheckEquals}
+	if old == nil {
+		check.Op = interfaces.OpCheckAbsent
+	}
+
+	err = storage.ProcessBatch([]*interfaces.Operation{
+		check,
+		{Key: key, Value: new, Op: interfaces.OpSet},
+	})
+	if _, ok := err.(*interfaces.ErrPreconditionFailed); ok {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// update runs fn in a read-write transaction, retrying after a backoff while it conflicts with other
+// transactions, up to DefaultTxnRetries attempts. fn has to be safe to run more than once.
+func (storage *Badger) update(fn func(txn *badger.Txn) error) (err error) {
+	for i := 0; i < DefaultTxnRetries; i++ {
+		if i > 0 {
+			RetryBackoff(i)
+		}
+		err = storage.DB.Update(fn)
+		if err != badger.ErrConflict {
+			return err
+		}
+	}
+	return err
+}
+
+// applyOperation applies a single batch operation to txn
+func applyOperation(txn *badger.Txn, op *interfaces.Operation) error {
+	switch op.Op {
+	case interfaces.OpSet:
+		return txn.SetEntry(newEntry(op.Key, op.Value, op.TTL))
+	case interfaces.OpDel:
+		return txn.Delete([]byte(op.Key))
+	case interfaces.OpIncr:
+		_, err := incrementCounter(txn, op.Key, op.Delta)
+		return err
+	case interfaces.OpCheckEquals, interfaces.OpCheckAbsent, interfaces.OpCheckExists:
+		return checkOperation(txn, op)
+	}
+	return nil
+}
+
+// checkOperation tests a precondition operation against txn
+func checkOperation(txn *badger.Txn, op *interfaces.Operation) error {
+	item, err := txn.Get([]byte(op.Key))
+	if err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	exists := err == nil
+
+	var value []byte
+	if exists && op.Op == interfaces.OpCheckEquals {
+		value, err = item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !checkHolds(op, value, exists) {
+		return &interfaces.ErrPreconditionFailed{Key: op.Key, Op: op.Op}
+	}
+	return nil
+}
+
+// checkHolds reports whether a precondition operation holds for the current value of its key
+func checkHolds(op *interfaces.Operation, value []byte, exists bool) bool {
+	switch op.Op {
+	case interfaces.OpCheckAbsent:
+		return !exists
+	case interfaces.OpCheckExists:
+		return exists
+	case interfaces.OpCheckEquals:
+		return exists && bytes.Equal(value, op.Value)
+	}
+	return true
+}
+
 // Close properly closes badger database
 func (storage *Badger) Close() error {
 	return storage.DB.Close()
@@ -60,9 +163,43 @@ func (storage *Badger) Close() error {
 // Set adds a key-value pair to the database
 func (storage *Badger) Set(key string, value []byte) (err error) {
 	return storage.DB.Update(func(txn *badger.Txn) error {
-		err := txn.Set([]byte(key), value)
+		err := txn.SetEntry(newEntry(key, value, 0))
+		return err
+	})
+}
+
+// SetWithTTL adds a key-value pair to the database which expires after ttl
+func (storage *Badger) SetWithTTL(key string, value []byte, ttl time.Duration) (err error) {
+	return storage.DB.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(newEntry(key, value, ttl))
+	})
+}
+
+// TTL returns the remaining time to live of a key, zero means the key never expires
+func (storage *Badger) TTL(key string) (ttl time.Duration, err error) {
+	err = storage.DB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		ttl = remainingTTL(item.ExpiresAt())
+		return nil
+	})
+	return
+}
+
+// getExpiring returns the value of key along with whether it expires, read at once
+func (storage *Badger) getExpiring(key string) (value []byte, expiring bool, err error) {
+	err = storage.DB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		expiring = item.ExpiresAt() != 0
+		value, err = item.ValueCopy(nil)
 		return err
 	})
+	return
 }
 
 // Del deletes a key
@@ -229,6 +366,34 @@ func (storage *Badger) IterateByPrefixFrom(prefix []byte, from []byte, limit uin
 	return totalIterated
 }
 
+// newEntry returns a badger entry for key, expiring after ttl if it's positive
+// The entry is flagged with metaSet so watchers can tell empty values from deletes
+func newEntry(key string, value []byte, ttl time.Duration) *badger.Entry {
+	entry := badger.NewEntry([]byte(key), value).WithMeta(metaSet)
+	if ttl > 0 {
+		entry.ExpiresAt = expiresAt(time.Now().Add(ttl))
+	}
+	return entry
+}
+
+// expiresAt returns the badger expiry of an entry expiring at t, badger expires entries to the second so t is
+// rounded up to the next second, entries live at least their ttl and at most a second more
+func expiresAt(t time.Time) uint64 {
+	seconds := t.Unix()
+	if t.Nanosecond() > 0 {
+		seconds++
+	}
+	return uint64(seconds)
+}
+
+// remainingTTL converts a badger expiry timestamp into the time left until it expires
+func remainingTTL(expiresAt uint64) time.Duration {
+	if expiresAt == 0 {
+		return 0
+	}
+	return time.Until(time.Unix(int64(expiresAt), 0))
+}
+
 func (storage *Badger) runStorageGC() {
 	timer := time.NewTicker(10 * time.Minute)
 	for {
@@ -0,0 +1,23 @@
+package db_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/xurwxj/kvdb/db"
+)
+
+// utilities
+func testWrap(t *testing.T, tests func(storage *db.Badger, t *testing.T)) {
+	dir, err := ioutil.TempDir("", "kvdb-")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := db.NewBadger(dir)
+	defer storage.Close()
+
+	tests(storage, t)
+}
@@ -0,0 +1,237 @@
+package db_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+func TestProcessBatchPreconditions(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		if err := storage.Set("config:version", []byte("1")); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+
+		tests := []struct {
+			name string
+			op   *interfaces.Operation
+			pass bool
+		}{
+			{"Equals", &interfaces.Operation{Key: "config:version", Value: []byte("1"), Op: interfaces.OpCheckEquals}, true},
+			{"Not Equals", &interfaces.Operation{Key: "config:version", Value: []byte("2"), Op: interfaces.OpCheckEquals}, false},
+			{"Equals Missing", &interfaces.Operation{Key: "missing", Value: []byte("1"), Op: interfaces.OpCheckEquals}, false},
+			{"Exists", &interfaces.Operation{Key: "config:version", Op: interfaces.OpCheckExists}, true},
+			{"Exists Missing", &interfaces.Operation{Key: "missing", Op: interfaces.OpCheckExists}, false},
+			{"Absent", &interfaces.Operation{Key: "missing", Op: interfaces.OpCheckAbsent}, true},
+			{"Absent Existing", &interfaces.Operation{Key: "config:version", Op: interfaces.OpCheckAbsent}, false},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				err := storage.ProcessBatch([]*interfaces.Operation{
+					{Key: "result", Value: []byte(tst.name), Op: interfaces.OpSet},
+					tst.op,
+					{Key: "config:data", Value: []byte(tst.name), Op: interfaces.OpSet},
+				})
+
+				value, getErr := storage.Get("config:data")
+				if tst.pass {
+					if err != nil {
+						t.Fatalf("Error processing batch: %s", err)
+					}
+					if string(value) != tst.name {
+						t.Fatalf("Batch was not applied, got %s wanted %s", value, tst.name)
+					}
+					return
+				}
+
+				failed, ok := err.(*interfaces.ErrPreconditionFailed)
+				if !ok {
+					t.Fatalf("Expected *ErrPreconditionFailed got %v", err)
+				}
+				if failed.Key != tst.op.Key || failed.Op != tst.op.Op {
+					t.Fatalf("Precondition error names %s %s wanted %s %s", failed.Op, failed.Key, tst.op.Op, tst.op.Key)
+				}
+				if getErr == nil && string(value) == tst.name {
+					t.Fatalf("Failed batch was partially applied")
+				}
+				if result, _ := storage.Get("result"); string(result) == tst.name {
+					t.Fatalf("Failed batch was partially applied")
+				}
+			})
+		}
+	})
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		swapped, err := storage.CompareAndSwap("leader", nil, []byte("a"))
+		if err != nil {
+			t.Fatalf("Error swapping: %s", err)
+		}
+		if !swapped {
+			t.Fatalf("Swap on absent key didn't happen")
+		}
+
+		swapped, err = storage.CompareAndSwap("leader", nil, []byte("b"))
+		if err != nil {
+			t.Fatalf("Error swapping: %s", err)
+		}
+		if swapped {
+			t.Fatalf("Swap on existing key happened when absent was expected")
+		}
+
+		swapped, err = storage.CompareAndSwap("leader", []byte("a"), []byte("b"))
+		if err != nil {
+			t.Fatalf("Error swapping: %s", err)
+		}
+		if !swapped {
+			t.Fatalf("Swap with matching value didn't happen")
+		}
+
+		value, err := storage.Get("leader")
+		if err != nil {
+			t.Fatalf("Error getting: %s", err)
+		}
+		if string(value) != "b" {
+			t.Fatalf("Got %s wanted b", value)
+		}
+	})
+}
+
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		winners := 0
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				swapped, err := storage.CompareAndSwap("leader", nil, []byte{byte(i)})
+				if err != nil {
+					t.Errorf("Error swapping: %s", err)
+					return
+				}
+				if swapped {
+					mu.Lock()
+					winners++
+					mu.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		if winners != 1 {
+			t.Fatalf("%d goroutines won the election wanted 1", winners)
+		}
+
+		if _, err := storage.Get("leader"); err == badger.ErrKeyNotFound {
+			t.Fatalf("Leader key was not written")
+		}
+	})
+}
+
+func TestProcessBatchSplit(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		// too big for a single transaction
+		value := bytes.Repeat([]byte("v"), 512<<10)
+		batch := make([]*interfaces.Operation, 32)
+		for i := range batch {
+			batch[i] = &interfaces.Operation{Key: fmt.Sprintf("big:%02d", i), Value: value, Op: interfaces.OpSet}
+		}
+
+		if err := storage.ProcessBatch(batch); err != badger.ErrTxnTooBig {
+			t.Fatalf("Got %v processing the batch at once wanted %s", err, badger.ErrTxnTooBig)
+		}
+
+		_, err := db.ProcessBatchSplit(storage, batch, db.SplitOptions{Atomic: true})
+		if err != db.ErrAtomicityRequired {
+			t.Fatalf("Got %v processing an atomic batch wanted %s", err, db.ErrAtomicityRequired)
+		}
+
+		stop := errors.New("stop")
+		done, err := db.ProcessBatchSplit(storage, batch, db.SplitOptions{
+			Progress: func(done int) error {
+				return stop
+			},
+		})
+		if err != stop {
+			t.Fatalf("Got %v wanted the error returned by progress", err)
+		}
+		if done == 0 || done == len(batch) {
+			t.Fatalf("Got %d operations done after the first commit", done)
+		}
+		if count := storage.KeysByPrefixCount([]byte("big:")); count != uint64(done) {
+			t.Fatalf("Got %d keys after the first commit wanted %d", count, done)
+		}
+
+		done, err = db.ProcessBatchSplit(storage, batch, db.SplitOptions{Resume: done})
+		if err != nil {
+			t.Fatalf("Error resuming batch: %s", err)
+		}
+		if done != len(batch) {
+			t.Fatalf("Got %d operations done wanted %d", done, len(batch))
+		}
+		if count := storage.KeysByPrefixCount([]byte("big:")); count != uint64(len(batch)) {
+			t.Fatalf("Got %d keys wanted %d", count, len(batch))
+		}
+
+		checked := append([]*interfaces.Operation{{Key: "big:00", Op: interfaces.OpCheckExists}}, batch...)
+		_, err = db.ProcessBatchSplit(storage, checked, db.SplitOptions{MaxOps: 4})
+		if err != db.ErrAtomicityRequired {
+			t.Fatalf("Got %v splitting a batch with checks wanted %s", err, db.ErrAtomicityRequired)
+		}
+
+		// a batch with checks fitting in one transaction is committed whole, whatever MaxOps says
+		small := []*interfaces.Operation{{Key: "big:00", Op: interfaces.OpCheckExists}}
+		for i := 0; i < 8; i++ {
+			small = append(small, &interfaces.Operation{Key: fmt.Sprintf("small:%d", i), Value: []byte("v"),
+				Op: interfaces.OpSet})
+		}
+		done, err = db.ProcessBatchSplit(storage, small, db.SplitOptions{MaxOps: 4})
+		if err != nil || done != len(small) {
+			t.Fatalf("Got %d, %v processing a batch with checks wanted %d", done, err, len(small))
+		}
+		if count := storage.KeysByPrefixCount([]byte("small:")); count != 8 {
+			t.Fatalf("Got %d keys wanted 8", count)
+		}
+	})
+}
+
+func TestDeleteByPrefixSplit(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		// long keys, so the deletes don't fit in one transaction
+		padding := strings.Repeat("k", 200)
+		batch := make([]*interfaces.Operation, 0, 1000)
+		for i := 0; i < 110000; i++ {
+			batch = append(batch, &interfaces.Operation{Key: fmt.Sprintf("purge:%s:%06d", padding, i), Op: interfaces.OpSet})
+			if len(batch) == cap(batch) {
+				if err := storage.ProcessBatch(batch); err != nil {
+					t.Fatalf("Error processing batch: %s", err)
+				}
+				batch = batch[:0]
+			}
+		}
+
+		if _, err := db.DeleteByPrefixSplit(storage, []byte("purge:"), db.SplitOptions{Resume: 10}); err != db.ErrResumePrefix {
+			t.Fatalf("Got %v resuming a prefix delete wanted %s", err, db.ErrResumePrefix)
+		}
+
+		deleted, err := db.DeleteByPrefixSplit(storage, []byte("purge:"), db.SplitOptions{})
+		if err != nil || deleted != 110000 {
+			t.Fatalf("Deleted %d, %v wanted 110000", deleted, err)
+		}
+		if count := storage.KeysByPrefixCount([]byte("purge:")); count != 0 {
+			t.Fatalf("Got %d keys after deleting the prefix wanted 0", count)
+		}
+	})
+}
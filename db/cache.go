@@ -0,0 +1,249 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// DefaultCacheSize is the size in bytes of a cache when no size is given
+const DefaultCacheSize = 64 << 20
+
+// CacheOptions allows you to change how values are cached
+// MaxBytes bounds the total size of the cached keys and values. With CacheMisses, keys that aren't found
+// are cached too, so repeated gets of missing keys don't reach the storage either.
+type CacheOptions struct {
+	MaxBytes    int64
+	CacheMisses bool
+}
+
+// notFound is the cached value of a missing key
+type notFound struct{}
+
+// expiringGetter is implemented by the storages reading a value and whether it expires at once
+type expiringGetter interface {
+	getExpiring(key string) (value []byte, expiring bool, err error)
+}
+
+// Cached is a DbStorage decorator caching the values read by Get
+// Every write made through the decorator invalidates the keys it touches, writes made to the wrapped
+// storage directly aren't seen and leave stale values in the cache.
+type Cached struct {
+	storage interfaces.DbStorage
+	lru     *LRU
+	misses  bool
+}
+
+// WithCache returns storage with a read-through cache in front of Get
+// Closing the decorator closes the wrapped storage.
+func WithCache(storage interfaces.DbStorage, opts CacheOptions) *Cached {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultCacheSize
+	}
+
+	return &Cached{
+		storage: storage,
+		lru:     NewLRU(opts.MaxBytes),
+		misses:  opts.CacheMisses,
+	}
+}
+
+// Stats returns the hit and miss statistics of the cache
+func (c *Cached) Stats() CacheStats {
+	return c.lru.Stats()
+}
+
+// Set adds a key-value pair to the database
+func (c *Cached) Set(key string, value []byte) (err error) {
+	defer c.lru.Invalidate(key)
+	return c.storage.Set(key, value)
+}
+
+// SetWithTTL adds a key-value pair to the database which expires after ttl
+// The value isn't cached, so it can't outlive its ttl in the cache
+func (c *Cached) SetWithTTL(key string, value []byte, ttl time.Duration) (err error) {
+	defer c.lru.Invalidate(key)
+	return c.storage.SetWithTTL(key, value, ttl)
+}
+
+// TTL returns the remaining time to live of a key
+func (c *Cached) TTL(key string) (ttl time.Duration, err error) {
+	return c.storage.TTL(key)
+}
+
+// Del deletes a key
+func (c *Cached) Del(key string) (err error) {
+	defer c.lru.Invalidate(key)
+	return c.storage.Del(key)
+}
+
+// Get returns value by key, from the cache if it's there
+func (c *Cached) Get(key string) (value []byte, err error) {
+	if cached, ok := c.lru.Get(key); ok {
+		if _, missing := cached.(notFound); missing {
+			return nil, badger.ErrKeyNotFound
+		}
+		return append([]byte(nil), cached.([]byte)...), nil
+	}
+
+	reservation := c.lru.Reserve(key)
+	value, expiring, err := readExpiring(c.storage, key)
+	if err == badger.ErrKeyNotFound && c.misses {
+		c.lru.Add(key, notFound{}, int64(len(key)), reservation)
+		return nil, err
+	}
+	if err != nil {
+		c.lru.Release(key, reservation)
+		return nil, err
+	}
+
+	// values expiring are never cached, they would outlive their ttl
+	if !expiring {
+		c.lru.Add(key, append([]byte(nil), value...), int64(len(key)+len(value)), reservation)
+	} else {
+		c.lru.Release(key, reservation)
+	}
+
+	return value, nil
+}
+
+// readExpiring returns the value of key and whether it expires, in a single read of the storages which can
+func readExpiring(storage interfaces.DbStorage, key string) (value []byte, expiring bool, err error) {
+	if getter, ok := storage.(expiringGetter); ok {
+		return getter.getExpiring(key)
+	}
+
+	value, err = storage.Get(key)
+	if err != nil {
+		return nil, false, err
+	}
+	ttl, err := storage.TTL(key)
+	return value, err != nil || ttl != 0, nil
+}
+
+// Iterate iterates over all keys
+func (c *Cached) Iterate(fn func(key []byte, value []byte)) {
+	c.storage.Iterate(fn)
+}
+
+// IterateByPrefix iterates over keys with prefix
+func (c *Cached) IterateByPrefix(prefix []byte, limit uint64, fn func(key []byte, value []byte)) uint64 {
+	return c.storage.IterateByPrefix(prefix, limit, fn)
+}
+
+// IterateByPrefixFrom iterates over keys with prefix starting at from
+func (c *Cached) IterateByPrefixFrom(prefix []byte, from []byte, limit uint64,
+	fn func(key []byte, value []byte)) uint64 {
+	return c.storage.IterateByPrefixFrom(prefix, from, limit, fn)
+}
+
+// IterateRange iterates over the keys between start and end
+func (c *Cached) IterateRange(start, end []byte, opts interfaces.RangeOptions,
+	fn func(key []byte, value []byte)) uint64 {
+	return c.storage.IterateRange(start, end, opts, fn)
+}
+
+// DeleteByPrefix deletes the keys with prefix and empties the cache
+func (c *Cached) DeleteByPrefix(prefix []byte) {
+	defer c.lru.Purge()
+	c.storage.DeleteByPrefix(prefix)
+}
+
+// Watch calls fn with the changes of the keys with any of the prefixes
+func (c *Cached) Watch(ctx context.Context, prefixes [][]byte, opts interfaces.WatchOptions,
+	fn func(events []interfaces.Event) error) error {
+	return c.storage.Watch(ctx, prefixes, opts, fn)
+}
+
+// KeysByPrefixCount counts the keys with prefix
+func (c *Cached) KeysByPrefixCount(prefix []byte) uint64 {
+	return c.storage.KeysByPrefixCount(prefix)
+}
+
+// ProcessBatch process batch of operations and invalidates the keys they write
+func (c *Cached) ProcessBatch(batch []*interfaces.Operation) (err error) {
+	defer func() {
+		for _, op := range batch {
+			c.lru.Invalidate(op.Key)
+		}
+	}()
+	return c.storage.ProcessBatch(batch)
+}
+
+// CompareAndSwap sets key to new only if it currently holds old
+func (c *Cached) CompareAndSwap(key string, old, new []byte) (swapped bool, err error) {
+	defer c.lru.Invalidate(key)
+	return c.storage.CompareAndSwap(key, old, new)
+}
+
+// Increment adds delta to the int64 counter stored in key and returns the new value
+func (c *Cached) Increment(key string, delta int64) (value int64, err error) {
+	defer c.lru.Invalidate(key)
+	return c.storage.Increment(key, delta)
+}
+
+// Merge returns a Merger for key, adding to it invalidates key
+func (c *Cached) Merge(key string, fn interfaces.MergeFunc, interval time.Duration) interfaces.Merger {
+	return &cachedMerger{Merger: c.storage.Merge(key, fn, interval), key: key, lru: c.lru}
+}
+
+// Begin starts a new transaction, its reads bypass the cache and the keys it writes are invalidated
+// once it's committed
+func (c *Cached) Begin(writable bool) (interfaces.Txn, error) {
+	txn, err := c.storage.Begin(writable)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedTxn{Txn: txn, lru: c.lru}, nil
+}
+
+// Close closes the wrapped storage
+func (c *Cached) Close() error {
+	c.lru.Purge()
+	return c.storage.Close()
+}
+
+// cachedMerger invalidates its key whenever a value is added
+type cachedMerger struct {
+	interfaces.Merger
+	key string
+	lru *LRU
+}
+
+func (m *cachedMerger) Add(value []byte) error {
+	defer m.lru.Invalidate(m.key)
+	return m.Merger.Add(value)
+}
+
+// cachedTxn tracks the keys written by a transaction to invalidate them on commit
+type cachedTxn struct {
+	interfaces.Txn
+	lru     *LRU
+	written []string
+}
+
+func (t *cachedTxn) Set(key string, value []byte) (err error) {
+	t.written = append(t.written, key)
+	return t.Txn.Set(key, value)
+}
+
+func (t *cachedTxn) SetWithTTL(key string, value []byte, ttl time.Duration) (err error) {
+	t.written = append(t.written, key)
+	return t.Txn.SetWithTTL(key, value, ttl)
+}
+
+func (t *cachedTxn) Del(key string) (err error) {
+	t.written = append(t.written, key)
+	return t.Txn.Del(key)
+}
+
+func (t *cachedTxn) Commit() error {
+	defer func() {
+		for _, key := range t.written {
+			t.lru.Invalidate(key)
+		}
+	}()
+	return t.Txn.Commit()
+}
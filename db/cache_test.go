@@ -0,0 +1,121 @@
+package db_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+func TestCached(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		cached := db.WithCache(storage, db.CacheOptions{CacheMisses: true})
+
+		if err := cached.Set("key", []byte("one")); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+
+		for i := 0; i < 3; i++ {
+			value, err := cached.Get("key")
+			if err != nil {
+				t.Fatalf("Error getting: %s", err)
+			}
+			if !bytes.Equal(value, []byte("one")) {
+				t.Fatalf("Got %q wanted %q", value, "one")
+			}
+		}
+
+		stats := cached.Stats()
+		if stats.Hits != 2 || stats.Misses != 1 {
+			t.Fatalf("Got %d hits and %d misses wanted 2 and 1", stats.Hits, stats.Misses)
+		}
+
+		err := cached.ProcessBatch([]*interfaces.Operation{{Key: "key", Value: []byte("two"), Op: interfaces.OpSet}})
+		if err != nil {
+			t.Fatalf("Error processing batch: %s", err)
+		}
+		value, err := cached.Get("key")
+		if err != nil {
+			t.Fatalf("Error getting: %s", err)
+		}
+		if !bytes.Equal(value, []byte("two")) {
+			t.Fatalf("Got stale value %q after a write", value)
+		}
+
+		txn, err := cached.Begin(true)
+		if err != nil {
+			t.Fatalf("Error beginning txn: %s", err)
+		}
+		if err = txn.Del("key"); err != nil {
+			t.Fatalf("Error deleting in txn: %s", err)
+		}
+		if err = txn.Commit(); err != nil {
+			t.Fatalf("Error committing: %s", err)
+		}
+
+		for i := 0; i < 2; i++ {
+			_, err = cached.Get("key")
+			if err != badger.ErrKeyNotFound {
+				t.Fatalf("Got %v getting a deleted key wanted %s", err, badger.ErrKeyNotFound)
+			}
+		}
+		if hits := cached.Stats().Hits; hits != 3 {
+			t.Fatalf("Missing key wasn't cached, got %d hits wanted 3", hits)
+		}
+
+		if err = cached.SetWithTTL("ttl", []byte("short"), time.Hour); err != nil {
+			t.Fatalf("Error setting with ttl: %s", err)
+		}
+		if _, err = cached.Get("ttl"); err != nil {
+			t.Fatalf("Error getting: %s", err)
+		}
+		before := cached.Stats().Hits
+		if _, err = cached.Get("ttl"); err != nil {
+			t.Fatalf("Error getting: %s", err)
+		}
+		if cached.Stats().Hits != before {
+			t.Fatalf("Value with a ttl was cached")
+		}
+	})
+}
+
+func TestLRUEviction(t *testing.T) {
+	lru := db.NewLRU(10)
+
+	for _, key := range []string{"a", "b", "c"} {
+		lru.Add(key, key, 4, lru.Reserve(key))
+	}
+
+	if _, ok := lru.Get("a"); ok {
+		t.Fatalf("Least recently used key wasn't evicted")
+	}
+	if _, ok := lru.Get("c"); !ok {
+		t.Fatalf("Most recently used key was evicted")
+	}
+
+	stats := lru.Stats()
+	if stats.Evictions != 1 || stats.Entries != 2 || stats.Bytes != 8 {
+		t.Fatalf("Got stats %+v wanted 1 eviction, 2 entries and 8 bytes", stats)
+	}
+
+	reservation := lru.Reserve("d")
+	lru.Invalidate("d")
+	lru.Add("d", "stale", 1, reservation)
+	if _, ok := lru.Get("d"); ok {
+		t.Fatalf("Value read before an invalidation was cached")
+	}
+
+	lru.Pin("e", time.Minute)
+	lru.Add("e", "e", 1, lru.Reserve("e"))
+	if _, ok := lru.Get("e"); ok {
+		t.Fatalf("Pinned key was cached")
+	}
+	lru.Unpin("e")
+	lru.Add("e", "e", 1, lru.Reserve("e"))
+	if _, ok := lru.Get("e"); !ok {
+		t.Fatalf("Unpinned key wasn't cached")
+	}
+}
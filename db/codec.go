@@ -0,0 +1,390 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// codecStorage is a DbStorage passing every value through an encode and decode func on its way to
+// and from the wrapped storage, it's the base of the value transforming decorators
+// Values which can't be decoded are skipped by iterations and watchers, and fail Get.
+type codecStorage struct {
+	storage interfaces.DbStorage
+	// encode and decode get the key stored in the wrapped storage along with the value
+	encode func(key, value []byte) ([]byte, error)
+	decode func(key, value []byte) ([]byte, error)
+	// mapKey returns the key stored in the wrapped storage for a key, keys are stored as is if it's nil
+	mapKey func(key string) string
+}
+
+// Set adds a key-value pair to the database
+func (c *codecStorage) Set(key string, value []byte) (err error) {
+	return c.SetWithTTL(key, value, 0)
+}
+
+// SetWithTTL adds a key-value pair to the database which expires after ttl
+func (c *codecStorage) SetWithTTL(key string, value []byte, ttl time.Duration) (err error) {
+	k := c.key(key)
+	encoded, err := c.encode([]byte(k), value)
+	if err != nil {
+		return err
+	}
+	if ttl > 0 {
+		return c.storage.SetWithTTL(k, encoded, ttl)
+	}
+	return c.storage.Set(k, encoded)
+}
+
+// TTL returns the remaining time to live of a key
+func (c *codecStorage) TTL(key string) (ttl time.Duration, err error) {
+	return c.storage.TTL(c.key(key))
+}
+
+// getExpiring returns the decoded value by key along with whether it expires
+func (c *codecStorage) getExpiring(key string) (value []byte, expiring bool, err error) {
+	k := c.key(key)
+	encoded, expiring, err := readExpiring(c.storage, k)
+	if err != nil {
+		return nil, false, err
+	}
+	value, err = c.decode([]byte(k), encoded)
+	return value, expiring, err
+}
+
+// Del deletes a key
+func (c *codecStorage) Del(key string) (err error) {
+	return c.storage.Del(c.key(key))
+}
+
+// Get returns the decoded value by key
+func (c *codecStorage) Get(key string) (value []byte, err error) {
+	k := c.key(key)
+	encoded, err := c.storage.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	return c.decode([]byte(k), encoded)
+}
+
+// Iterate iterates over all keys
+func (c *codecStorage) Iterate(fn func(key []byte, value []byte)) {
+	c.storage.Iterate(c.decoded(fn))
+}
+
+// IterateByPrefix iterates over keys with prefix
+// Values which can't be decoded are skipped, they don't count toward limit or the number returned.
+func (c *codecStorage) IterateByPrefix(prefix []byte, limit uint64, fn func(key []byte, value []byte)) uint64 {
+	return c.IterateByPrefixFrom(prefix, prefix, limit, fn)
+}
+
+// IterateByPrefixFrom iterates over keys with prefix starting at from
+func (c *codecStorage) IterateByPrefixFrom(prefix []byte, from []byte, limit uint64,
+	fn func(key []byte, value []byte)) uint64 {
+	var passed uint64
+	for {
+		want := remaining(limit, passed)
+		var last []byte
+		iterated := c.storage.IterateByPrefixFrom(prefix, from, want, c.counted(fn, &passed, &last))
+		if limit == 0 || passed >= limit || iterated < want {
+			return passed
+		}
+		// values were skipped, carry on after the last key
+		from = append(append([]byte(nil), last...), 0)
+	}
+}
+
+// IterateRange iterates over the keys between start and end
+func (c *codecStorage) IterateRange(start, end []byte, opts interfaces.RangeOptions,
+	fn func(key []byte, value []byte)) uint64 {
+	if opts.KeysOnly {
+		return c.storage.IterateRange(start, end, opts, fn)
+	}
+
+	limit := opts.Limit
+	var passed uint64
+	for {
+		opts.Limit = remaining(limit, passed)
+		var last []byte
+		iterated := c.storage.IterateRange(start, end, opts, c.counted(fn, &passed, &last))
+		if limit == 0 || passed >= limit || iterated < opts.Limit {
+			return passed
+		}
+		// values were skipped, carry on past the last key
+		if opts.Reverse {
+			end, opts.IncludeEnd = last, false
+		} else {
+			start, opts.ExcludeStart = last, true
+		}
+	}
+}
+
+// DeleteByPrefix deletes the keys with prefix
+func (c *codecStorage) DeleteByPrefix(prefix []byte) {
+	c.storage.DeleteByPrefix(prefix)
+}
+
+// Watch calls fn with the decoded changes of the keys with any of the prefixes
+func (c *codecStorage) Watch(ctx context.Context, prefixes [][]byte, opts interfaces.WatchOptions,
+	fn func(events []interfaces.Event) error) error {
+	return c.storage.Watch(ctx, prefixes, opts, func(events []interfaces.Event) error {
+		decoded := events[:0]
+		for _, event := range events {
+			if event.Op == interfaces.OpSet {
+				value, err := c.decode(event.Key, event.Value)
+				if err != nil {
+					continue
+				}
+				event.Value = value
+			}
+			decoded = append(decoded, event)
+		}
+		if len(decoded) == 0 {
+			return nil
+		}
+		return fn(decoded)
+	})
+}
+
+// KeysByPrefixCount counts the keys with prefix
+func (c *codecStorage) KeysByPrefixCount(prefix []byte) uint64 {
+	return c.storage.KeysByPrefixCount(prefix)
+}
+
+// ProcessBatch process batch of operations in a single transaction
+// Precondition values are compared against the decoded values
+func (c *codecStorage) ProcessBatch(batch []*interfaces.Operation) (err error) {
+	return RetryTxn(c.storage, 0, func(txn interfaces.Txn) error {
+		for _, op := range batch {
+			k := c.key(op.Key)
+			switch op.Op {
+			case interfaces.OpSet:
+				encoded, err := c.encode([]byte(k), op.Value)
+				if err != nil {
+					return err
+				}
+				if err = txn.SetWithTTL(k, encoded, op.TTL); err != nil {
+					return err
+				}
+			case interfaces.OpDel:
+				if err := txn.Del(k); err != nil {
+					return err
+				}
+			case interfaces.OpIncr:
+				if _, err := c.increment(txn, k, op.Delta); err != nil {
+					return err
+				}
+			case interfaces.OpCheckEquals, interfaces.OpCheckAbsent, interfaces.OpCheckExists:
+				value, err := c.txnGet(txn, k)
+				if err != nil && err != badger.ErrKeyNotFound {
+					return err
+				}
+				if !checkHolds(op, value, err == nil) {
+					return &interfaces.ErrPreconditionFailed{Key: op.Key, Op: op.Op}
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// CompareAndSwap sets key to new only if its decoded value is old, a nil old means the key must not exist
+func (c *codecStorage) CompareAndSwap(key string, old, new []byte) (swapped bool, err error) {
+	return compareAndSwap(c, key, old, new)
+}
+
+// Increment adds delta to the int64 counter stored in key and returns the new value
+func (c *codecStorage) Increment(key string, delta int64) (value int64, err error) {
+	err = RetryTxn(c.storage, 0, func(txn interfaces.Txn) error {
+		var err error
+		value, err = c.increment(txn, c.key(key), delta)
+		return err
+	})
+	return
+}
+
+// Merge returns a Merger for key, fn is called with decoded values
+func (c *codecStorage) Merge(key string, fn interfaces.MergeFunc, interval time.Duration) interfaces.Merger {
+	k := []byte(c.key(key))
+	decode := func(value []byte) []byte {
+		if decoded, err := c.decode(k, value); err == nil {
+			return decoded
+		}
+		return value
+	}
+
+	merger := c.storage.Merge(string(k), func(existing, value []byte) []byte {
+		encoded, err := c.encode(k, fn(decode(existing), decode(value)))
+		if err != nil {
+			return existing
+		}
+		return encoded
+	}, interval)
+
+	return &codecMerger{merger: merger, key: k, c: c}
+}
+
+// Begin starts a new transaction encoding the values it reads and writes
+func (c *codecStorage) Begin(writable bool) (interfaces.Txn, error) {
+	txn, err := c.storage.Begin(writable)
+	if err != nil {
+		return nil, err
+	}
+	return &codecTxn{txn: txn, c: c}, nil
+}
+
+// Close closes the wrapped storage
+func (c *codecStorage) Close() error {
+	return c.storage.Close()
+}
+
+// key returns the key stored in the wrapped storage for key
+func (c *codecStorage) key(key string) string {
+	if c.mapKey == nil {
+		return key
+	}
+	return c.mapKey(key)
+}
+
+// decoded returns an iteration callback decoding values before passing them to fn
+func (c *codecStorage) decoded(fn func(key []byte, value []byte)) func(key []byte, value []byte) {
+	return func(key []byte, encoded []byte) {
+		value, err := c.decode(key, encoded)
+		if err != nil {
+			return
+		}
+		fn(key, value)
+	}
+}
+
+// counted returns an iteration callback like decoded, counting the values passed to fn in passed and keeping
+// the last key iterated in last
+func (c *codecStorage) counted(fn func(key []byte, value []byte), passed *uint64,
+	last *[]byte) func(key []byte, value []byte) {
+	decoded := c.decoded(func(key []byte, value []byte) {
+		*passed++
+		fn(key, value)
+	})
+	return func(key []byte, encoded []byte) {
+		*last = key
+		decoded(key, encoded)
+	}
+}
+
+// remaining returns the limit left of an iteration once passed values were iterated, zero if there's no limit
+func remaining(limit, passed uint64) uint64 {
+	if limit == 0 {
+		return 0
+	}
+	return limit - passed
+}
+
+// txnGet returns the decoded value of the stored key k within txn
+func (c *codecStorage) txnGet(txn interfaces.Txn, k string) ([]byte, error) {
+	encoded, err := txn.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	return c.decode([]byte(k), encoded)
+}
+
+// increment adds delta to the encoded counter of the stored key k within txn
+func (c *codecStorage) increment(txn interfaces.Txn, k string, delta int64) (int64, error) {
+	var value int64
+
+	current, err := c.txnGet(txn, k)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return 0, err
+	}
+	if err == nil {
+		value, err = DecodeInt64(current)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	value += delta
+
+	encoded, err := c.encode([]byte(k), EncodeInt64(value))
+	if err != nil {
+		return 0, err
+	}
+	return value, txn.Set(k, encoded)
+}
+
+// codecMerger encodes the values added to a merger and decodes its merged value
+type codecMerger struct {
+	merger interfaces.Merger
+	key    []byte
+	c      *codecStorage
+}
+
+func (m *codecMerger) Add(value []byte) error {
+	encoded, err := m.c.encode(m.key, value)
+	if err != nil {
+		return err
+	}
+	return m.merger.Add(encoded)
+}
+
+func (m *codecMerger) Get() ([]byte, error) {
+	encoded, err := m.merger.Get()
+	if err != nil {
+		return nil, err
+	}
+	return m.c.decode(m.key, encoded)
+}
+
+func (m *codecMerger) Stop() {
+	m.merger.Stop()
+}
+
+// codecTxn is a transaction of a codecStorage
+type codecTxn struct {
+	txn interfaces.Txn
+	c   *codecStorage
+}
+
+func (t *codecTxn) Get(key string) (value []byte, err error) {
+	return t.c.txnGet(t.txn, t.c.key(key))
+}
+
+func (t *codecTxn) Set(key string, value []byte) (err error) {
+	return t.SetWithTTL(key, value, 0)
+}
+
+func (t *codecTxn) SetWithTTL(key string, value []byte, ttl time.Duration) (err error) {
+	k := t.c.key(key)
+	encoded, err := t.c.encode([]byte(k), value)
+	if err != nil {
+		return err
+	}
+	return t.txn.SetWithTTL(k, encoded, ttl)
+}
+
+func (t *codecTxn) Del(key string) (err error) {
+	return t.txn.Del(t.c.key(key))
+}
+
+// IterateByPrefix iterates over keys with prefix, values which can't be decoded don't count toward limit
+// Transactions can't start an iteration past a key, so a limited one reads every key of the prefix.
+func (t *codecTxn) IterateByPrefix(prefix []byte, limit uint64, fn func(key []byte, value []byte)) uint64 {
+	var passed uint64
+	t.txn.IterateByPrefix(prefix, 0, t.c.decoded(func(key []byte, value []byte) {
+		if limit == 0 || passed < limit {
+			fn(key, value)
+			passed++
+		}
+	}))
+	return passed
+}
+
+func (t *codecTxn) Commit() error {
+	return t.txn.Commit()
+}
+
+func (t *codecTxn) Discard() {
+	t.txn.Discard()
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io/ioutil"
+	"sync"
+
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// DefaultCompressionThreshold is the size in bytes below which values are stored uncompressed
+// when no threshold is given
+const DefaultCompressionThreshold = 256
+
+// headers of the values written by a Compressor
+const (
+	compressionNone  byte = 0
+	compressionFlate byte = 1
+)
+
+// ErrUnknownCompression is returned when a value doesn't start with a known compression header
+var ErrUnknownCompression = errors.New("value has an unknown compression header")
+
+// CompressionRule turns compression on or off for the keys starting with Prefix
+type CompressionRule struct {
+	Prefix   []byte
+	Compress bool
+}
+
+// CompressionOptions allows you to change how values are compressed
+// Level is the flate level, zero means flate.DefaultCompression. Values smaller than Threshold are never
+// compressed. Rules are tested in order and the first one matching the key of a value decides whether it's
+// compressed, values of keys matching no rule are compressed.
+type CompressionOptions struct {
+	Level     int
+	Threshold int
+	Rules     []CompressionRule
+}
+
+// Compressor compresses values with flate, every value it writes starts with a header byte so
+// compressed and uncompressed values can coexist
+type Compressor struct {
+	level     int
+	threshold int
+	rules     []CompressionRule
+	writers   sync.Pool
+}
+
+// NewCompressor returns a compressor for the options
+func NewCompressor(opts CompressionOptions) (*Compressor, error) {
+	c := &Compressor{
+		level:     opts.Level,
+		threshold: opts.Threshold,
+		rules:     opts.Rules,
+	}
+	if c.level == 0 {
+		c.level = flate.DefaultCompression
+	}
+	if c.threshold <= 0 {
+		c.threshold = DefaultCompressionThreshold
+	}
+
+	// validate the level once rather than on every write
+	if _, err := flate.NewWriter(ioutil.Discard, c.level); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Compress returns value prefixed by its compression header, compressed if the rules allow it for key
+// and if it's worth it
+func (c *Compressor) Compress(key, value []byte) ([]byte, error) {
+	if len(value) >= c.threshold && c.compresses(key) {
+		var buff bytes.Buffer
+		buff.WriteByte(compressionFlate)
+
+		w, _ := c.writers.Get().(*flate.Writer)
+		if w == nil {
+			var err error
+			w, err = flate.NewWriter(&buff, c.level)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			w.Reset(&buff)
+		}
+		defer c.writers.Put(w)
+
+		if _, err := w.Write(value); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+
+		if buff.Len() < len(value)+1 {
+			return buff.Bytes(), nil
+		}
+	}
+
+	return append([]byte{compressionNone}, value...), nil
+}
+
+// Decompress returns the original value of a value written by Compress
+func (c *Compressor) Decompress(key, value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return nil, ErrUnknownCompression
+	}
+
+	switch value[0] {
+	case compressionNone:
+		return append([]byte(nil), value[1:]...), nil
+	case compressionFlate:
+		r := flate.NewReader(bytes.NewReader(value[1:]))
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+
+	return nil, ErrUnknownCompression
+}
+
+// compresses reports whether the rules allow compressing the value of key
+func (c *Compressor) compresses(key []byte) bool {
+	for _, rule := range c.rules {
+		if bytes.HasPrefix(key, rule.Prefix) {
+			return rule.Compress
+		}
+	}
+	return true
+}
+
+// Compressed is a DbStorage decorator compressing values
+type Compressed struct {
+	*codecStorage
+}
+
+// WithCompression returns storage with every value compressed by compressor
+// Values written without the decorator lack a compression header, they are skipped by iterations and
+// watchers and fail Get with ErrUnknownCompression. Closing the decorator closes the wrapped storage.
+func WithCompression(storage interfaces.DbStorage, compressor *Compressor) *Compressed {
+	return &Compressed{
+		codecStorage: &codecStorage{
+			storage: storage,
+			encode:  compressor.Compress,
+			decode:  compressor.Decompress,
+		},
+	}
+}
@@ -0,0 +1,98 @@
+package db_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+func TestCompressed(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		compressor, err := db.NewCompressor(db.CompressionOptions{
+			Threshold: 16,
+			Rules: []db.CompressionRule{
+				{Prefix: []byte("media:"), Compress: false},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error creating compressor: %s", err)
+		}
+		compressed := db.WithCompression(storage, compressor)
+
+		document := bytes.Repeat([]byte("repetitive document "), 100)
+		tests := []struct {
+			name       string
+			key        string
+			value      []byte
+			compressed bool
+		}{
+			{"Repetitive", "doc:1", document, true},
+			{"Below Threshold", "doc:2", []byte("small"), false},
+			{"Rule Off", "media:1", document, false},
+			{"Empty", "doc:3", []byte{}, false},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				if err := compressed.Set(tst.key, tst.value); err != nil {
+					t.Fatalf("Error setting: %s", err)
+				}
+
+				raw, err := storage.Get(tst.key)
+				if err != nil {
+					t.Fatalf("Error getting raw value: %s", err)
+				}
+				if (len(raw) < len(tst.value)) != tst.compressed {
+					t.Fatalf("Stored %d bytes for a %d byte value, compressed wanted %t", len(raw),
+						len(tst.value), tst.compressed)
+				}
+
+				value, err := compressed.Get(tst.key)
+				if err != nil {
+					t.Fatalf("Error getting: %s", err)
+				}
+				if !bytes.Equal(value, tst.value) {
+					t.Fatalf("Value was not restored")
+				}
+			})
+		}
+
+		err = compressed.ProcessBatch([]*interfaces.Operation{
+			{Key: "doc:1", Value: document, Op: interfaces.OpCheckEquals},
+			{Key: "doc:count", Op: interfaces.OpIncr, Delta: 1},
+		})
+		if err != nil {
+			t.Fatalf("Error processing batch against compressed values: %s", err)
+		}
+
+		count, err := compressed.Increment("doc:count", 1)
+		if err != nil {
+			t.Fatalf("Error incrementing: %s", err)
+		}
+		if count != 2 {
+			t.Fatalf("Counter is %d wanted 2", count)
+		}
+
+		if err = storage.Set("doc:raw", []byte("raw")); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+		iterated := 0
+		compressed.IterateByPrefix([]byte("doc:"), 0, func(key []byte, value []byte) {
+			if string(key) == "doc:raw" {
+				t.Fatalf("Value without a compression header was iterated")
+			}
+			iterated++
+		})
+		if iterated != 4 {
+			t.Fatalf("Iterated %d values wanted 4", iterated)
+		}
+	})
+}
+
+func TestNewCompressorInvalidLevel(t *testing.T) {
+	if _, err := db.NewCompressor(db.CompressionOptions{Level: 42}); err == nil {
+		t.Fatalf("Compressor with an invalid level didn't fail!")
+	}
+}
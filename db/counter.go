@@ -0,0 +1,80 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// ErrNotCounter is returned when a counter operation finds a value that isn't an encoded int64
+var ErrNotCounter = errors.New("value is not an int64 counter")
+
+// Increment adds delta to the int64 counter stored in key and returns the new value
+// A missing key counts as zero. Concurrent increments never lose updates, a conflicting increment is retried
+// after a backoff like a batch, and badger.ErrConflict is returned once DefaultTxnRetries attempts conflicted.
+func (storage *Badger) Increment(key string, delta int64) (value int64, err error) {
+	err = storage.update(func(txn *badger.Txn) error {
+		var err error
+		value, err = incrementCounter(txn, key, delta)
+		return err
+	})
+	return value, err
+}
+
+// Merge returns a Merger for key backed by a badger merge operator, values added to it are
+// written without reading the key so they never conflict, and are merged with fn when read
+// and every interval in the background. Use AddInt64 to merge counters encoded by EncodeInt64.
+// Read a merged key through the Merger only, Get returns the last value added.
+func (storage *Badger) Merge(key string, fn interfaces.MergeFunc, interval time.Duration) interfaces.Merger {
+	return storage.DB.GetMergeOperator([]byte(key), badger.MergeFunc(fn), interval)
+}
+
+// EncodeInt64 encodes a counter value the way Increment stores it
+func EncodeInt64(value int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(value))
+	return b
+}
+
+// DecodeInt64 decodes a counter value stored by Increment
+func DecodeInt64(b []byte) (int64, error) {
+	if len(b) != 8 {
+		return 0, ErrNotCounter
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+// AddInt64 is a MergeFunc summing counters encoded by EncodeInt64
+// values that aren't counters are treated as zero
+func AddInt64(existing, value []byte) []byte {
+	a, _ := DecodeInt64(existing)
+	b, _ := DecodeInt64(value)
+	return EncodeInt64(a + b)
+}
+
+// incrementCounter adds delta to the counter in key within txn
+func incrementCounter(txn *badger.Txn, key string, delta int64) (int64, error) {
+	var value int64
+
+	item, err := txn.Get([]byte(key))
+	if err != nil && err != badger.ErrKeyNotFound {
+		return 0, err
+	}
+	if err == nil {
+		err = item.Value(func(v []byte) error {
+			var err error
+			value, err = DecodeInt64(v)
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	value += delta
+
+	return value, txn.SetEntry(newEntry(key, EncodeInt64(value), 0))
+}
@@ -0,0 +1,110 @@
+package db_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+func TestIncrementConcurrent(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		var wg sync.WaitGroup
+		workers, increments := 8, 25
+
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < increments; j++ {
+					if _, err := storage.Increment("hits", 1); err != nil {
+						t.Errorf("Error incrementing: %s", err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		value, err := storage.Increment("hits", 0)
+		if err != nil {
+			t.Fatalf("Error reading counter: %s", err)
+		}
+		if value != int64(workers*increments) {
+			t.Fatalf("Counter is %d wanted %d", value, workers*increments)
+		}
+	})
+}
+
+func TestIncrementNotCounter(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		if err := storage.Set("name", []byte("value")); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+
+		if _, err := storage.Increment("name", 1); err != db.ErrNotCounter {
+			t.Fatalf("Expected ErrNotCounter got %v", err)
+		}
+	})
+}
+
+func TestProcessBatchIncr(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		err := storage.ProcessBatch([]*interfaces.Operation{
+			{Key: "usage", Op: interfaces.OpIncr, Delta: 5},
+			{Key: "usage", Op: interfaces.OpIncr, Delta: -2},
+			{Key: "event", Value: []byte("x"), Op: interfaces.OpSet},
+		})
+		if err != nil {
+			t.Fatalf("Error processing batch: %s", err)
+		}
+
+		value, err := storage.Get("usage")
+		if err != nil {
+			t.Fatalf("Error getting counter: %s", err)
+		}
+		counter, err := db.DecodeInt64(value)
+		if err != nil {
+			t.Fatalf("Error decoding counter: %s", err)
+		}
+		if counter != 3 {
+			t.Fatalf("Counter is %d wanted 3", counter)
+		}
+	})
+}
+
+func TestMergeCounter(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		merger := storage.Merge("rate", db.AddInt64, 100*time.Millisecond)
+		defer merger.Stop()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					if err := merger.Add(db.EncodeInt64(2)); err != nil {
+						t.Errorf("Error adding to merger: %s", err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		value, err := merger.Get()
+		if err != nil {
+			t.Fatalf("Error getting merged value: %s", err)
+		}
+		counter, err := db.DecodeInt64(value)
+		if err != nil {
+			t.Fatalf("Error decoding counter: %s", err)
+		}
+		if counter != 200 {
+			t.Fatalf("Merged counter is %d wanted 200", counter)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// DefaultRotateBatchSize is the number of values Rotate re-encrypts per batch when no size is given
+const DefaultRotateBatchSize = 1000
+
+// EncryptionOptions allows you to change how an Encrypted storage stores keys
+// When HMACKey is set every key is replaced by the hex encoded HMAC-SHA256 of it, so no key material is
+// stored in plaintext. Keys can then only be read back by their exact value: prefixes passed to
+// iteration, counting and deletion match the stored keys and callbacks receive the stored keys.
+type EncryptionOptions struct {
+	HMACKey []byte
+}
+
+// Encrypted is a DbStorage decorator encrypting every value with AES-GCM
+type Encrypted struct {
+	*codecStorage
+	keyring *Keyring
+}
+
+// WithEncryption returns storage with every value sealed by keyring
+// Values which can't be opened, such as values written without the decorator, are skipped by iterations
+// and watchers and fail Get with ErrNotSealed. Closing the decorator closes the wrapped storage.
+func WithEncryption(storage interfaces.DbStorage, keyring *Keyring, opts EncryptionOptions) *Encrypted {
+	e := &Encrypted{
+		codecStorage: &codecStorage{
+			storage: storage,
+			encode:  keyring.Seal,
+			decode:  keyring.Open,
+		},
+		keyring: keyring,
+	}
+
+	if opts.HMACKey != nil {
+		e.mapKey = func(key string) string {
+			mac := hmac.New(sha256.New, opts.HMACKey)
+			mac.Write([]byte(key))
+			return hex.EncodeToString(mac.Sum(nil))
+		}
+	}
+
+	return e
+}
+
+// Rotate re-encrypts with the current key every value with prefix that was sealed with an older one,
+// batchSize values at a time, and returns the number of values re-encrypted.
+// Values written concurrently are left untouched, as they are already sealed with the current key.
+func (e *Encrypted) Rotate(prefix []byte, batchSize int) (rotated uint64, err error) {
+	if batchSize <= 0 {
+		batchSize = DefaultRotateBatchSize
+	}
+
+	from := prefix
+	for {
+		var batch []*interfaces.Operation
+		var last []byte
+
+		iterated := e.storage.IterateByPrefixFrom(prefix, from, uint64(batchSize), func(key []byte, sealed []byte) {
+			last = key
+			if id, err := e.keyring.KeyID(sealed); err != nil || id == e.keyring.Current() {
+				return
+			}
+			value, err := e.keyring.Open(key, sealed)
+			if err != nil {
+				return
+			}
+			resealed, err := e.keyring.Seal(key, value)
+			if err != nil {
+				return
+			}
+			ttl, err := e.storage.TTL(string(key))
+			if err != nil {
+				return
+			}
+
+			batch = append(batch,
+				&interfaces.Operation{Key: string(key), Value: sealed, Op: interfaces.OpCheckEquals},
+				&interfaces.Operation{Key: string(key), Value: resealed, Op: interfaces.OpSet, TTL: ttl},
+			)
+		})
+
+		for len(batch) > 0 {
+			err = e.storage.ProcessBatch(batch)
+			failed, ok := err.(*interfaces.ErrPreconditionFailed)
+			if !ok {
+				break
+			}
+			// the value changed since it was read, drop it from the batch and try again
+			batch = dropKey(batch, failed.Key)
+		}
+		if err != nil {
+			return rotated, err
+		}
+		rotated += uint64(len(batch) / 2)
+
+		if iterated < uint64(batchSize) {
+			return rotated, nil
+		}
+		from = append(last, 0)
+	}
+}
+
+// dropKey returns batch without the operations on key
+func dropKey(batch []*interfaces.Operation, key string) []*interfaces.Operation {
+	kept := batch[:0]
+	for _, op := range batch {
+		if op.Key != key {
+			kept = append(kept, op)
+		}
+	}
+	return kept
+}
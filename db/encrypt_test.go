@@ -0,0 +1,241 @@
+package db_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+func testKeyring(t *testing.T, current uint32) *db.Keyring {
+	keyring, err := db.NewKeyring(current, map[uint32][]byte{
+		1: bytes.Repeat([]byte{1}, 32),
+		2: bytes.Repeat([]byte{2}, 32),
+	})
+	if err != nil {
+		t.Fatalf("Error creating keyring: %s", err)
+	}
+	return keyring
+}
+
+func TestNewKeyringInvalid(t *testing.T) {
+	if _, err := db.NewKeyring(3, map[uint32][]byte{1: make([]byte, 32)}); err == nil {
+		t.Fatalf("Keyring without its current key didn't fail!")
+	}
+	if _, err := db.NewKeyring(1, map[uint32][]byte{1: make([]byte, 7)}); err == nil {
+		t.Fatalf("Keyring with an invalid key size didn't fail!")
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		encrypted := db.WithEncryption(storage, testKeyring(t, 1), db.EncryptionOptions{})
+
+		if err := encrypted.Set("secret", []byte("value")); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+
+		raw, err := storage.Get("secret")
+		if err != nil {
+			t.Fatalf("Error getting raw value: %s", err)
+		}
+		if bytes.Contains(raw, []byte("value")) {
+			t.Fatalf("Value is stored in plaintext")
+		}
+
+		value, err := encrypted.Get("secret")
+		if err != nil {
+			t.Fatalf("Error getting: %s", err)
+		}
+		if string(value) != "value" {
+			t.Fatalf("Got %s wanted value", value)
+		}
+
+		// values can't be moved to another key
+		if err = storage.Set("other", raw); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+		if _, err = encrypted.Get("other"); err == nil {
+			t.Fatalf("Opening a value moved to another key didn't fail!")
+		}
+
+		swapped, err := encrypted.CompareAndSwap("secret", []byte("value"), []byte("new"))
+		if err != nil {
+			t.Fatalf("Error swapping: %s", err)
+		}
+		if !swapped {
+			t.Fatalf("Swap against the decrypted value didn't happen")
+		}
+
+		counter, err := encrypted.Increment("counter", 2)
+		if err != nil {
+			t.Fatalf("Error incrementing: %s", err)
+		}
+		if counter != 2 {
+			t.Fatalf("Counter is %d wanted 2", counter)
+		}
+
+		var keys []string
+		encrypted.IterateByPrefix([]byte("secret"), 0, func(key []byte, value []byte) {
+			keys = append(keys, string(key))
+			if string(value) != "new" {
+				t.Fatalf("Iterated value %s wanted new", value)
+			}
+		})
+		if len(keys) != 1 {
+			t.Fatalf("Iterated %d keys wanted 1", len(keys))
+		}
+	})
+}
+
+func TestEncryptedIterateLimit(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		encrypted := db.WithEncryption(storage, testKeyring(t, 1), db.EncryptionOptions{})
+
+		// every other value can't be decrypted
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("item:%d", i)
+			var err error
+			if i%2 == 0 {
+				err = storage.Set(key, []byte("plain"))
+			} else {
+				err = encrypted.Set(key, []byte("secret"))
+			}
+			if err != nil {
+				t.Fatalf("Error setting: %s", err)
+			}
+		}
+
+		for _, limit := range []uint64{0, 3, 5, 6} {
+			wanted := limit
+			if limit == 0 || limit > 5 {
+				wanted = 5
+			}
+
+			calls := 0
+			count := encrypted.IterateByPrefix([]byte("item:"), limit, func(key []byte, value []byte) {
+				calls++
+			})
+			if count != wanted || calls != int(wanted) {
+				t.Fatalf("Iterated %d values and returned %d with limit %d wanted %d", calls, count, limit, wanted)
+			}
+
+			calls = 0
+			count = encrypted.IterateRange([]byte("item:"), nil, interfaces.RangeOptions{Reverse: true, Limit: limit},
+				func(key []byte, value []byte) {
+					calls++
+				})
+			if count != wanted || calls != int(wanted) {
+				t.Fatalf("Iterated %d values and returned %d in range with limit %d wanted %d", calls, count, limit,
+					wanted)
+			}
+
+			txn, err := encrypted.Begin(false)
+			if err != nil {
+				t.Fatalf("Error beginning txn: %s", err)
+			}
+			calls = 0
+			count = txn.IterateByPrefix([]byte("item:"), limit, func(key []byte, value []byte) {
+				calls++
+			})
+			txn.Discard()
+			if count != wanted || calls != int(wanted) {
+				t.Fatalf("Iterated %d values and returned %d in txn with limit %d wanted %d", calls, count, limit,
+					wanted)
+			}
+		}
+	})
+}
+
+func TestEncryptedHMACKeys(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		encrypted := db.WithEncryption(storage, testKeyring(t, 1), db.EncryptionOptions{
+			HMACKey: []byte("mac key"),
+		})
+
+		err := encrypted.ProcessBatch([]*interfaces.Operation{
+			{Key: "ssn:123-45-6789", Op: interfaces.OpCheckAbsent},
+			{Key: "ssn:123-45-6789", Value: []byte("user 1"), Op: interfaces.OpSet, TTL: time.Hour},
+		})
+		if err != nil {
+			t.Fatalf("Error processing batch: %s", err)
+		}
+
+		storage.Iterate(func(key []byte, value []byte) {
+			if bytes.Contains(key, []byte("123-45-6789")) {
+				t.Fatalf("Key is stored in plaintext")
+			}
+		})
+
+		value, err := encrypted.Get("ssn:123-45-6789")
+		if err != nil {
+			t.Fatalf("Error getting: %s", err)
+		}
+		if string(value) != "user 1" {
+			t.Fatalf("Got %s wanted user 1", value)
+		}
+
+		if ttl, err := encrypted.TTL("ssn:123-45-6789"); err != nil || ttl == 0 {
+			t.Fatalf("Ttl of hashed key is %s, %v", ttl, err)
+		}
+	})
+}
+
+func TestEncryptedRotate(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		retired, err := db.NewKeyring(1, map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+		if err != nil {
+			t.Fatalf("Error creating keyring: %s", err)
+		}
+		old := db.WithEncryption(storage, retired, db.EncryptionOptions{})
+		for _, key := range []string{"tenant:a", "tenant:b", "tenant:c"} {
+			if err := old.Set(key, []byte(key)); err != nil {
+				t.Fatalf("Error setting: %s", err)
+			}
+		}
+
+		keyring := testKeyring(t, 2)
+		current := db.WithEncryption(storage, keyring, db.EncryptionOptions{})
+		if err := current.Set("tenant:d", []byte("tenant:d")); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+
+		rotated, err := current.Rotate([]byte("tenant:"), 2)
+		if err != nil {
+			t.Fatalf("Error rotating: %s", err)
+		}
+		if rotated != 3 {
+			t.Fatalf("Rotated %d values wanted 3", rotated)
+		}
+
+		storage.IterateByPrefix([]byte("tenant:"), 0, func(key []byte, sealed []byte) {
+			id, err := keyring.KeyID(sealed)
+			if err != nil {
+				t.Fatalf("Error reading key id: %s", err)
+			}
+			if id != 2 {
+				t.Fatalf("Value of %s is sealed with key %d wanted 2", key, id)
+			}
+		})
+
+		value, err := current.Get("tenant:a")
+		if err != nil {
+			t.Fatalf("Error getting: %s", err)
+		}
+		if string(value) != "tenant:a" {
+			t.Fatalf("Got %s wanted tenant:a", value)
+		}
+
+		if _, err = old.Get("tenant:a"); err != db.ErrUnknownKeyID {
+			t.Fatalf("Expected ErrUnknownKeyID opening with a retired keyring got %v", err)
+		}
+
+		if _, err = current.Get("missing"); err != badger.ErrKeyNotFound {
+			t.Fatalf("Expected ErrKeyNotFound got %v", err)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// DefaultGroupWindow is how long writes wait for others to share their commit when no window is given
+const DefaultGroupWindow = 2 * time.Millisecond
+
+// DefaultGroupMaxOps is the number of writes committing a group right away when no size is given
+const DefaultGroupMaxOps = 1000
+
+// ErrGroupClosed is returned by writes made to a Grouped storage after Close
+var ErrGroupClosed = errors.New("group commit storage is closed")
+
+// GroupOptions allows you to change how writes are grouped
+// A group is committed Window after its first write, or as soon as it holds MaxOps writes.
+type GroupOptions struct {
+	Window time.Duration
+	MaxOps int
+}
+
+// Grouped is a DbStorage decorator committing concurrent Set, SetWithTTL and Del calls together
+// Each call still blocks until its write is committed and returns its own error, if a group fails its
+// writes are retried one by one so a bad write doesn't fail the others. Writes made by a single caller
+// keep their order, writes made concurrently are committed in any order.
+type Grouped struct {
+	interfaces.DbStorage
+	opts GroupOptions
+
+	mu       sync.Mutex
+	pending  []*groupWrite
+	timer    *time.Timer
+	closed   bool
+	inFlight sync.WaitGroup
+}
+
+type groupWrite struct {
+	op   *interfaces.Operation
+	done chan error
+}
+
+// WithGroupCommit returns storage with its single key writes committed in groups
+// Closing the decorator commits the pending writes and closes the wrapped storage.
+func WithGroupCommit(storage interfaces.DbStorage, opts GroupOptions) *Grouped {
+	if opts.Window <= 0 {
+		opts.Window = DefaultGroupWindow
+	}
+	if opts.MaxOps <= 0 {
+		opts.MaxOps = DefaultGroupMaxOps
+	}
+
+	return &Grouped{DbStorage: storage, opts: opts}
+}
+
+// Set adds a key-value pair to the database once its group is committed
+func (g *Grouped) Set(key string, value []byte) (err error) {
+	return g.write(&interfaces.Operation{Key: key, Value: value, Op: interfaces.OpSet})
+}
+
+// SetWithTTL adds a key-value pair to the database which expires after ttl once its group is committed
+func (g *Grouped) SetWithTTL(key string, value []byte, ttl time.Duration) (err error) {
+	return g.write(&interfaces.Operation{Key: key, Value: value, Op: interfaces.OpSet, TTL: ttl})
+}
+
+// Del deletes a key once its group is committed
+func (g *Grouped) Del(key string) (err error) {
+	return g.write(&interfaces.Operation{Key: key, Op: interfaces.OpDel})
+}
+
+// Flush commits the pending writes without waiting for the window to end
+func (g *Grouped) Flush() {
+	g.mu.Lock()
+	group := g.take()
+	g.mu.Unlock()
+
+	g.commit(group)
+}
+
+// Close commits the pending writes and closes the wrapped storage
+func (g *Grouped) Close() error {
+	g.mu.Lock()
+	g.closed = true
+	group := g.take()
+	g.mu.Unlock()
+
+	g.commit(group)
+	g.inFlight.Wait()
+	return g.DbStorage.Close()
+}
+
+func (g *Grouped) write(op *interfaces.Operation) error {
+	w := &groupWrite{op: op, done: make(chan error, 1)}
+
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return ErrGroupClosed
+	}
+
+	g.pending = append(g.pending, w)
+	if len(g.pending) >= g.opts.MaxOps {
+		group := g.take()
+		g.mu.Unlock()
+		g.commit(group)
+		return <-w.done
+	}
+	if len(g.pending) == 1 {
+		g.timer = time.AfterFunc(g.opts.Window, g.Flush)
+	}
+	g.mu.Unlock()
+
+	return <-w.done
+}
+
+// take removes the pending group, g.mu must be held
+func (g *Grouped) take() []*groupWrite {
+	group := g.pending
+	g.pending = nil
+	if g.timer != nil {
+		g.timer.Stop()
+		g.timer = nil
+	}
+	if len(group) > 0 {
+		g.inFlight.Add(1)
+	}
+	return group
+}
+
+func (g *Grouped) commit(group []*groupWrite) {
+	if len(group) == 0 {
+		return
+	}
+	defer g.inFlight.Done()
+
+	batch := make([]*interfaces.Operation, len(group))
+	for i := range group {
+		batch[i] = group[i].op
+	}
+
+	if g.DbStorage.ProcessBatch(batch) == nil {
+		for i := range group {
+			group[i].done <- nil
+		}
+		return
+	}
+
+	// find out which writes failed the group
+	for i := range group {
+		group[i].done <- g.DbStorage.ProcessBatch(batch[i : i+1])
+	}
+}
@@ -0,0 +1,83 @@
+package db_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+)
+
+func TestGrouped(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		grouped := db.WithGroupCommit(storage, db.GroupOptions{Window: 20 * time.Millisecond, MaxOps: 16})
+
+		keys := make([]string, 40)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("key:%02d", i)
+		}
+		// an empty key fails its write, and only its write
+		keys[7] = ""
+
+		errs := make([]error, len(keys))
+		var wg sync.WaitGroup
+		for i := range keys {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = grouped.Set(keys[i], []byte(keys[i]))
+			}(i)
+		}
+		wg.Wait()
+
+		for i, key := range keys {
+			if key == "" {
+				if errs[i] == nil {
+					t.Fatalf("Writing an empty key succeeded")
+				}
+				continue
+			}
+			if errs[i] != nil {
+				t.Fatalf("Error setting %s: %s", key, errs[i])
+			}
+			value, err := storage.Get(key)
+			if err != nil {
+				t.Fatalf("Error getting %s after its set returned: %s", key, err)
+			}
+			if string(value) != key {
+				t.Fatalf("Got %q for %s", value, key)
+			}
+		}
+
+		if err := grouped.Del("key:00"); err != nil {
+			t.Fatalf("Error deleting: %s", err)
+		}
+		if _, err := storage.Get("key:00"); err != badger.ErrKeyNotFound {
+			t.Fatalf("Got %v getting a deleted key wanted %s", err, badger.ErrKeyNotFound)
+		}
+	})
+}
+
+func TestGroupedClose(t *testing.T) {
+	grouped := db.WithGroupCommit(db.NewBadgerInMemory(), db.GroupOptions{Window: time.Hour})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- grouped.Set("key", []byte("value"))
+	}()
+
+	// wait for the write to be pending
+	time.Sleep(10 * time.Millisecond)
+	if err := grouped.Close(); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Pending write wasn't committed on close: %s", err)
+	}
+
+	if err := grouped.Set("key", []byte("value")); err != db.ErrGroupClosed {
+		t.Fatalf("Got %v writing after close wanted %s", err, db.ErrGroupClosed)
+	}
+}
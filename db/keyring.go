@@ -0,0 +1,105 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// sealVersion is the format version byte leading every value sealed by a Keyring
+const sealVersion byte = 1
+
+// sealHeaderSize is the size of the version byte and key id preceding the nonce of a sealed value
+const sealHeaderSize = 1 + 4
+
+// ErrNotSealed is returned when a value wasn't sealed by a Keyring
+var ErrNotSealed = errors.New("value is not sealed by a keyring")
+
+// ErrUnknownKeyID is returned when a value was sealed with a key the Keyring doesn't hold
+var ErrUnknownKeyID = errors.New("value is sealed with an unknown key id")
+
+// Keyring seals and opens values with AES-GCM using a set of versioned keys
+// Values are always sealed with the current key, and can be opened with any key of the ring as
+// the key id is stored alongside the ciphertext, which allows keys to be rotated.
+type Keyring struct {
+	current uint32
+	aeads   map[uint32]cipher.AEAD
+}
+
+// NewKeyring returns a keyring sealing with the key current of keys
+// keys must be 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256
+func NewKeyring(current uint32, keys map[uint32][]byte) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current key id %d is not in the keyring", current)
+	}
+
+	k := &Keyring{
+		current: current,
+		aeads:   make(map[uint32]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key id %d: %s", id, err)
+		}
+		k.aeads[id], err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key id %d: %s", id, err)
+		}
+	}
+
+	return k, nil
+}
+
+// Current returns the id of the key new values are sealed with
+func (k *Keyring) Current() uint32 {
+	return k.current
+}
+
+// KeyID returns the id of the key a sealed value was sealed with
+func (k *Keyring) KeyID(sealed []byte) (uint32, error) {
+	if len(sealed) < sealHeaderSize || sealed[0] != sealVersion {
+		return 0, ErrNotSealed
+	}
+	return binary.BigEndian.Uint32(sealed[1:sealHeaderSize]), nil
+}
+
+// Seal encrypts value with the current key, key is authenticated along with the value
+// so a sealed value can't be moved to another key
+func (k *Keyring) Seal(key, value []byte) ([]byte, error) {
+	aead := k.aeads[k.current]
+
+	sealed := make([]byte, sealHeaderSize+aead.NonceSize(), sealHeaderSize+aead.NonceSize()+len(value)+aead.Overhead())
+	sealed[0] = sealVersion
+	binary.BigEndian.PutUint32(sealed[1:sealHeaderSize], k.current)
+
+	nonce := sealed[sealHeaderSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(sealed, nonce, value, key), nil
+}
+
+// Open decrypts a value sealed for key
+func (k *Keyring) Open(key, sealed []byte) ([]byte, error) {
+	id, err := k.KeyID(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+	if len(sealed) < sealHeaderSize+aead.NonceSize() {
+		return nil, ErrNotSealed
+	}
+
+	nonce := sealed[sealHeaderSize : sealHeaderSize+aead.NonceSize()]
+	return aead.Open(nil, nonce, sealed[sealHeaderSize+aead.NonceSize():], key)
+}
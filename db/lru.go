@@ -0,0 +1,200 @@
+package db
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// maxPinned is the number of pinned keys above which expired pins are dropped
+const maxPinned = 1024
+
+// CacheStats reports the activity of a cache
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Bytes     int64
+}
+
+// LRU is a size bounded, least recently used cache safe for concurrent use
+// Filling the cache is a two step process: Reserve a key before reading it from the source, then Add the
+// value read with the reservation. A value is only added if the key wasn't invalidated in between,
+// so a slow read can never put back a value a concurrent write has just replaced.
+type LRU struct {
+	mu       sync.Mutex
+	maxBytes int64
+	entries  map[string]*list.Element
+	order    *list.List
+	reserved map[string]uint64
+	pinned   map[string]time.Time
+	nextRes  uint64
+	stats    CacheStats
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+	size  int64
+}
+
+// NewLRU returns a cache evicting the least recently used values once their total size exceeds maxBytes
+func NewLRU(maxBytes int64) *LRU {
+	return &LRU{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		reserved: make(map[string]uint64),
+		pinned:   make(map[string]time.Time),
+	}
+}
+
+// Get returns the cached value of key
+func (l *LRU) Get(key string) (value interface{}, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.entries[key]
+	if !ok || l.isPinned(key) {
+		l.stats.Misses++
+		return nil, false
+	}
+
+	l.stats.Hits++
+	l.order.MoveToFront(el)
+	return el.Value.(*lruEntry).value, true
+}
+
+// Reserve returns the reservation to Add the value of key with, once it's read
+func (l *LRU) Reserve(key string) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.isPinned(key) {
+		return 0
+	}
+
+	l.nextRes++
+	l.reserved[key] = l.nextRes
+	return l.nextRes
+}
+
+// Add caches value of size bytes for key, if reservation is still the latest one for key
+func (l *LRU) Add(key string, value interface{}, size int64, reservation uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if reservation == 0 || l.reserved[key] != reservation {
+		return
+	}
+	delete(l.reserved, key)
+
+	if size > l.maxBytes {
+		return
+	}
+
+	if el, ok := l.entries[key]; ok {
+		l.remove(el)
+	}
+	l.entries[key] = l.order.PushFront(&lruEntry{key: key, value: value, size: size})
+	l.stats.Bytes += size
+
+	for l.stats.Bytes > l.maxBytes {
+		l.remove(l.order.Back())
+		l.stats.Evictions++
+	}
+}
+
+// Release drops reservation without adding a value, for reads whose result won't be cached
+func (l *LRU) Release(key string, reservation uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.reserved[key] == reservation {
+		delete(l.reserved, key)
+	}
+}
+
+// Invalidate removes key from the cache and cancels its pending reservation
+func (l *LRU) Invalidate(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.invalidate(key)
+}
+
+// Pin invalidates key and keeps it out of the cache for d, or until Unpin
+// It's meant for keys written by a transaction which isn't committed yet
+func (l *LRU) Pin(key string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.invalidate(key)
+
+	now := time.Now()
+	if len(l.pinned) >= maxPinned {
+		// drop the pins of discarded transactions
+		for k, until := range l.pinned {
+			if !now.Before(until) {
+				delete(l.pinned, k)
+			}
+		}
+	}
+	l.pinned[key] = now.Add(d)
+}
+
+// Unpin invalidates key and allows it to be cached again
+func (l *LRU) Unpin(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.invalidate(key)
+	delete(l.pinned, key)
+}
+
+// Purge empties the cache
+func (l *LRU) Purge() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = make(map[string]*list.Element)
+	l.order.Init()
+	l.reserved = make(map[string]uint64)
+	l.stats.Bytes = 0
+}
+
+// Stats returns the current statistics of the cache
+func (l *LRU) Stats() CacheStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := l.stats
+	stats.Entries = len(l.entries)
+	return stats
+}
+
+func (l *LRU) invalidate(key string) {
+	if el, ok := l.entries[key]; ok {
+		l.remove(el)
+	}
+	delete(l.reserved, key)
+}
+
+func (l *LRU) remove(el *list.Element) {
+	entry := l.order.Remove(el).(*lruEntry)
+	delete(l.entries, entry.key)
+	l.stats.Bytes -= entry.size
+}
+
+func (l *LRU) isPinned(key string) bool {
+	until, ok := l.pinned[key]
+	if !ok {
+		return false
+	}
+	if time.Now().Before(until) {
+		return true
+	}
+	delete(l.pinned, key)
+	return false
+}
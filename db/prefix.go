@@ -0,0 +1,248 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// Prefixed is a DbStorage scoped to a namespace of another DbStorage
+// Every key is transparently prefixed with the namespace, and keys passed to callbacks have it stripped
+type Prefixed struct {
+	storage interfaces.DbStorage
+	ns      []byte
+}
+
+// WithPrefix returns storage scoped to the keys starting with ns
+// Closing the returned storage doesn't close the wrapped one, which stays owned by the caller
+func WithPrefix(storage interfaces.DbStorage, ns []byte) interfaces.DbStorage {
+	return &Prefixed{
+		storage: storage,
+		ns:      append([]byte(nil), ns...),
+	}
+}
+
+// PrefixBatch returns a copy of batch with the keys prefixed by ns, so operations of several namespaces
+// can be processed atomically in a single ProcessBatch of the storage they share
+func PrefixBatch(ns []byte, batch []*interfaces.Operation) []*interfaces.Operation {
+	prefixed := make([]*interfaces.Operation, len(batch))
+	for i := range batch {
+		op := *batch[i]
+		op.Key = string(ns) + op.Key
+		prefixed[i] = &op
+	}
+	return prefixed
+}
+
+// Set adds a key-value pair to the namespace
+func (p *Prefixed) Set(key string, value []byte) (err error) {
+	return p.storage.Set(p.key(key), value)
+}
+
+// SetWithTTL adds a key-value pair to the namespace which expires after ttl
+func (p *Prefixed) SetWithTTL(key string, value []byte, ttl time.Duration) (err error) {
+	return p.storage.SetWithTTL(p.key(key), value, ttl)
+}
+
+// TTL returns the remaining time to live of a key in the namespace
+func (p *Prefixed) TTL(key string) (ttl time.Duration, err error) {
+	return p.storage.TTL(p.key(key))
+}
+
+// Del deletes a key from the namespace
+func (p *Prefixed) Del(key string) (err error) {
+	return p.storage.Del(p.key(key))
+}
+
+// Get returns value by key from the namespace
+func (p *Prefixed) Get(key string) (value []byte, err error) {
+	return p.storage.Get(p.key(key))
+}
+
+// getExpiring returns the value of key in the namespace along with whether it expires
+func (p *Prefixed) getExpiring(key string) (value []byte, expiring bool, err error) {
+	return readExpiring(p.storage, p.key(key))
+}
+
+// Iterate iterates over all keys of the namespace
+func (p *Prefixed) Iterate(fn func(key []byte, value []byte)) {
+	p.storage.IterateByPrefix(p.ns, 0, p.strip(fn))
+}
+
+// IterateByPrefix iterates over keys with prefix in the namespace
+func (p *Prefixed) IterateByPrefix(prefix []byte, limit uint64, fn func(key []byte, value []byte)) uint64 {
+	return p.storage.IterateByPrefix(p.prefix(prefix), limit, p.strip(fn))
+}
+
+// IterateByPrefixFrom iterates over keys with prefix in the namespace starting at from
+func (p *Prefixed) IterateByPrefixFrom(prefix []byte, from []byte, limit uint64,
+	fn func(key []byte, value []byte)) uint64 {
+	return p.storage.IterateByPrefixFrom(p.prefix(prefix), p.prefix(from), limit, p.strip(fn))
+}
+
+// IterateRange iterates over the keys between start and end in the namespace, open bounds stop
+// at the edges of the namespace
+func (p *Prefixed) IterateRange(start, end []byte, opts interfaces.RangeOptions,
+	fn func(key []byte, value []byte)) uint64 {
+	if start == nil {
+		start = p.ns
+		opts.ExcludeStart = false
+	} else {
+		start = p.prefix(start)
+	}
+
+	if end == nil {
+		end = prefixEnd(p.ns)
+		opts.IncludeEnd = false
+	} else {
+		end = p.prefix(end)
+	}
+
+	return p.storage.IterateRange(start, end, opts, p.strip(fn))
+}
+
+// DeleteByPrefix deletes the keys with prefix in the namespace
+func (p *Prefixed) DeleteByPrefix(prefix []byte) {
+	p.storage.DeleteByPrefix(p.prefix(prefix))
+}
+
+// Watch calls fn with the changes of the keys with any of the prefixes in the namespace, no prefixes
+// watches the whole namespace
+func (p *Prefixed) Watch(ctx context.Context, prefixes [][]byte, opts interfaces.WatchOptions,
+	fn func(events []interfaces.Event) error) error {
+	nsPrefixes := [][]byte{p.ns}
+	if len(prefixes) > 0 {
+		nsPrefixes = make([][]byte, len(prefixes))
+		for i := range prefixes {
+			nsPrefixes[i] = p.prefix(prefixes[i])
+		}
+	}
+
+	strip := func(events []interfaces.Event) {
+		for i := range events {
+			events[i].Key = events[i].Key[len(p.ns):]
+		}
+	}
+
+	onDrop := opts.OnDrop
+	if onDrop != nil {
+		opts.OnDrop = func(events []interfaces.Event) {
+			strip(events)
+			onDrop(events)
+		}
+	}
+
+	return p.storage.Watch(ctx, nsPrefixes, opts, func(events []interfaces.Event) error {
+		strip(events)
+		return fn(events)
+	})
+}
+
+// KeysByPrefixCount counts the keys with prefix in the namespace
+func (p *Prefixed) KeysByPrefixCount(prefix []byte) uint64 {
+	return p.storage.KeysByPrefixCount(p.prefix(prefix))
+}
+
+// ProcessBatch process batch of operations on the namespace
+func (p *Prefixed) ProcessBatch(batch []*interfaces.Operation) (err error) {
+	return p.unprefixError(p.storage.ProcessBatch(PrefixBatch(p.ns, batch)))
+}
+
+// CompareAndSwap sets key in the namespace to new only if it currently holds old
+func (p *Prefixed) CompareAndSwap(key string, old, new []byte) (swapped bool, err error) {
+	return p.storage.CompareAndSwap(p.key(key), old, new)
+}
+
+// Increment adds delta to the int64 counter stored in key in the namespace
+func (p *Prefixed) Increment(key string, delta int64) (value int64, err error) {
+	return p.storage.Increment(p.key(key), delta)
+}
+
+// Merge returns a Merger for key in the namespace
+func (p *Prefixed) Merge(key string, fn interfaces.MergeFunc, interval time.Duration) interfaces.Merger {
+	return p.storage.Merge(p.key(key), fn, interval)
+}
+
+// Begin starts a new transaction scoped to the namespace
+func (p *Prefixed) Begin(writable bool) (interfaces.Txn, error) {
+	txn, err := p.storage.Begin(writable)
+	if err != nil {
+		return nil, err
+	}
+	return &prefixedTxn{txn: txn, p: p}, nil
+}
+
+// Close does nothing, the wrapped storage has to be closed by its owner
+func (p *Prefixed) Close() error {
+	return nil
+}
+
+func (p *Prefixed) key(key string) string {
+	return string(p.ns) + key
+}
+
+func (p *Prefixed) prefix(prefix []byte) []byte {
+	return append(append(make([]byte, 0, len(p.ns)+len(prefix)), p.ns...), prefix...)
+}
+
+func (p *Prefixed) strip(fn func(key []byte, value []byte)) func(key []byte, value []byte) {
+	return func(key []byte, value []byte) {
+		fn(key[len(p.ns):], value)
+	}
+}
+
+// unprefixError strips the namespace from the key named by a precondition error
+func (p *Prefixed) unprefixError(err error) error {
+	if failed, ok := err.(*interfaces.ErrPreconditionFailed); ok && len(failed.Key) >= len(p.ns) {
+		return &interfaces.ErrPreconditionFailed{Key: failed.Key[len(p.ns):], Op: failed.Op}
+	}
+	return err
+}
+
+// prefixEnd returns the smallest key greater than every key starting with prefix,
+// nil if there is none
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// prefixedTxn is a transaction scoped to the namespace of a Prefixed storage
+type prefixedTxn struct {
+	txn interfaces.Txn
+	p   *Prefixed
+}
+
+func (t *prefixedTxn) Get(key string) (value []byte, err error) {
+	return t.txn.Get(t.p.key(key))
+}
+
+func (t *prefixedTxn) Set(key string, value []byte) (err error) {
+	return t.txn.Set(t.p.key(key), value)
+}
+
+func (t *prefixedTxn) SetWithTTL(key string, value []byte, ttl time.Duration) (err error) {
+	return t.txn.SetWithTTL(t.p.key(key), value, ttl)
+}
+
+func (t *prefixedTxn) Del(key string) (err error) {
+	return t.txn.Del(t.p.key(key))
+}
+
+func (t *prefixedTxn) IterateByPrefix(prefix []byte, limit uint64, fn func(key []byte, value []byte)) uint64 {
+	return t.txn.IterateByPrefix(t.p.prefix(prefix), limit, t.p.strip(fn))
+}
+
+func (t *prefixedTxn) Commit() error {
+	return t.txn.Commit()
+}
+
+func (t *prefixedTxn) Discard() {
+	t.txn.Discard()
+}
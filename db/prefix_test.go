@@ -0,0 +1,165 @@
+package db_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+func TestWithPrefix(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		users := db.WithPrefix(storage, []byte("users/"))
+		jobs := db.WithPrefix(storage, []byte("jobs/"))
+
+		for _, key := range []string{"a", "b", "c"} {
+			if err := users.Set(key, []byte("user "+key)); err != nil {
+				t.Fatalf("Error setting: %s", err)
+			}
+			if err := jobs.Set(key, []byte("job "+key)); err != nil {
+				t.Fatalf("Error setting: %s", err)
+			}
+		}
+
+		value, err := storage.Get("users/a")
+		if err != nil {
+			t.Fatalf("Prefixed key not stored in the wrapped storage: %s", err)
+		}
+		if string(value) != "user a" {
+			t.Fatalf("Got %s wanted user a", value)
+		}
+
+		var keys []string
+		users.Iterate(func(key []byte, value []byte) {
+			keys = append(keys, string(key))
+		})
+		if !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+			t.Fatalf("Iterated keys %v wanted [a b c]", keys)
+		}
+
+		keys = nil
+		users.IterateRange(nil, nil, interfaces.RangeOptions{Reverse: true, Limit: 2}, func(key []byte, value []byte) {
+			keys = append(keys, string(key))
+		})
+		if !reflect.DeepEqual(keys, []string{"c", "b"}) {
+			t.Fatalf("Iterated keys %v wanted [c b]", keys)
+		}
+
+		if count := users.KeysByPrefixCount(nil); count != 3 {
+			t.Fatalf("Counted %d keys wanted 3", count)
+		}
+
+		users.DeleteByPrefix(nil)
+		if count := users.KeysByPrefixCount(nil); count != 0 {
+			t.Fatalf("Counted %d keys after delete wanted 0", count)
+		}
+		if count := jobs.KeysByPrefixCount(nil); count != 3 {
+			t.Fatalf("Delete wasn't scoped to the namespace, %d keys left in another wanted 3", count)
+		}
+	})
+}
+
+func TestWithPrefixBatch(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		users := db.WithPrefix(storage, []byte("users/"))
+
+		err := users.ProcessBatch([]*interfaces.Operation{
+			{Key: "a", Op: interfaces.OpCheckExists},
+			{Key: "b", Value: []byte("b"), Op: interfaces.OpSet},
+		})
+		failed, ok := err.(*interfaces.ErrPreconditionFailed)
+		if !ok {
+			t.Fatalf("Expected *ErrPreconditionFailed got %v", err)
+		}
+		if failed.Key != "a" {
+			t.Fatalf("Precondition error names key %s wanted a", failed.Key)
+		}
+
+		// operations of two namespaces committed atomically
+		batch := append(db.PrefixBatch([]byte("users/"), []*interfaces.Operation{
+			{Key: "a", Value: []byte("a"), Op: interfaces.OpSet},
+		}), db.PrefixBatch([]byte("jobs/"), []*interfaces.Operation{
+			{Key: "a", Op: interfaces.OpCheckAbsent},
+			{Key: "a", Value: []byte("a"), Op: interfaces.OpSet},
+		})...)
+		if err = storage.ProcessBatch(batch); err != nil {
+			t.Fatalf("Error processing batch: %s", err)
+		}
+
+		if _, err = users.Get("a"); err != nil {
+			t.Fatalf("Error getting: %s", err)
+		}
+		if _, err = storage.Get("jobs/a"); err != nil {
+			t.Fatalf("Error getting: %s", err)
+		}
+	})
+}
+
+func TestWithPrefixTxn(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		users := db.WithPrefix(storage, []byte("users/"))
+
+		err := db.RetryTxn(users, 0, func(txn interfaces.Txn) error {
+			return txn.Set("a", []byte("a"))
+		})
+		if err != nil {
+			t.Fatalf("Error running transaction: %s", err)
+		}
+
+		txn, err := users.Begin(false)
+		if err != nil {
+			t.Fatalf("Error beginning transaction: %s", err)
+		}
+		defer txn.Discard()
+
+		var keys []string
+		txn.IterateByPrefix(nil, 0, func(key []byte, value []byte) {
+			keys = append(keys, string(key))
+		})
+		if !reflect.DeepEqual(keys, []string{"a"}) {
+			t.Fatalf("Iterated keys %v wanted [a]", keys)
+		}
+
+		if _, err = txn.Get("users/a"); err != badger.ErrKeyNotFound {
+			t.Fatalf("Key was prefixed twice")
+		}
+	})
+}
+
+func TestWithPrefixWatch(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		users := db.WithPrefix(storage, []byte("users/"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		events := make(chan interfaces.Event, 10)
+		go users.Watch(ctx, nil, interfaces.WatchOptions{}, func(batch []interfaces.Event) error {
+			for _, event := range batch {
+				events <- event
+			}
+			return nil
+		})
+		time.Sleep(50 * time.Millisecond)
+
+		if err := storage.Set("jobs/a", []byte("job")); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+		if err := users.Set("a", []byte("user")); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+
+		select {
+		case event := <-events:
+			if string(event.Key) != "a" {
+				t.Fatalf("Got event for key %s wanted a", event.Key)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for event")
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"bytes"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// IterateRange iterates over the keys between start and end, see interfaces.RangeOptions
+func (storage *Badger) IterateRange(start, end []byte, opts interfaces.RangeOptions,
+	fn func(key []byte, value []byte)) uint64 {
+	var totalIterated uint64
+	storage.DB.View(func(txn *badger.Txn) error {
+		iterOpts := badger.DefaultIteratorOptions
+		iterOpts.AllVersions = false
+		iterOpts.Reverse = opts.Reverse
+		iterOpts.PrefetchValues = !opts.KeysOnly
+		it := txn.NewIterator(iterOpts)
+		defer it.Close()
+
+		// seek to the first key in iteration order
+		first, last := start, end
+		if opts.Reverse {
+			first, last = end, start
+		}
+		if first == nil {
+			it.Rewind()
+		} else {
+			it.Seek(first)
+		}
+
+		for ; it.Valid() && (opts.Limit == 0 || totalIterated < opts.Limit); it.Next() {
+			item := it.Item()
+			key := item.Key()
+
+			if first != nil && bytes.Equal(key, first) && !rangeIncludes(opts, opts.Reverse) {
+				continue
+			}
+			if last != nil && pastRange(key, last, opts) {
+				break
+			}
+
+			k := item.KeyCopy(nil)
+			var v []byte
+			if !opts.KeysOnly {
+				var err error
+				v, err = item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+			}
+			fn(k, v)
+			totalIterated++
+		}
+		return nil
+	})
+
+	return totalIterated
+}
+
+// rangeIncludes reports whether the end (or start if end is false) bound of the range is inclusive
+func rangeIncludes(opts interfaces.RangeOptions, end bool) bool {
+	if end {
+		return opts.IncludeEnd
+	}
+	return !opts.ExcludeStart
+}
+
+// pastRange reports whether key is beyond the last bound of the range in iteration order
+func pastRange(key, last []byte, opts interfaces.RangeOptions) bool {
+	cmp := bytes.Compare(key, last)
+	if opts.Reverse {
+		cmp = -cmp
+	}
+	if cmp == 0 {
+		return !rangeIncludes(opts, !opts.Reverse)
+	}
+	return cmp > 0
+}
@@ -0,0 +1,91 @@
+package db_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+func TestIterateRange(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		for i := 0; i < 10; i++ {
+			if err := storage.Set(fmt.Sprintf("event:%02d", i), []byte{byte(i)}); err != nil {
+				t.Fatalf("Error setting: %s", err)
+			}
+		}
+		if err := storage.Set("other", []byte("x")); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+
+		tests := []struct {
+			name       string
+			start, end string
+			opts       interfaces.RangeOptions
+			result     []string
+		}{
+			{"Default Bounds", "event:02", "event:05", interfaces.RangeOptions{},
+				[]string{"event:02", "event:03", "event:04"}},
+			{"Exclude Start Include End", "event:02", "event:05",
+				interfaces.RangeOptions{ExcludeStart: true, IncludeEnd: true},
+				[]string{"event:03", "event:04", "event:05"}},
+			{"Reverse", "event:02", "event:05", interfaces.RangeOptions{Reverse: true},
+				[]string{"event:04", "event:03", "event:02"}},
+			{"Reverse Exclude Start Include End", "event:02", "event:05",
+				interfaces.RangeOptions{Reverse: true, ExcludeStart: true, IncludeEnd: true},
+				[]string{"event:05", "event:04", "event:03"}},
+			{"Reverse Latest With Limit", "event:", "event;", interfaces.RangeOptions{Reverse: true, Limit: 3},
+				[]string{"event:09", "event:08", "event:07"}},
+			{"Open Start", "", "event:02", interfaces.RangeOptions{},
+				[]string{"event:00", "event:01"}},
+			{"Open End", "event:08", "", interfaces.RangeOptions{},
+				[]string{"event:08", "event:09", "other"}},
+			{"Reverse Open End", "event:08", "", interfaces.RangeOptions{Reverse: true},
+				[]string{"other", "event:09", "event:08"}},
+			{"Missing Bounds", "event:025", "event:045", interfaces.RangeOptions{},
+				[]string{"event:03", "event:04"}},
+			{"Reverse Missing Bounds", "event:025", "event:045", interfaces.RangeOptions{Reverse: true},
+				[]string{"event:04", "event:03"}},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				var start, end []byte
+				if tst.start != "" {
+					start = []byte(tst.start)
+				}
+				if tst.end != "" {
+					end = []byte(tst.end)
+				}
+
+				var result []string
+				count := storage.IterateRange(start, end, tst.opts, func(key []byte, value []byte) {
+					result = append(result, string(key))
+				})
+
+				if !reflect.DeepEqual(result, tst.result) {
+					t.Fatalf("Got %v wanted %v", result, tst.result)
+				}
+				if count != uint64(len(tst.result)) {
+					t.Fatalf("Returned count %d wanted %d", count, len(tst.result))
+				}
+			})
+		}
+	})
+}
+
+func TestIterateRangeKeysOnly(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		if err := storage.Set("key", []byte("value")); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+
+		storage.IterateRange(nil, nil, interfaces.RangeOptions{KeysOnly: true}, func(key []byte, value []byte) {
+			if value != nil {
+				t.Fatalf("Keys only iteration read value %s", value)
+			}
+		})
+	})
+}
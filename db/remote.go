@@ -0,0 +1,616 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// DefaultMergeInterval is how often a Merger of a Remote storage writes the values added when no interval is given
+const DefaultMergeInterval = time.Second
+
+// ErrWatchLagged is returned by the Watch of a Remote storage when the watcher fell behind the writes
+// and the server ended its stream, the events committed since its last batch may have been missed
+var ErrWatchLagged = errors.New("watcher fell behind and missed events")
+
+// RemoteError is returned by a Remote storage for the errors of the server which don't match a storage error
+type RemoteError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote storage: %s (%d %s)", e.Message, e.Status, e.Code)
+}
+
+// remoteErrorBody is the error body sent by the server package
+type remoteErrorBody struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+	Key   string `json:"key"`
+	Op    string `json:"op"`
+}
+
+// remoteKV is a line of an iteration stream
+type remoteKV struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// remoteWatchLine is a line of a watch stream
+type remoteWatchLine struct {
+	Events []interfaces.Event `json:"events"`
+	remoteErrorBody
+}
+
+// RemoteOptions allows you to change how a Remote storage talks to its server
+// Client defaults to a new http.Client without timeout, since watches stream for as long as they run.
+// Authorize is called on every request, to set its credentials.
+type RemoteOptions struct {
+	Client    *http.Client
+	Authorize func(r *http.Request)
+}
+
+// Remote is a DbStorage served over HTTP by the server package
+// Errors of the served storage are returned as the same errors, badger.ErrKeyNotFound,
+// *interfaces.ErrPreconditionFailed, badger.ErrConflict, badger.ErrTxnTooBig and ErrNotCounter. Methods
+// without an error result stop on transport errors, iterations call fn with the keys read until then.
+type Remote struct {
+	base *url.URL
+	opts RemoteOptions
+}
+
+// NewRemote returns the storage served at baseURL
+func NewRemote(baseURL string, opts RemoteOptions) (*Remote, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{}
+	}
+	return &Remote{base: base, opts: opts}, nil
+}
+
+// Set adds a key-value pair
+func (r *Remote) Set(key string, value []byte) (err error) {
+	return r.do(context.Background(), http.MethodPut, "/kv", url.Values{"key": {key}}, value, nil)
+}
+
+// SetWithTTL adds a key-value pair which expires after ttl
+func (r *Remote) SetWithTTL(key string, value []byte, ttl time.Duration) (err error) {
+	params := url.Values{"key": {key}, "ttl": {ttl.String()}}
+	return r.do(context.Background(), http.MethodPut, "/kv", params, value, nil)
+}
+
+// TTL returns the remaining time to live of a key, zero if it doesn't expire
+func (r *Remote) TTL(key string) (ttl time.Duration, err error) {
+	var res struct {
+		TTL time.Duration `json:"ttl"`
+	}
+	err = r.do(context.Background(), http.MethodGet, "/ttl", url.Values{"key": {key}}, nil, &res)
+	return res.TTL, err
+}
+
+// Del deletes a key
+func (r *Remote) Del(key string) (err error) {
+	return r.do(context.Background(), http.MethodDelete, "/kv", url.Values{"key": {key}}, nil, nil)
+}
+
+// Get returns value by key
+func (r *Remote) Get(key string) (value []byte, err error) {
+	err = r.do(context.Background(), http.MethodGet, "/kv", url.Values{"key": {key}}, nil, &value)
+	return value, err
+}
+
+// Iterate iterates over all keys
+func (r *Remote) Iterate(fn func(key []byte, value []byte)) {
+	r.iterate("/range", nil, fn)
+}
+
+// IterateByPrefix iterates over keys with prefix
+func (r *Remote) IterateByPrefix(prefix []byte, limit uint64, fn func(key []byte, value []byte)) uint64 {
+	params := url.Values{"prefix": {string(prefix)}, "limit": {strconv.FormatUint(limit, 10)}}
+	return r.iterate("/iterate", params, fn)
+}
+
+// IterateByPrefixFrom iterates over keys with prefix, starting from the key from
+func (r *Remote) IterateByPrefixFrom(prefix []byte, from []byte, limit uint64,
+	fn func(key []byte, value []byte)) uint64 {
+	params := url.Values{
+		"prefix": {string(prefix)},
+		"from":   {string(from)},
+		"limit":  {strconv.FormatUint(limit, 10)},
+	}
+	return r.iterate("/iterate", params, fn)
+}
+
+// IterateRange iterates over the keys between start and end as controlled by opts
+func (r *Remote) IterateRange(start, end []byte, opts interfaces.RangeOptions,
+	fn func(key []byte, value []byte)) uint64 {
+	params := url.Values{
+		"excludeStart": {strconv.FormatBool(opts.ExcludeStart)},
+		"includeEnd":   {strconv.FormatBool(opts.IncludeEnd)},
+		"reverse":      {strconv.FormatBool(opts.Reverse)},
+		"keysOnly":     {strconv.FormatBool(opts.KeysOnly)},
+		"limit":        {strconv.FormatUint(opts.Limit, 10)},
+	}
+	if start != nil {
+		params.Set("start", string(start))
+	}
+	if end != nil {
+		params.Set("end", string(end))
+	}
+	return r.iterate("/range", params, fn)
+}
+
+// DeleteByPrefix deletes all keys with prefix
+func (r *Remote) DeleteByPrefix(prefix []byte) {
+	r.do(context.Background(), http.MethodPost, "/delete-prefix", url.Values{"prefix": {string(prefix)}}, nil, nil)
+}
+
+// Watch calls fn with the changes committed to keys matching any of the prefixes, until ctx is done or fn
+// returns an error. Events are queued by the server, opts.Buffer batches at least, a watcher falling
+// behind gets ErrWatchLagged instead of holding back writers: DropWhenFull and OnDrop aren't used.
+// Events committed before the server starts watching, shortly after the call, aren't delivered.
+func (r *Remote) Watch(ctx context.Context, prefixes [][]byte, opts interfaces.WatchOptions,
+	fn func(events []interfaces.Event) error) error {
+	params := url.Values{"buffer": {strconv.Itoa(opts.Buffer)}}
+	for _, prefix := range prefixes {
+		params.Add("prefix", string(prefix))
+	}
+
+	err := r.stream(ctx, "/watch", params, func(dec *json.Decoder) error {
+		var line remoteWatchLine
+		if err := dec.Decode(&line); err != nil {
+			return err
+		}
+		if line.Code != "" {
+			if line.Code == "watchLagged" {
+				return ErrWatchLagged
+			}
+			return &RemoteError{Status: http.StatusOK, Code: line.Code, Message: line.Error}
+		}
+		return fn(line.Events)
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// KeysByPrefixCount counts the keys with prefix
+func (r *Remote) KeysByPrefixCount(prefix []byte) uint64 {
+	var res struct {
+		Count uint64 `json:"count"`
+	}
+	r.do(context.Background(), http.MethodGet, "/count", url.Values{"prefix": {string(prefix)}}, nil, &res)
+	return res.Count
+}
+
+// ProcessBatch processes the operations of batch atomically on the server
+func (r *Remote) ProcessBatch(batch []*interfaces.Operation) (err error) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	return r.do(context.Background(), http.MethodPost, "/batch", nil, body, nil)
+}
+
+// CompareAndSwap sets key to new if its value is old, a nil old means the key must not exist
+func (r *Remote) CompareAndSwap(key string, old, new []byte) (swapped bool, err error) {
+	body, err := json.Marshal(struct {
+		Key string `json:"key"`
+		Old []byte `json:"old"`
+		New []byte `json:"new"`
+	}{key, old, new})
+	if err != nil {
+		return false, err
+	}
+
+	var res struct {
+		Swapped bool `json:"swapped"`
+	}
+	err = r.do(context.Background(), http.MethodPost, "/cas", nil, body, &res)
+	return res.Swapped, err
+}
+
+// Increment adds delta to the int64 counter stored in key and returns the new value
+func (r *Remote) Increment(key string, delta int64) (value int64, err error) {
+	var res struct {
+		Value int64 `json:"value"`
+	}
+	params := url.Values{"key": {key}, "delta": {strconv.FormatInt(delta, 10)}}
+	err = r.do(context.Background(), http.MethodPost, "/incr", params, nil, &res)
+	return res.Value, err
+}
+
+// Merge returns a Merger of key, the values added are merged locally and written every interval with a
+// compare and swap, so merges of several clients don't override each other. An interval which isn't positive
+// writes them every DefaultMergeInterval.
+func (r *Remote) Merge(key string, fn interfaces.MergeFunc, interval time.Duration) interfaces.Merger {
+	if interval <= 0 {
+		interval = DefaultMergeInterval
+	}
+	m := &remoteMerger{remote: r, key: key, fn: fn, stop: make(chan struct{}), done: make(chan struct{})}
+	go m.run(interval)
+	return m
+}
+
+// Begin starts an optimistic transaction
+// Reads are served by the server and remembered, writes are buffered until Commit, which applies them in a
+// single batch checking every key read still holds the value read. When one doesn't, Commit returns
+// badger.ErrConflict, like a conflicting badger transaction, and RetryTxn can be used.
+func (r *Remote) Begin(writable bool) (interfaces.Txn, error) {
+	return &RemoteTxn{
+		remote:   r,
+		writable: writable,
+		reads:    make(map[string][]byte),
+		pending:  make(map[string]*interfaces.Operation),
+	}, nil
+}
+
+// Close releases the idle connections to the server, the served storage stays open
+func (r *Remote) Close() error {
+	r.opts.Client.CloseIdleConnections()
+	return nil
+}
+
+func (r *Remote) iterate(path string, params url.Values, fn func(key []byte, value []byte)) uint64 {
+	var totalIterated uint64
+	r.stream(context.Background(), path, params, func(dec *json.Decoder) error {
+		var kv remoteKV
+		if err := dec.Decode(&kv); err != nil {
+			return err
+		}
+		fn(kv.Key, kv.Value)
+		totalIterated++
+		return nil
+	})
+	return totalIterated
+}
+
+// stream sends a GET of path and calls fn to decode each JSON line of the response, until fn returns an error
+func (r *Remote) stream(ctx context.Context, path string, params url.Values, fn func(dec *json.Decoder) error) error {
+	res, err := r.send(ctx, http.MethodGet, path, params, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(res.Body))
+	for {
+		err := fn(dec)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// do sends a request and decodes its response into result, raw bytes into a *[]byte and JSON otherwise
+func (r *Remote) do(ctx context.Context, method, path string, params url.Values, body []byte,
+	result interface{}) error {
+	res, err := r.send(ctx, method, path, params, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if raw, ok := result.(*[]byte); ok {
+		*raw, err = ioutil.ReadAll(res.Body)
+		return err
+	}
+	if result != nil {
+		return json.NewDecoder(res.Body).Decode(result)
+	}
+	return nil
+}
+
+// send sends a request, the body of error responses is read and returned as the matching error
+func (r *Remote) send(ctx context.Context, method, path string, params url.Values,
+	body []byte) (*http.Response, error) {
+	u := *r.base
+	u.Path += path
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if r.opts.Authorize != nil {
+		r.opts.Authorize(req)
+	}
+
+	res, err := r.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+
+	var e remoteErrorBody
+	if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
+		return nil, &RemoteError{Status: res.StatusCode, Message: res.Status}
+	}
+	return nil, remoteError(res.StatusCode, &e)
+}
+
+// remoteError returns the storage error matching an error body of the server
+func remoteError(status int, e *remoteErrorBody) error {
+	switch e.Code {
+	case "notFound":
+		return badger.ErrKeyNotFound
+	case "preconditionFailed":
+		return &interfaces.ErrPreconditionFailed{Key: e.Key, Op: e.Op}
+	case "conflict":
+		return badger.ErrConflict
+	case "txnTooBig":
+		return badger.ErrTxnTooBig
+	case "notCounter":
+		return ErrNotCounter
+	}
+	return &RemoteError{Status: status, Code: e.Code, Message: e.Error}
+}
+
+// RemoteTxn implements interfaces.Txn for a Remote storage
+type RemoteTxn struct {
+	remote   *Remote
+	writable bool
+	done     bool
+
+	// reads holds the values read by the transaction, nil for the keys found missing
+	reads map[string][]byte
+	// pending holds the last write of each key, writes in the order they were made
+	pending map[string]*interfaces.Operation
+	writes  []*interfaces.Operation
+}
+
+// Get returns value by key, pending writes of this transaction are visible
+func (t *RemoteTxn) Get(key string) (value []byte, err error) {
+	if t.done {
+		return nil, badger.ErrDiscardedTxn
+	}
+	if op, ok := t.pending[key]; ok {
+		if op.Op == interfaces.OpDel {
+			return nil, badger.ErrKeyNotFound
+		}
+		return append([]byte(nil), op.Value...), nil
+	}
+
+	value, ok := t.reads[key]
+	if !ok {
+		value, err = t.remote.Get(key)
+		if err == badger.ErrKeyNotFound {
+			value, err = nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.reads[key] = value
+	}
+
+	if value == nil {
+		return nil, badger.ErrKeyNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+// Set adds a key-value pair within the transaction
+func (t *RemoteTxn) Set(key string, value []byte) (err error) {
+	return t.write(&interfaces.Operation{Key: key, Value: append([]byte{}, value...), Op: interfaces.OpSet})
+}
+
+// SetWithTTL adds a key-value pair within the transaction which expires after ttl
+func (t *RemoteTxn) SetWithTTL(key string, value []byte, ttl time.Duration) (err error) {
+	return t.write(&interfaces.Operation{Key: key, Value: append([]byte{}, value...), Op: interfaces.OpSet, TTL: ttl})
+}
+
+// Del deletes a key within the transaction
+func (t *RemoteTxn) Del(key string) (err error) {
+	return t.write(&interfaces.Operation{Key: key, Op: interfaces.OpDel})
+}
+
+func (t *RemoteTxn) write(op *interfaces.Operation) error {
+	if t.done {
+		return badger.ErrDiscardedTxn
+	}
+	if !t.writable {
+		return badger.ErrReadOnlyTxn
+	}
+	t.pending[op.Key] = op
+	t.writes = append(t.writes, op)
+	return nil
+}
+
+// IterateByPrefix iterates over keys with prefix as seen by the transaction
+// The keys iterated are read by the transaction, keys added with the prefix by others before Commit
+// don't conflict.
+func (t *RemoteTxn) IterateByPrefix(prefix []byte, limit uint64, fn func(key []byte, value []byte)) uint64 {
+	if t.done {
+		return 0
+	}
+
+	values := make(map[string][]byte)
+	t.remote.IterateByPrefix(prefix, 0, func(key, value []byte) {
+		if value == nil {
+			value = []byte{}
+		}
+		if _, ok := t.reads[string(key)]; !ok {
+			t.reads[string(key)] = value
+		}
+		values[string(key)] = t.reads[string(key)]
+	})
+	for key, op := range t.pending {
+		if !strings.HasPrefix(key, string(prefix)) {
+			continue
+		}
+		if op.Op == interfaces.OpDel {
+			delete(values, key)
+		} else {
+			values[key] = op.Value
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key, value := range values {
+		if value != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var totalIterated uint64
+	for _, key := range keys {
+		if limit > 0 && totalIterated >= limit {
+			break
+		}
+		fn([]byte(key), append([]byte(nil), values[key]...))
+		totalIterated++
+	}
+	return totalIterated
+}
+
+// Commit applies the writes of the transaction if none of the keys it read changed since,
+// badger.ErrConflict is returned otherwise
+func (t *RemoteTxn) Commit() error {
+	if t.done {
+		return badger.ErrDiscardedTxn
+	}
+	t.done = true
+	if len(t.writes) == 0 {
+		return nil
+	}
+
+	batch := make([]*interfaces.Operation, 0, len(t.reads)+len(t.writes))
+	for key, value := range t.reads {
+		check := &interfaces.Operation{Key: key, Value: value, Op: interfaces.OpCheckEquals}
+		if value == nil {
+			check.Op = interfaces.OpCheckAbsent
+		}
+		batch = append(batch, check)
+	}
+	batch = append(batch, t.writes...)
+
+	err := t.remote.ProcessBatch(batch)
+	if _, ok := err.(*interfaces.ErrPreconditionFailed); ok {
+		return badger.ErrConflict
+	}
+	return err
+}
+
+// Discard discards the transaction, it is safe to call after Commit
+func (t *RemoteTxn) Discard() {
+	t.done = true
+}
+
+// remoteMerger implements interfaces.Merger for a Remote storage
+type remoteMerger struct {
+	remote *Remote
+	key    string
+	fn     interfaces.MergeFunc
+
+	mu      sync.Mutex
+	values  [][]byte
+	stop    chan struct{}
+	done    chan struct{}
+	stopped sync.Once
+}
+
+// Add queues value to be merged
+func (m *remoteMerger) Add(value []byte) error {
+	m.mu.Lock()
+	m.values = append(m.values, append([]byte(nil), value...))
+	m.mu.Unlock()
+	return nil
+}
+
+// Get writes the values added so far and returns the merged value
+func (m *remoteMerger) Get() ([]byte, error) {
+	if err := m.flush(); err != nil {
+		return nil, err
+	}
+	return m.remote.Get(m.key)
+}
+
+// Stop writes the values added so far and stops merging in the background
+func (m *remoteMerger) Stop() {
+	m.stopped.Do(func() {
+		close(m.stop)
+		<-m.done
+		m.flush()
+	})
+}
+
+func (m *remoteMerger) run(interval time.Duration) {
+	defer close(m.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.flush()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// flush merges the queued values into the stored one, retrying while other clients change it
+// Values are kept queued if the write fails, to be written by the next flush.
+func (m *remoteMerger) flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.values) == 0 {
+		return nil
+	}
+
+	for {
+		existing, err := m.remote.Get(m.key)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		merged := existing
+		for _, value := range m.values {
+			if merged == nil {
+				merged = value
+				continue
+			}
+			merged = m.fn(merged, value)
+		}
+
+		swapped, err := m.remote.CompareAndSwap(m.key, existing, merged)
+		if err != nil {
+			return err
+		}
+		if swapped {
+			m.values = nil
+			return nil
+		}
+	}
+}
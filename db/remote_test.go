@@ -0,0 +1,266 @@
+package db_test
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+	"github.com/xurwxj/kvdb/server"
+)
+
+func testRemote(t *testing.T, tests func(storage *db.Badger, remote *db.Remote, t *testing.T)) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		srv := httptest.NewServer(server.New(storage, server.Options{}))
+		defer srv.Close()
+
+		remote, err := db.NewRemote(srv.URL, db.RemoteOptions{})
+		if err != nil {
+			t.Fatalf("Error creating remote: %s", err)
+		}
+		defer remote.Close()
+
+		tests(storage, remote, t)
+	})
+}
+
+func TestRemote(t *testing.T) {
+	testRemote(t, func(storage *db.Badger, remote *db.Remote, t *testing.T) {
+		if err := remote.Set("a", []byte("1")); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+		if err := remote.SetWithTTL("b", []byte("2"), time.Hour); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+		if err := remote.Set("c", nil); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+
+		value, err := storage.Get("a")
+		if err != nil || string(value) != "1" {
+			t.Fatalf("Remote set isn't stored: %q, %v", value, err)
+		}
+
+		value, err = remote.Get("b")
+		if err != nil || string(value) != "2" {
+			t.Fatalf("Got %q, %v, wanted 2", value, err)
+		}
+		if _, err = remote.Get("missing"); err != badger.ErrKeyNotFound {
+			t.Fatalf("Got %v for a missing key, wanted badger.ErrKeyNotFound", err)
+		}
+
+		ttl, err := remote.TTL("b")
+		// expiry is rounded up to the second
+		if err != nil || ttl <= 0 || ttl > time.Hour+time.Second {
+			t.Fatalf("Got TTL %s, %v, wanted at most an hour and a second", ttl, err)
+		}
+
+		var keys []string
+		count := remote.IterateByPrefix(nil, 2, func(key, value []byte) {
+			keys = append(keys, string(key))
+		})
+		if count != 2 || len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+			t.Fatalf("Iterated %v, wanted [a b]", keys)
+		}
+
+		keys = nil
+		remote.IterateRange([]byte("a"), []byte("c"), interfaces.RangeOptions{Reverse: true, IncludeEnd: true},
+			func(key, value []byte) {
+				keys = append(keys, string(key))
+			})
+		if len(keys) != 3 || keys[0] != "c" || keys[2] != "a" {
+			t.Fatalf("Iterated range %v, wanted [c b a]", keys)
+		}
+
+		if count := remote.KeysByPrefixCount(nil); count != 3 {
+			t.Fatalf("Counted %d keys, wanted 3", count)
+		}
+
+		if err = remote.Del("a"); err != nil {
+			t.Fatalf("Error deleting: %s", err)
+		}
+		remote.DeleteByPrefix([]byte("b"))
+		if count := storage.KeysByPrefixCount(nil); count != 1 {
+			t.Fatalf("%d keys left, wanted 1", count)
+		}
+	})
+}
+
+func TestRemoteBatch(t *testing.T) {
+	testRemote(t, func(storage *db.Badger, remote *db.Remote, t *testing.T) {
+		err := remote.ProcessBatch([]*interfaces.Operation{
+			{Key: "a", Value: []byte("1"), Op: interfaces.OpSet},
+			{Key: "b", Value: []byte("2"), Op: interfaces.OpSet},
+		})
+		if err != nil {
+			t.Fatalf("Error processing batch: %s", err)
+		}
+
+		err = remote.ProcessBatch([]*interfaces.Operation{
+			{Key: "a", Op: interfaces.OpCheckAbsent},
+			{Key: "b", Op: interfaces.OpDel},
+		})
+		failed, ok := err.(*interfaces.ErrPreconditionFailed)
+		if !ok || failed.Key != "a" || failed.Op != interfaces.OpCheckAbsent {
+			t.Fatalf("Got %v, wanted the precondition of a to fail", err)
+		}
+		if _, err = storage.Get("b"); err != nil {
+			t.Fatalf("Failed batch was applied: %v", err)
+		}
+
+		swapped, err := remote.CompareAndSwap("a", []byte("1"), []byte("3"))
+		if err != nil || !swapped {
+			t.Fatalf("Got %v, %v, wanted a swap", swapped, err)
+		}
+		swapped, err = remote.CompareAndSwap("a", []byte("1"), []byte("4"))
+		if err != nil || swapped {
+			t.Fatalf("Got %v, %v, wanted no swap", swapped, err)
+		}
+
+		value, err := remote.Increment("n", 5)
+		if err != nil || value != 5 {
+			t.Fatalf("Got %d, %v, wanted 5", value, err)
+		}
+		if _, err = remote.Increment("a", 1); err != db.ErrNotCounter {
+			t.Fatalf("Got %v, wanted db.ErrNotCounter", err)
+		}
+
+		merger := remote.Merge("sum", db.AddInt64, time.Hour)
+		merger.Add(db.EncodeInt64(2))
+		merger.Add(db.EncodeInt64(3))
+		merged, err := merger.Get()
+		merger.Stop()
+		if err != nil {
+			t.Fatalf("Error getting merged value: %s", err)
+		}
+		if sum, _ := db.DecodeInt64(merged); sum != 5 {
+			t.Fatalf("Merged %d, wanted 5", sum)
+		}
+
+		// merges every DefaultMergeInterval
+		merger = remote.Merge("sum", db.AddInt64, 0)
+		merger.Add(db.EncodeInt64(1))
+		merger.Stop()
+		if merged, err = remote.Get("sum"); err != nil {
+			t.Fatalf("Error getting merged value: %s", err)
+		}
+		if sum, _ := db.DecodeInt64(merged); sum != 6 {
+			t.Fatalf("Merged %d, wanted 6", sum)
+		}
+	})
+}
+
+func TestRemoteTxn(t *testing.T) {
+	testRemote(t, func(storage *db.Badger, remote *db.Remote, t *testing.T) {
+		storage.Set("a", []byte("1"))
+		storage.Set("b", []byte("2"))
+
+		txn, _ := remote.Begin(true)
+		value, err := txn.Get("a")
+		if err != nil || string(value) != "1" {
+			t.Fatalf("Got %q, %v, wanted 1", value, err)
+		}
+		txn.Set("c", []byte("3"))
+		txn.Del("b")
+
+		var keys []string
+		txn.IterateByPrefix(nil, 0, func(key, value []byte) {
+			keys = append(keys, string(key))
+		})
+		if len(keys) != 2 || keys[0] != "a" || keys[1] != "c" {
+			t.Fatalf("Txn iterated %v, wanted its own writes [a c]", keys)
+		}
+		if _, err = storage.Get("c"); err != badger.ErrKeyNotFound {
+			t.Fatalf("Txn write is visible before commit: %v", err)
+		}
+
+		if err = txn.Commit(); err != nil {
+			t.Fatalf("Error committing: %s", err)
+		}
+		if value, _ = storage.Get("c"); string(value) != "3" {
+			t.Fatalf("Got %q after commit, wanted 3", value)
+		}
+
+		txn, _ = remote.Begin(true)
+		txn.Get("a")
+		txn.Get("missing")
+		txn.Set("a", []byte("from txn"))
+		storage.Set("missing", []byte("now here"))
+		if err = txn.Commit(); err != badger.ErrConflict {
+			t.Fatalf("Got %v, wanted badger.ErrConflict", err)
+		}
+		if value, _ = storage.Get("a"); string(value) != "1" {
+			t.Fatalf("Conflicting txn was applied, a is %q", value)
+		}
+
+		attempts := 0
+		err = db.RetryTxn(remote, 0, func(txn interfaces.Txn) error {
+			attempts++
+			value, err := txn.Get("a")
+			if err != nil {
+				return err
+			}
+			if attempts == 1 {
+				storage.Set("a", []byte("changed"))
+			}
+			return txn.Set("a", append(value, '!'))
+		})
+		if err != nil || attempts != 2 {
+			t.Fatalf("Got %v after %d attempts, wanted a retry", err, attempts)
+		}
+		if value, _ = storage.Get("a"); string(value) != "changed!" {
+			t.Fatalf("Got %q, wanted changed!", value)
+		}
+
+		txn, _ = remote.Begin(false)
+		if err = txn.Set("a", nil); err != badger.ErrReadOnlyTxn {
+			t.Fatalf("Got %v, wanted badger.ErrReadOnlyTxn", err)
+		}
+		txn.Discard()
+	})
+}
+
+func TestRemoteWatch(t *testing.T) {
+	testRemote(t, func(storage *db.Badger, remote *db.Remote, t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		events := make(chan interfaces.Event, 100)
+		done := make(chan error, 1)
+		go func() {
+			done <- remote.Watch(ctx, [][]byte{[]byte("w:")}, interfaces.WatchOptions{},
+				func(batch []interfaces.Event) error {
+					for _, event := range batch {
+						events <- event
+					}
+					return nil
+				})
+		}()
+
+		// the server subscribes shortly after the stream starts, write until an event comes through
+		var event interfaces.Event
+		timeout := time.After(5 * time.Second)
+	wait:
+		for {
+			storage.Set("other", []byte("ignored"))
+			storage.Set("w:a", []byte("value"))
+			select {
+			case event = <-events:
+				break wait
+			case <-time.After(20 * time.Millisecond):
+			case <-timeout:
+				t.Fatalf("Timed out waiting for event")
+			}
+		}
+		if string(event.Key) != "w:a" || !bytes.Equal(event.Value, []byte("value")) || event.Op != interfaces.OpSet {
+			t.Fatalf("Got event %+v, wanted a set of w:a", event)
+		}
+
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Fatalf("Watch returned %v, wanted context.Canceled", err)
+		}
+	})
+}
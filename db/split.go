@@ -0,0 +1,115 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// DefaultSplitMaxOps is the number of operations committed together by ProcessBatchSplit when no size is given
+const DefaultSplitMaxOps = 10000
+
+// ErrAtomicityRequired is returned by ProcessBatchSplit when a batch doesn't fit in one transaction but
+// has to be committed atomically, because the options say so or because it holds check operations
+var ErrAtomicityRequired = errors.New("batch is too big for one transaction and can't be split")
+
+// ErrResumePrefix is returned by DeleteByPrefixSplit when it's given a Resume, the keys deleted by a failed call
+// are gone so calling it again finishes the purge
+var ErrResumePrefix = errors.New("a prefix delete can't be resumed, call it again instead")
+
+// SplitOptions allows you to change how ProcessBatchSplit splits a batch
+// MaxOps bounds the operations committed together, a part still too big for badger is halved until it fits.
+// With Atomic the batch is never split, whatever its number of operations. Resume is the number of operations of the batch already committed,
+// as returned or reported to Progress by a previous call, and Progress is called after every commit with
+// the number of operations committed so far, returning an error from it stops the batch.
+type SplitOptions struct {
+	MaxOps   int
+	Atomic   bool
+	Resume   int
+	Progress func(done int) error
+}
+
+// ProcessBatchSplit processes batch in as many transactions as needed to avoid badger.ErrTxnTooBig
+// It returns the number of operations committed, which can be passed as Resume to finish a failed batch.
+// Operations are committed in order, but a reader can see the batch partially applied.
+func ProcessBatchSplit(storage interfaces.DbStorage, batch []*interfaces.Operation, opts SplitOptions) (done int, err error) {
+	if opts.MaxOps <= 0 {
+		opts.MaxOps = DefaultSplitMaxOps
+	}
+	done = opts.Resume
+	if done > len(batch) {
+		done = len(batch)
+	}
+
+	// checks only hold within their transaction, a batch holding them is only committed whole
+	if opts.Atomic || hasChecks(batch[done:]) {
+		err = storage.ProcessBatch(batch[done:])
+		if err == badger.ErrTxnTooBig {
+			return done, ErrAtomicityRequired
+		}
+		if err != nil {
+			return done, err
+		}
+		done = len(batch)
+
+		if opts.Progress != nil {
+			err = opts.Progress(done)
+		}
+		return done, err
+	}
+
+	size := opts.MaxOps
+	for done < len(batch) {
+		end := done + size
+		if end > len(batch) {
+			end = len(batch)
+		}
+
+		err = storage.ProcessBatch(batch[done:end])
+		if err == badger.ErrTxnTooBig && end-done > 1 {
+			size = (end - done) / 2
+			continue
+		}
+		if err != nil {
+			return done, err
+		}
+		done = end
+
+		if opts.Progress != nil {
+			if err = opts.Progress(done); err != nil {
+				return done, err
+			}
+		}
+	}
+
+	return done, nil
+}
+
+// DeleteByPrefixSplit deletes the keys with prefix like DeleteByPrefix, in as many transactions as needed to
+// avoid badger.ErrTxnTooBig, and returns the number of keys deleted. It fails with ErrResumePrefix if
+// opts.Resume is set, the keys deleted are gone so calling it again finishes a failed purge.
+func DeleteByPrefixSplit(storage interfaces.DbStorage, prefix []byte, opts SplitOptions) (int, error) {
+	if opts.Resume != 0 {
+		return 0, ErrResumePrefix
+	}
+
+	var batch []*interfaces.Operation
+	storage.IterateRange(prefix, prefixEnd(prefix), interfaces.RangeOptions{KeysOnly: true},
+		func(key []byte, value []byte) {
+			batch = append(batch, &interfaces.Operation{Key: string(key), Op: interfaces.OpDel})
+		})
+
+	return ProcessBatchSplit(storage, batch, opts)
+}
+
+// hasChecks reports whether any operation of batch is a check, checks only hold within their transaction
+func hasChecks(batch []*interfaces.Operation) bool {
+	for _, op := range batch {
+		switch op.Op {
+		case interfaces.OpCheckEquals, interfaces.OpCheckAbsent, interfaces.OpCheckExists:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+func TestSetWithTTL(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		err := storage.SetWithTTL("session", []byte("value"), time.Second)
+		if err != nil {
+			t.Fatalf("Error setting with ttl: %s", err)
+		}
+
+		ttl, err := storage.TTL("session")
+		if err != nil {
+			t.Fatalf("Error getting ttl: %s", err)
+		}
+		// rounded up to the second
+		if ttl <= 900*time.Millisecond || ttl > 2*time.Second {
+			t.Fatalf("Remaining ttl is %s wanted (0.9s, 2s]", ttl)
+		}
+
+		if err = storage.Set("persistent", []byte("value")); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+		ttl, err = storage.TTL("persistent")
+		if err != nil {
+			t.Fatalf("Error getting ttl: %s", err)
+		}
+		if ttl != 0 {
+			t.Fatalf("Key without expiry has ttl %s", ttl)
+		}
+
+		time.Sleep(2 * time.Second)
+
+		if _, err = storage.Get("session"); err != badger.ErrKeyNotFound {
+			t.Fatalf("Expired key is still readable: %v", err)
+		}
+		if _, err = storage.TTL("session"); err != badger.ErrKeyNotFound {
+			t.Fatalf("Expired key still has a ttl: %v", err)
+		}
+	})
+}
+
+func TestSetWithTTLSubSecond(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		// whatever the time within the current second, the key outlives its ttl
+		for i := 0; i < 10; i++ {
+			err := storage.SetWithTTL("short", []byte("value"), 100*time.Millisecond)
+			if err != nil {
+				t.Fatalf("Error setting with ttl: %s", err)
+			}
+			start := time.Now()
+
+			time.Sleep(90 * time.Millisecond)
+			if _, err = storage.Get("short"); err != nil && time.Since(start) < 100*time.Millisecond {
+				t.Fatalf("Key expired before its ttl: %v", err)
+			}
+		}
+
+		time.Sleep(1200 * time.Millisecond)
+		if _, err := storage.Get("short"); err != badger.ErrKeyNotFound {
+			t.Fatalf("Expired key is still readable: %v", err)
+		}
+	})
+}
+
+func TestProcessBatchTTL(t *testing.T) {
+	storage := db.NewBadgerInMemory()
+	defer storage.Close()
+
+	err := storage.ProcessBatch([]*interfaces.Operation{
+		{Key: "lock", Value: []byte("owner"), Op: interfaces.OpSet, TTL: time.Second},
+		{Key: "config", Value: []byte("value"), Op: interfaces.OpSet},
+	})
+	if err != nil {
+		t.Fatalf("Error processing batch: %s", err)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	if _, err = storage.Get("lock"); err != badger.ErrKeyNotFound {
+		t.Fatalf("Expired key is still readable: %v", err)
+	}
+	if _, err = storage.Get("config"); err != nil {
+		t.Fatalf("Key without ttl expired: %s", err)
+	}
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// DefaultTxnRetries is the number of attempts RetryTxn makes when no attempts are given
+const DefaultTxnRetries = 10
+
+// maxRetryBackoff caps the wait between two attempts of a conflicting transaction
+const maxRetryBackoff = 10 * time.Millisecond
+
+// BadgerTxn implements interfaces.Txn on top of a badger transaction
+type BadgerTxn struct {
+	Txn *badger.Txn
+}
+
+// Begin starts a new transaction, read only transactions can't Set or Del
+func (storage *Badger) Begin(writable bool) (interfaces.Txn, error) {
+	return &BadgerTxn{Txn: storage.DB.NewTransaction(writable)}, nil
+}
+
+// Get returns value by key, pending writes of this transaction are visible
+func (t *BadgerTxn) Get(key string) (value []byte, err error) {
+	item, err := t.Txn.Get([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+// Set adds a key-value pair within the transaction
+func (t *BadgerTxn) Set(key string, value []byte) (err error) {
+	return t.Txn.SetEntry(newEntry(key, value, 0))
+}
+
+// SetWithTTL adds a key-value pair within the transaction which expires after ttl
+func (t *BadgerTxn) SetWithTTL(key string, value []byte, ttl time.Duration) (err error) {
+	return t.Txn.SetEntry(newEntry(key, value, ttl))
+}
+
+// Del deletes a key within the transaction
+func (t *BadgerTxn) Del(key string) (err error) {
+	return t.Txn.Delete([]byte(key))
+}
+
+// IterateByPrefix iterates over keys with prefix as seen by the transaction
+func (t *BadgerTxn) IterateByPrefix(prefix []byte, limit uint64, fn func(key []byte, value []byte)) uint64 {
+	var totalIterated uint64
+	opts := badger.DefaultIteratorOptions
+	opts.AllVersions = false
+	it := t.Txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix) && ((limit > 0 && totalIterated < limit) || limit <= 0); it.Next() {
+		item := it.Item()
+		k := item.KeyCopy(nil)
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			break
+		}
+		fn(k, v)
+		totalIterated++
+	}
+
+	return totalIterated
+}
+
+// Commit commits the transaction, badger.ErrConflict is returned if a key read by
+// the transaction was changed by another transaction that committed first
+func (t *BadgerTxn) Commit() error {
+	return t.Txn.Commit()
+}
+
+// Discard discards the transaction, it is safe to call after Commit
+func (t *BadgerTxn) Discard() {
+	t.Txn.Discard()
+}
+
+// RetryTxn runs fn in a writable transaction and commits it, retrying the whole
+// transaction up to attempts times while the commit fails with badger.ErrConflict, after a
+// random backoff. Any error returned by fn discards the transaction and is returned as is.
+func RetryTxn(storage interfaces.DbStorage, attempts int, fn func(txn interfaces.Txn) error) (err error) {
+	if attempts <= 0 {
+		attempts = DefaultTxnRetries
+	}
+
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			RetryBackoff(i)
+		}
+
+		var txn interfaces.Txn
+		txn, err = storage.Begin(true)
+		if err != nil {
+			return err
+		}
+
+		err = fn(txn)
+		if err != nil {
+			txn.Discard()
+			return err
+		}
+
+		err = txn.Commit()
+		txn.Discard()
+		if err != badger.ErrConflict {
+			return err
+		}
+	}
+
+	return err
+}
+
+// RetryBackoff waits before the attempt of a transaction which conflicted attempt times, a random time up to a
+// cap doubling with every attempt, so contending transactions don't retry in lockstep. It's the backoff of
+// RetryTxn, for the packages retrying their own badger transactions.
+func RetryBackoff(attempt int) {
+	backoff := maxRetryBackoff
+	if attempt < 4 {
+		backoff = time.Millisecond << uint(attempt)
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+	time.Sleep(time.Duration(rand.Int63n(int64(backoff) + 1)))
+}
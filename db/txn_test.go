@@ -0,0 +1,132 @@
+package db_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+func TestTxnReadModifyWrite(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		txn, err := storage.Begin(true)
+		if err != nil {
+			t.Fatalf("Error beginning transaction: %s", err)
+		}
+		defer txn.Discard()
+
+		if err = txn.Set("a:1", []byte("one")); err != nil {
+			t.Fatalf("Error setting in transaction: %s", err)
+		}
+		if err = txn.Set("a:2", []byte("two")); err != nil {
+			t.Fatalf("Error setting in transaction: %s", err)
+		}
+
+		value, err := txn.Get("a:1")
+		if err != nil {
+			t.Fatalf("Pending write not visible in transaction: %s", err)
+		}
+		if string(value) != "one" {
+			t.Fatalf("Got %s wanted one", value)
+		}
+
+		if _, err = storage.Get("a:1"); err != badger.ErrKeyNotFound {
+			t.Fatalf("Uncommitted write visible outside of transaction: %v", err)
+		}
+
+		count := txn.IterateByPrefix([]byte("a:"), 0, func(key []byte, value []byte) {})
+		if count != 2 {
+			t.Fatalf("Iterated %d keys wanted 2", count)
+		}
+
+		if err = txn.Commit(); err != nil {
+			t.Fatalf("Error committing transaction: %s", err)
+		}
+
+		value, err = storage.Get("a:2")
+		if err != nil {
+			t.Fatalf("Error getting committed value: %s", err)
+		}
+		if string(value) != "two" {
+			t.Fatalf("Got %s wanted two", value)
+		}
+	})
+}
+
+func TestTxnReadOnly(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		txn, err := storage.Begin(false)
+		if err != nil {
+			t.Fatalf("Error beginning transaction: %s", err)
+		}
+		defer txn.Discard()
+
+		if err = txn.Set("key", []byte("value")); err == nil {
+			t.Fatalf("Setting in a read only transaction didn't fail!")
+		}
+	})
+}
+
+func TestTxnDiscard(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		txn, err := storage.Begin(true)
+		if err != nil {
+			t.Fatalf("Error beginning transaction: %s", err)
+		}
+		if err = txn.Set("key", []byte("value")); err != nil {
+			t.Fatalf("Error setting in transaction: %s", err)
+		}
+		txn.Discard()
+
+		if _, err = storage.Get("key"); err != badger.ErrKeyNotFound {
+			t.Fatalf("Discarded write was stored: %v", err)
+		}
+	})
+}
+
+func TestRetryTxnConflict(t *testing.T) {
+	testRetryTxnConflict(t, 100)
+}
+
+// contending transactions back off rather than running out of the default attempts together
+func TestRetryTxnConflictDefaultAttempts(t *testing.T) {
+	testRetryTxnConflict(t, 0)
+}
+
+func testRetryTxnConflict(t *testing.T, attempts int) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		if err := storage.Set("counter", []byte{0}); err != nil {
+			t.Fatalf("Error setting counter: %s", err)
+		}
+
+		var wg sync.WaitGroup
+		workers := 10
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := db.RetryTxn(storage, attempts, func(txn interfaces.Txn) error {
+					value, err := txn.Get("counter")
+					if err != nil {
+						return err
+					}
+					return txn.Set("counter", []byte{value[0] + 1})
+				})
+				if err != nil {
+					t.Errorf("Error incrementing counter: %s", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		value, err := storage.Get("counter")
+		if err != nil {
+			t.Fatalf("Error getting counter: %s", err)
+		}
+		if int(value[0]) != workers {
+			t.Fatalf("Counter is %d wanted %d", value[0], workers)
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/pb"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// badgerInternalPrefix is the prefix of the keys badger writes for itself, such as the marker committing a
+// transaction, they're never reported
+var badgerInternalPrefix = []byte("!badger!")
+
+// metaSet is the badger user meta flag of every value written through Badger, it tells an empty value from a
+// delete without reading the key again
+const metaSet byte = 1
+
+// Watch calls fn with the changes committed to keys matching any of the prefixes, an empty prefix
+// matches every key. It blocks until ctx is done, returning ctx.Err(), or until fn returns an error.
+// Keys expiring aren't reported, only explicit sets and deletes are. An empty value written without going
+// through this package is told from a delete by reading the delete marker of its version, if that version
+// expired or was compacted away meanwhile it's reported as a delete.
+func (storage *Badger) Watch(ctx context.Context, prefixes [][]byte, opts interfaces.WatchOptions,
+	fn func(events []interfaces.Event) error) error {
+	matches := make([]pb.Match, len(prefixes))
+	for i := range prefixes {
+		matches[i] = pb.Match{Prefix: prefixes[i]}
+	}
+	if len(matches) == 0 {
+		matches = append(matches, pb.Match{Prefix: nil})
+	}
+
+	if opts.Buffer <= 0 {
+		return storage.DB.Subscribe(ctx, func(kvs *badger.KVList) error {
+			events := storage.kvEvents(kvs)
+			if len(events) == 0 {
+				return nil
+			}
+			return fn(events)
+		}, matches)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	buffer := make(chan []interfaces.Event, opts.Buffer)
+	delivered := make(chan error, 1)
+	go func() {
+		for events := range buffer {
+			if err := fn(events); err != nil {
+				delivered <- err
+				cancel()
+				// keep draining so the subscription never blocks on a stopped consumer
+				for range buffer {
+				}
+				return
+			}
+		}
+		delivered <- nil
+	}()
+
+	err := storage.DB.Subscribe(ctx, func(kvs *badger.KVList) error {
+		events := storage.kvEvents(kvs)
+		if len(events) == 0 {
+			return nil
+		}
+		if !opts.DropWhenFull {
+			select {
+			case buffer <- events:
+			case <-ctx.Done():
+			}
+			return nil
+		}
+
+		select {
+		case buffer <- events:
+		default:
+			if opts.OnDrop != nil {
+				opts.OnDrop(events)
+			}
+		}
+		return nil
+	}, matches)
+	close(buffer)
+
+	if fnErr := <-delivered; fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+// kvEvents converts a badger subscription update into events, leaving out badger's internal keys
+func (storage *Badger) kvEvents(kvs *badger.KVList) []interfaces.Event {
+	events := make([]interfaces.Event, 0, len(kvs.Kv))
+	for _, kv := range kvs.Kv {
+		if bytes.HasPrefix(kv.Key, badgerInternalPrefix) {
+			continue
+		}
+		event := interfaces.Event{
+			Key:       kv.Key,
+			Value:     kv.Value,
+			Op:        interfaces.OpSet,
+			Version:   kv.Version,
+			ExpiresAt: kv.ExpiresAt,
+		}
+		// subscriptions don't carry the delete marker, deletes have no value and no metaSet flag
+		if len(kv.Value) == 0 && (len(kv.Meta) == 0 || kv.Meta[0]&metaSet == 0) &&
+			storage.deleted(kv.Key, kv.Version) {
+			event.Op = interfaces.OpDel
+			event.Value = nil
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// deleted reports whether the version of key is a delete, a version which can't be found is reported deleted
+func (storage *Badger) deleted(key []byte, version uint64) bool {
+	deleted := true
+	storage.DB.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.AllVersions = true
+		opts.PrefetchValues = false
+		opts.Prefix = key
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		// versions of a key are iterated newest first
+		for it.Seek(key); it.Valid(); it.Next() {
+			item := it.Item()
+			if !bytes.Equal(item.Key(), key) || item.Version() < version {
+				break
+			}
+			if item.Version() == version {
+				deleted = item.IsDeletedOrExpired()
+				break
+			}
+		}
+		return nil
+	})
+	return deleted
+}
@@ -0,0 +1,192 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// watchEvents starts a watcher and returns the channel events are sent to once it's subscribed
+func watchEvents(ctx context.Context, storage *db.Badger, prefixes [][]byte,
+	opts interfaces.WatchOptions) (<-chan interfaces.Event, <-chan error) {
+	events := make(chan interfaces.Event, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- storage.Watch(ctx, prefixes, opts, func(batch []interfaces.Event) error {
+			for _, event := range batch {
+				events <- event
+			}
+			return nil
+		})
+	}()
+	// give the subscription time to register
+	time.Sleep(50 * time.Millisecond)
+	return events, done
+}
+
+func nextEvent(t *testing.T, events <-chan interfaces.Event) interfaces.Event {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for event")
+	}
+	return interfaces.Event{}
+}
+
+func TestWatch(t *testing.T) {
+	for _, opts := range []interfaces.WatchOptions{{}, {Buffer: 10}} {
+		testWrap(t, func(storage *db.Badger, t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			events, done := watchEvents(ctx, storage, [][]byte{[]byte("cache:")}, opts)
+
+			if err := storage.Set("other", []byte("ignored")); err != nil {
+				t.Fatalf("Error setting: %s", err)
+			}
+			if err := storage.SetWithTTL("cache:a", []byte("value"), time.Hour); err != nil {
+				t.Fatalf("Error setting: %s", err)
+			}
+			if err := storage.Set("cache:empty", nil); err != nil {
+				t.Fatalf("Error setting: %s", err)
+			}
+			// written around Set, without the flag telling empty values from deletes
+			err := storage.DB.Update(func(txn *badger.Txn) error {
+				return txn.Set([]byte("cache:raw"), nil)
+			})
+			if err != nil {
+				t.Fatalf("Error setting: %s", err)
+			}
+			if err := storage.Del("cache:a"); err != nil {
+				t.Fatalf("Error deleting: %s", err)
+			}
+
+			event := nextEvent(t, events)
+			if string(event.Key) != "cache:a" || string(event.Value) != "value" || event.Op != interfaces.OpSet {
+				t.Fatalf("Unexpected event %+v", event)
+			}
+			if event.ExpiresAt == 0 || event.Version == 0 {
+				t.Fatalf("Event is missing its version or expiry %+v", event)
+			}
+
+			event = nextEvent(t, events)
+			if string(event.Key) != "cache:empty" || event.Op != interfaces.OpSet {
+				t.Fatalf("Empty value wasn't reported as a set %+v", event)
+			}
+
+			event = nextEvent(t, events)
+			if string(event.Key) != "cache:raw" || event.Op != interfaces.OpSet {
+				t.Fatalf("Empty value written by badger wasn't reported as a set %+v", event)
+			}
+
+			event = nextEvent(t, events)
+			if string(event.Key) != "cache:a" || event.Op != interfaces.OpDel {
+				t.Fatalf("Unexpected event %+v", event)
+			}
+
+			cancel()
+			if err := <-done; err != context.Canceled {
+				t.Fatalf("Watch returned %v wanted %v", err, context.Canceled)
+			}
+		})
+	}
+}
+
+func TestWatchEveryKey(t *testing.T) {
+	for _, opts := range []interfaces.WatchOptions{{}, {Buffer: 10}} {
+		testWrap(t, func(storage *db.Badger, t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			events, done := watchEvents(ctx, storage, nil, opts)
+
+			for _, key := range []string{"a", "b"} {
+				if err := storage.Set(key, []byte("value")); err != nil {
+					t.Fatalf("Error setting: %s", err)
+				}
+			}
+
+			// badger's internal keys aren't reported
+			for _, key := range []string{"a", "b"} {
+				if event := nextEvent(t, events); string(event.Key) != key {
+					t.Fatalf("Got event for %q, wanted %q", event.Key, key)
+				}
+			}
+			select {
+			case event := <-events:
+				t.Fatalf("Unexpected event %+v", event)
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			cancel()
+			if err := <-done; err != context.Canceled {
+				t.Fatalf("Watch returned %v wanted %v", err, context.Canceled)
+			}
+		})
+	}
+}
+
+func TestWatchCallbackError(t *testing.T) {
+	for _, opts := range []interfaces.WatchOptions{{}, {Buffer: 10}} {
+		testWrap(t, func(storage *db.Badger, t *testing.T) {
+			stop := errors.New("stop")
+			done := make(chan error, 1)
+			go func() {
+				done <- storage.Watch(context.Background(), nil, opts, func(events []interfaces.Event) error {
+					return stop
+				})
+			}()
+			time.Sleep(50 * time.Millisecond)
+
+			if err := storage.Set("key", []byte("value")); err != nil {
+				t.Fatalf("Error setting: %s", err)
+			}
+
+			select {
+			case err := <-done:
+				if err != stop {
+					t.Fatalf("Watch returned %v wanted %v", err, stop)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Watch didn't stop on callback error")
+			}
+		})
+	}
+}
+
+func TestWatchDropWhenFull(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		release := make(chan struct{})
+		dropped := make(chan struct{}, 100)
+		go storage.Watch(ctx, nil, interfaces.WatchOptions{
+			Buffer:       1,
+			DropWhenFull: true,
+			OnDrop: func(events []interfaces.Event) {
+				dropped <- struct{}{}
+			},
+		}, func(events []interfaces.Event) error {
+			<-release
+			return nil
+		})
+		time.Sleep(50 * time.Millisecond)
+
+		for i := 0; i < 5; i++ {
+			if err := storage.Set("key", []byte{byte(i)}); err != nil {
+				t.Fatalf("Error setting: %s", err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		close(release)
+
+		select {
+		case <-dropped:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No events were dropped by a full buffer")
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package hold
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+)
+
+// Backup writes every record and index changed after version since to w, since 0 is a full backup
+// It returns the version to pass as since to the next, incremental, backup. Backups can be checked
+// with db.VerifyBackup.
+func (s *Store) Backup(w io.Writer, since uint64) (uint64, error) {
+	return s.db.Backup(w, since)
+}
+
+// Restore loads a backup written by Backup, incremental backups have to be restored in the order
+// they were taken, after the full backup they're based on
+// Nothing else should write to the store while it runs.
+func (s *Store) Restore(r io.Reader) error {
+	// sequences restart from the leases stored in the backup
+	var err error
+	s.sequences.Range(func(key, value interface{}) bool {
+		err = value.(*badger.Sequence).Release()
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	s.sequences = &sync.Map{}
+
+	err = s.db.Load(r, db.DefaultRestorePendingWrites)
+	if s.cache != nil {
+		s.cache.Purge()
+	}
+	return err
+}
+
+// RestoreTo restores a backup into a new store in options.Dir, which must be empty or not exist,
+// and returns it opened
+func RestoreTo(options Options, r io.Reader) (*Store, error) {
+	entries, err := os.ReadDir(options.Dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		return nil, db.ErrRestoreNotEmpty
+	}
+
+	s, err := Open(options)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.Restore(r); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,56 @@
+package hold_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/hold"
+)
+
+func TestBackupRestore(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		var backup bytes.Buffer
+		_, err := store.Backup(&backup, 0)
+		if err != nil {
+			t.Fatalf("Error backing up hold: %s", err)
+		}
+
+		if _, err = db.VerifyBackup(bytes.NewReader(backup.Bytes())); err != nil {
+			t.Fatalf("Error verifying backup: %s", err)
+		}
+
+		opt := testOptions()
+		defer os.RemoveAll(opt.Dir)
+
+		restored, err := hold.RestoreTo(opt, &backup)
+		if err != nil {
+			t.Fatalf("Error restoring hold: %s", err)
+		}
+		defer restored.Close()
+
+		var result []ItemTest
+		err = restored.Find(&result, hold.Where("Category").Eq("vehicle").Index("Category"))
+		if err != nil {
+			t.Fatalf("Error finding data in the restored hold: %s", err)
+		}
+
+		var wanted []ItemTest
+		err = store.Find(&wanted, hold.Where("Category").Eq("vehicle").Index("Category"))
+		if err != nil {
+			t.Fatalf("Error finding data in hold: %s", err)
+		}
+
+		if len(result) == 0 || len(result) != len(wanted) {
+			t.Fatalf("Found %d records in the restored hold wanted %d", len(result), len(wanted))
+		}
+
+		_, err = hold.RestoreTo(opt, bytes.NewReader(nil))
+		if err != db.ErrRestoreNotEmpty {
+			t.Fatalf("Got %v restoring into a used directory wanted %s", err, db.ErrRestoreNotEmpty)
+		}
+	})
+}
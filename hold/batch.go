@@ -0,0 +1,176 @@
+package hold
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+)
+
+// DefaultBatchSize is the number of records written per transaction when no batch size is given
+const DefaultBatchSize = 1000
+
+// BatchOptions allows you to change how DeleteMatchingInBatches and UpdateMatchingInBatches split their work
+// Size is the number of records per transaction, a batch still too big for badger is halved until it fits.
+// Progress is called after every committed batch with the number of records processed so far and a checkpoint,
+// returning an error from it stops the work. Passing the last checkpoint as Resume skips the records
+// already processed by a previous, interrupted run.
+type BatchOptions struct {
+	Size     int
+	Resume   []byte
+	Progress func(processed int, checkpoint []byte) error
+}
+
+// DeleteMatchingInBatches deletes all of the records that match the passed in query over as many
+// transactions as needed, unlike DeleteMatching it doesn't fail with db.ErrAtomicityRequired on large results
+// but isn't atomic, if it fails the records of the committed batches stay deleted
+func (s *Store) DeleteMatchingInBatches(dataType interface{}, query *Query, opts BatchOptions) error {
+	return s.matchingInBatches(dataType, query, opts, func(tx *badger.Txn, storer Storer, r *record) error {
+		return s.deleteRecord(tx, storer, r)
+	})
+}
+
+// UpdateMatchingInBatches runs the update function for every record that match the passed in query over
+// as many transactions as needed, unlike UpdateMatching it doesn't fail with db.ErrAtomicityRequired on
+// large results but isn't atomic, if it fails the records of the committed batches stay updated
+func (s *Store) UpdateMatchingInBatches(dataType interface{}, query *Query, opts BatchOptions,
+	update func(record interface{}) error) error {
+	return s.matchingInBatches(dataType, query, opts, func(tx *badger.Txn, storer Storer, r *record) error {
+		return s.updateRecord(tx, storer, r, update)
+	})
+}
+
+// matchingInBatches runs the query once to find the keys of the matching records, then calls action for
+// each of them in key order, in batches of transactions
+// Records are read again in their batch and skipped if they were changed and no longer match the query.
+func (s *Store) matchingInBatches(dataType interface{}, query *Query, opts BatchOptions,
+	action func(tx *badger.Txn, storer Storer, r *record) error) error {
+	if query == nil {
+		query = &Query{}
+	}
+	if opts.Size <= 0 {
+		opts.Size = DefaultBatchSize
+	}
+
+	var keys [][]byte
+	err := s.db.View(func(tx *badger.Txn) error {
+		return s.runQuery(tx, dataType, query, nil, query.skip, func(r *record) error {
+			if opts.Resume == nil || bytes.Compare(r.key, opts.Resume) > 0 {
+				keys = append(keys, r.key)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+
+	storer := s.newStorer(dataType)
+	tp := query.dataType
+	processed := 0
+	size := opts.Size
+
+	for processed < len(keys) {
+		end := processed + size
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		err = s.update(func(tx *badger.Txn) error {
+			// match every record of the batch before changing any, like the query of UpdateMatching
+			var records []*record
+			for _, key := range keys[processed:end] {
+				item, err := tx.Get(key)
+				if err == badger.ErrKeyNotFound {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+
+				val := reflect.New(tp)
+				err = item.Value(func(value []byte) error {
+					return s.decodeValue(key, value, val.Interface())
+				})
+				if err != nil {
+					return err
+				}
+
+				ok, err := query.recordMatches(s, tx, key, val)
+				if err != nil {
+					return err
+				}
+				if ok {
+					records = append(records, &record{key: key, value: val})
+				}
+			}
+
+			for i := range records {
+				err := action(tx, storer, records[i])
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err == badger.ErrTxnTooBig && end-processed > 1 {
+			size = (end - processed) / 2
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		processed = end
+
+		if opts.Progress != nil {
+			err = opts.Progress(processed, keys[processed-1])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// recordMatches tests a record read outside of the query's iterator against the query and its ors
+func (q *Query) recordMatches(s *Store, tx *badger.Txn, key []byte, value reflect.Value) (bool, error) {
+	// the index criteria were handled by the iterator, make them be tested too
+	badIndex := q.badIndex
+	q.badIndex = true
+	defer func() {
+		q.badIndex = badIndex
+	}()
+
+	q.dataType = value.Elem().Type()
+	q.tx = tx
+
+	ok, err := q.matchesAllFields(s, key, value, value.Interface())
+	if ok || err != nil {
+		return ok, err
+	}
+
+	for i := range q.ors {
+		ok, err = q.ors[i].recordMatches(s, tx, key, value)
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+
+	return false, nil
+}
+
+// atomicityError returns db.ErrAtomicityRequired for a transaction too big for badger, the records of a
+// single transaction operation can't be split over several
+func atomicityError(err error) error {
+	if err == badger.ErrTxnTooBig {
+		return db.ErrAtomicityRequired
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+package hold
+
+import (
+	"context"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/pb"
+	"github.com/xurwxj/kvdb/db"
+)
+
+// cachePinTimeout is how long a record written by a transaction stays out of the cache
+// if the transaction is never committed
+const cachePinTimeout = time.Minute
+
+// cacheVersionStripes is the number of stripes the commit versions of the invalidated records are kept in
+const cacheVersionStripes = 256
+
+// cachedMiss is the cached value of a record that doesn't exist
+type cachedMiss struct{}
+
+// cachedRecord is the cached value of a record, decoded again by every get so results never share memory
+type cachedRecord struct {
+	value []byte
+}
+
+// CacheStats returns the hit and miss statistics of the record cache, or zero stats if the store
+// has no cache
+func (s *Store) CacheStats() db.CacheStats {
+	if s.cache == nil {
+		return db.CacheStats{}
+	}
+	return s.cache.Stats()
+}
+
+// cacheGet fills result from the cache, found is false if the record isn't cached
+func (s *Store) cacheGet(gk []byte, result interface{}, storer Storer) (found bool, err error) {
+	cached, ok := s.cache.Get(string(gk))
+	if !ok {
+		return false, nil
+	}
+
+	if _, miss := cached.(cachedMiss); miss {
+		return true, ErrNotFound
+	}
+
+	return true, s.decodeRecord(gk, cached.(cachedRecord).value, result, storer)
+}
+
+// cacheAdd caches the encoded value of a get of gk made with the reservation
+func (s *Store) cacheAdd(gk []byte, value []byte, err error, reservation uint64) {
+	if err == ErrNotFound && s.cacheMisses {
+		s.cache.Add(string(gk), cachedMiss{}, int64(len(gk)), reservation)
+		return
+	}
+	if err != nil {
+		s.cache.Release(string(gk), reservation)
+		return
+	}
+
+	s.cache.Add(string(gk), cachedRecord{value: value}, int64(len(gk)+len(value)), reservation)
+}
+
+// cacheCurrent reports whether tx reads gk at or after the last commit which invalidated it, a transaction
+// older than that reads a record the cache must not be filled with
+func (s *Store) cacheCurrent(tx *badger.Txn, gk []byte) bool {
+	return tx.ReadTs() >= atomic.LoadUint64(&s.cacheVersions[cacheStripe(gk)])
+}
+
+// cacheInvalidated records that a write of gk was committed at version
+func (s *Store) cacheInvalidated(gk []byte, version uint64) {
+	stripe := &s.cacheVersions[cacheStripe(gk)]
+	for {
+		current := atomic.LoadUint64(stripe)
+		if version <= current || atomic.CompareAndSwapUint64(stripe, current, version) {
+			return
+		}
+	}
+}
+
+func cacheStripe(gk []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(gk)
+	return h.Sum32() % cacheVersionStripes
+}
+
+// cacheWrite keeps a record written in tx out of the cache until the write is committed
+func (s *Store) cacheWrite(tx *badger.Txn, gk []byte) {
+	if s.cache == nil {
+		return
+	}
+
+	s.cache.Pin(string(gk), cachePinTimeout)
+	if written, ok := s.cacheWrites.Load(tx); ok {
+		keys := written.(*[]string)
+		*keys = append(*keys, string(gk))
+	}
+}
+
+// update runs fn in a read-write transaction, the records it writes can be cached again as soon as it's
+// committed rather than once the commit is seen by watchCache
+func (s *Store) update(fn func(tx *badger.Txn) error) error {
+	if s.cache == nil {
+		return s.db.Update(fn)
+	}
+
+	var written []string
+	defer func() {
+		for _, key := range written {
+			s.cache.Unpin(key)
+		}
+	}()
+
+	return s.db.Update(func(tx *badger.Txn) error {
+		s.cacheWrites.Store(tx, &written)
+		defer s.cacheWrites.Delete(tx)
+		return fn(tx)
+	})
+}
+
+// watchCache invalidates cached records as soon as writes to them made in any transaction are committed
+func (s *Store) watchCache(ctx context.Context) {
+	defer close(s.cacheDone)
+
+	s.db.Subscribe(ctx, func(kvs *badger.KVList) error {
+		for _, kv := range kvs.Kv {
+			// recorded before the record is invalidated, gets reserved later see the version
+			s.cacheInvalidated(kv.Key, kv.Version)
+			s.cache.Unpin(string(kv.Key))
+		}
+		return nil
+	}, []pb.Match{{Prefix: []byte(typePrefixRoot)}})
+}
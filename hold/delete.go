@@ -9,7 +9,7 @@ import (
 // Delete deletes a record from the bolthold, datatype just needs to be an example of the type stored so that
 // the proper bucket and indexes are updated
 func (s *Store) Delete(key, dataType interface{}) error {
-	return s.Badger().Update(func(tx *badger.Txn) error {
+	return s.update(func(tx *badger.Txn) error {
 		return s.TxDelete(tx, key, dataType)
 	})
 }
@@ -34,28 +34,50 @@ func (s *Store) TxDelete(tx *badger.Txn, key, dataType interface{}) error {
 	}
 
 	item.Value(func(bVal []byte) error {
-		return s.decode(bVal, value)
+		return s.decodeValue(gk, bVal, value)
 	})
 	if err != nil {
 		return err
 	}
 
+	err = beforeDelete(tx, value)
+	if err != nil {
+		return err
+	}
+
 	// delete data
+	s.cacheWrite(tx, gk)
 	err = tx.Delete(gk)
 
 	if err != nil {
 		return err
 	}
 
+	err = s.deleteExpiry(tx, gk)
+	if err != nil {
+		return err
+	}
+
 	// remove any indexes
-	return s.indexDelete(storer, tx, gk, value)
+	err = s.indexDelete(storer, tx, gk, value)
+	if err != nil {
+		return err
+	}
+
+	err = s.logChange(tx, storer, gk, ChangeDelete, value, nil)
+	if err != nil {
+		return err
+	}
+
+	return afterDelete(tx, value)
 }
 
-// DeleteMatching deletes all of the records that match the passed in query
+// DeleteMatching deletes all of the records that match the passed in query in a single transaction
+// It fails with db.ErrAtomicityRequired if the records don't fit in one, see DeleteMatchingInBatches.
 func (s *Store) DeleteMatching(dataType interface{}, query *Query) error {
-	return s.Badger().Update(func(tx *badger.Txn) error {
+	return atomicityError(s.update(func(tx *badger.Txn) error {
 		return s.TxDeleteMatching(tx, dataType, query)
-	})
+	}))
 }
 
 // TxDeleteMatching does the same as DeleteMatching, but allows you to specify your own transaction
@@ -1,6 +1,7 @@
 package hold_test
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -87,6 +88,103 @@ func TestDeleteMatching(t *testing.T) {
 	}
 }
 
+func TestDeleteMatchingInBatches(t *testing.T) {
+	for _, tst := range testResults {
+		t.Run(tst.name, func(t *testing.T) {
+			testWrap(t, func(store *hold.Store, t *testing.T) {
+
+				insertTestData(t, store)
+
+				batches := 0
+				err := store.DeleteMatchingInBatches(&ItemTest{}, tst.query, hold.BatchOptions{
+					Size: 2,
+					Progress: func(processed int, checkpoint []byte) error {
+						batches++
+						return nil
+					},
+				})
+				if err != nil {
+					t.Fatalf("Error deleting data from hold: %s", err)
+				}
+
+				if wanted := (len(tst.result) + 1) / 2; batches != wanted {
+					t.Fatalf("Delete ran in %d batches wanted %d", batches, wanted)
+				}
+
+				var result []ItemTest
+				err = store.Find(&result, nil)
+				if err != nil {
+					t.Fatalf("Error finding result after delete from hold: %s", err)
+				}
+
+				if len(result) != (len(testData) - len(tst.result)) {
+					t.Fatalf("Delete result count is %d wanted %d.", len(result),
+						(len(testData) - len(tst.result)))
+				}
+
+				for i := range result {
+					for k := range tst.result {
+						if result[i].equal(&testData[tst.result[k]]) {
+							t.Fatalf("Found %v in the result set when it should've been deleted!", result[i])
+						}
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestDeleteMatchingInBatchesResume(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		stop := errors.New("stop")
+		var checkpoint []byte
+		err := store.DeleteMatchingInBatches(&ItemTest{}, nil, hold.BatchOptions{
+			Size: 3,
+			Progress: func(processed int, last []byte) error {
+				checkpoint = last
+				return stop
+			},
+		})
+		if err != stop {
+			t.Fatalf("Got %v wanted the error returned by progress", err)
+		}
+
+		count, err := store.Count(&ItemTest{}, nil)
+		if err != nil {
+			t.Fatalf("Error counting: %s", err)
+		}
+		if count != len(testData)-3 {
+			t.Fatalf("Got %d records after the first batch wanted %d", count, len(testData)-3)
+		}
+
+		processed := 0
+		err = store.DeleteMatchingInBatches(&ItemTest{}, nil, hold.BatchOptions{
+			Size:   3,
+			Resume: checkpoint,
+			Progress: func(n int, last []byte) error {
+				processed = n
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error resuming delete: %s", err)
+		}
+		if processed != len(testData)-3 {
+			t.Fatalf("Resumed delete processed %d records wanted %d", processed, len(testData)-3)
+		}
+
+		count, err = store.Count(&ItemTest{}, nil)
+		if err != nil {
+			t.Fatalf("Error counting: %s", err)
+		}
+		if count != 0 {
+			t.Fatalf("Got %d records after resuming wanted 0", count)
+		}
+	})
+}
+
 func TestDeleteOnUnknownType(t *testing.T) {
 	testWrap(t, func(store *hold.Store, t *testing.T) {
 		insertTestData(t, store)
@@ -53,3 +53,45 @@ func (s *Store) encodeKey(key interface{}, typeName string) ([]byte, error) {
 func (s *Store) decodeKey(data []byte, key interface{}, typeName string) error {
 	return s.decode(data[len(typePrefix(typeName)):], key)
 }
+
+// encodeValue encodes a record value stored at the badger key
+func (s *Store) encodeValue(key []byte, value interface{}) ([]byte, error) {
+	encoded, err := s.encode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	// compressed before it's sealed, sealed values don't compress
+	if s.compressor != nil {
+		encoded, err = s.compressor.Compress(key, encoded)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.cipher == nil {
+		return encoded, nil
+	}
+	return s.cipher.Seal(key, encoded)
+}
+
+// decodeValue decodes a record value read from the badger key
+func (s *Store) decodeValue(key, data []byte, value interface{}) error {
+	if s.cipher != nil {
+		var err error
+		data, err = s.cipher.Open(key, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.compressor != nil {
+		var err error
+		data, err = s.compressor.Decompress(key, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.decode(data, value)
+}
@@ -0,0 +1,334 @@
+package hold
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// expiryPrefix is the prefix of the keys storing the expiry of the records with a TTL
+const expiryPrefix = "_bhExpiry:"
+
+// holdPrefixExpiresValue tags the time.Time field holding the time a record expires at
+const holdPrefixExpiresValue = "expires"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// expiry is stored next to every record with a TTL
+// Badger drops expired records on its own, but not the index entries pointing to them, Indexes are the
+// index keys the record was added to so they can be cleaned up once it's gone.
+type expiry struct {
+	ExpiresAt uint64
+	Indexes   [][]byte
+}
+
+// InsertWithTTL inserts the passed in data into the hold like Insert, the record expires after ttl
+// If the data struct has a field tagged as `hold:"expires"` and is passed by reference, the field is
+// set to the time it expires at.
+func (s *Store) InsertWithTTL(key, data interface{}, ttl time.Duration) error {
+	return s.write(func(tx *badger.Txn) error {
+		return s.TxInsertWithTTL(tx, key, data, ttl)
+	})
+}
+
+// TxInsertWithTTL is the same as InsertWithTTL except it allows you specify your own transaction
+func (s *Store) TxInsertWithTTL(tx *badger.Txn, key, data interface{}, ttl time.Duration) error {
+	return s.insert(tx, key, data, ttl)
+}
+
+// UpsertWithTTL inserts or updates the record like Upsert, the record expires after ttl
+func (s *Store) UpsertWithTTL(key, data interface{}, ttl time.Duration) error {
+	return s.write(func(tx *badger.Txn) error {
+		return s.TxUpsertWithTTL(tx, key, data, ttl)
+	})
+}
+
+// TxUpsertWithTTL is the same as UpsertWithTTL except it allows you specify your own transaction
+func (s *Store) TxUpsertWithTTL(tx *badger.Txn, key, data interface{}, ttl time.Duration) error {
+	storer := s.newStorer(data)
+
+	gk, err := s.encodeKey(key, storer.Type())
+	if err != nil {
+		return err
+	}
+
+	return s.upsertWithTTL(tx, storer, gk, data, ttl, true)
+}
+
+// SweepExpired removes the records expired by badger from the indexes they were in, and returns how many it
+// removed. Until they are swept, queries skip the index entries of expired records, and writing a record
+// again with the same key cleans up after its expired version.
+func (s *Store) SweepExpired() (int, error) {
+	now := uint64(time.Now().Unix())
+
+	var expired [][]byte
+	err := s.db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(expiryPrefix)
+		it := tx.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			var e expiry
+			err := it.Item().Value(func(v []byte) error {
+				return s.decode(v, &e)
+			})
+			if err != nil {
+				return err
+			}
+
+			if e.ExpiresAt <= now {
+				expired = append(expired, it.Item().KeyCopy(nil)[len(expiryPrefix):])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	swept := 0
+	for start := 0; start < len(expired); start += DefaultBatchSize {
+		end := start + DefaultBatchSize
+		if end > len(expired) {
+			end = len(expired)
+		}
+
+		count := 0
+		err := s.update(func(tx *badger.Txn) error {
+			count = 0
+			for _, gk := range expired[start:end] {
+				// the record may have been written again since it was listed
+				_, err := tx.Get(gk)
+				if err == nil {
+					continue
+				}
+				if err != badger.ErrKeyNotFound {
+					return err
+				}
+
+				e, err := s.getExpiry(tx, gk)
+				if err != nil {
+					return err
+				}
+				if e == nil || e.ExpiresAt > now {
+					continue
+				}
+
+				err = s.clearExpired(tx, gk, e)
+				if err != nil {
+					return err
+				}
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			return swept, err
+		}
+		swept += count
+	}
+
+	return swept, nil
+}
+
+// runSweeper calls SweepExpired every interval until the store is closed
+func (s *Store) runSweeper(interval time.Duration) {
+	defer close(s.sweepDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.SweepExpired()
+		case <-s.sweepStop:
+			return
+		}
+	}
+}
+
+// putRecord writes data as the record stored at gk and indexes it, the record expires at expiresAt unless it's
+// zero. old is the expiry of the record it replaces.
+func (s *Store) putRecord(tx *badger.Txn, storer Storer, gk []byte, data interface{}, old *expiry,
+	expiresAt uint64) error {
+	value, err := s.encodeValue(gk, data)
+	if err != nil {
+		return err
+	}
+
+	s.cacheWrite(tx, gk)
+	entry := badger.NewEntry(gk, value)
+	entry.ExpiresAt = expiresAt
+	err = tx.SetEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	err = s.indexAdd(storer, tx, gk, data)
+	if err != nil {
+		return err
+	}
+
+	if expiresAt == 0 {
+		if old == nil {
+			return nil
+		}
+		return tx.Delete(expiryKey(gk))
+	}
+
+	e := &expiry{ExpiresAt: expiresAt}
+	for name, index := range storer.Indexes() {
+		indexKey, err := index.IndexFunc(name, data)
+		if err != nil {
+			return err
+		}
+		if indexKey != nil {
+			e.Indexes = append(e.Indexes, append(indexKeyPrefix(storer.Type(), name), indexKey...))
+		}
+	}
+
+	value, err = s.encode(e)
+	if err != nil {
+		return err
+	}
+	return tx.Set(expiryKey(gk), value)
+}
+
+// getExpiry returns the expiry of the record stored at gk, nil if it doesn't expire
+func (s *Store) getExpiry(tx *badger.Txn, gk []byte) (*expiry, error) {
+	item, err := tx.Get(expiryKey(gk))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e := &expiry{}
+	err = item.Value(func(v []byte) error {
+		return s.decode(v, e)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// deleteExpiry removes the expiry of a record being deleted
+func (s *Store) deleteExpiry(tx *badger.Txn, gk []byte) error {
+	e, err := s.getExpiry(tx, gk)
+	if err != nil || e == nil {
+		return err
+	}
+	return tx.Delete(expiryKey(gk))
+}
+
+// clearExpired removes the record stored at gk, which badger expired, from the indexes it was in
+func (s *Store) clearExpired(tx *badger.Txn, gk []byte, e *expiry) error {
+	if e == nil {
+		return nil
+	}
+
+	for _, indexKey := range e.Indexes {
+		item, err := tx.Get(indexKey)
+		if err == badger.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		keys := make(keyList, 0)
+		err = item.Value(func(v []byte) error {
+			return s.decode(v, &keys)
+		})
+		if err != nil {
+			return err
+		}
+
+		if !keys.in(gk) {
+			continue
+		}
+		keys.remove(gk)
+
+		if len(keys) == 0 {
+			err = tx.Delete(indexKey)
+		} else {
+			var value []byte
+			value, err = s.encode(keys)
+			if err == nil {
+				err = tx.Set(indexKey, value)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Delete(expiryKey(gk))
+}
+
+func expiryKey(gk []byte) []byte {
+	return append([]byte(expiryPrefix), gk...)
+}
+
+// recordExpiry returns the unix time the record data expires at, or zero if it doesn't expire
+// A positive ttl sets the expires field of data, if it has one it can set, otherwise a non-zero field decides.
+// Types without an expires field, and records whose field is zero, keep the expiry kept, which the field is set to.
+func recordExpiry(data interface{}, ttl time.Duration, kept uint64) uint64 {
+	dataVal := reflect.Indirect(reflect.ValueOf(data))
+	field, hasField := getExpiresField(dataVal.Type())
+	if !hasField {
+		if ttl > 0 {
+			return unixExpiry(time.Now().Add(ttl))
+		}
+		return kept
+	}
+
+	fieldValue := dataVal.FieldByName(field.Name)
+	expiresAt := kept
+	if ttl > 0 {
+		expiresAt = unixExpiry(time.Now().Add(ttl))
+	} else if expires := fieldValue.Interface().(time.Time); !expires.IsZero() {
+		return unixExpiry(expires)
+	}
+
+	if expiresAt != 0 && fieldValue.CanSet() {
+		fieldValue.Set(reflect.ValueOf(time.Unix(int64(expiresAt), 0)))
+	}
+	return expiresAt
+}
+
+// unixExpiry returns the badger expiry of a record expiring at t, badger expires records to the second so t is
+// rounded up to the next second, records live at least their ttl and at most a second more
+func unixExpiry(t time.Time) uint64 {
+	seconds := t.Unix()
+	if t.Nanosecond() > 0 {
+		seconds++
+	}
+	return uint64(seconds)
+}
+
+// getExpiresField returns the time.Time field tagged as `hold:"expires"`
+func getExpiresField(tp reflect.Type) (reflect.StructField, bool) {
+	return getTimeField(tp, holdPrefixExpiresValue)
+}
+
+// liveKeys returns the keys of records which exist, dropping the ones badger expired
+func liveKeys(tx *badger.Txn, keys keyList) (keyList, error) {
+	live := make(keyList, 0, len(keys))
+	for _, key := range keys {
+		_, err := tx.Get(key)
+		if err == badger.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		live = append(live, key)
+	}
+	return live, nil
+}
@@ -0,0 +1,297 @@
+package hold_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/hold"
+)
+
+type Session struct {
+	ID      string    `hold:"key"`
+	User    string    `hold:"index"`
+	Token   string    `hold:"unique"`
+	Expires time.Time `hold:"expires"`
+}
+
+// recordExpiresAt returns the badger expiry of the record stored for key
+func recordExpiresAt(t *testing.T, store *hold.Store, typeName string, key interface{}) uint64 {
+	gk, err := hold.DefaultEncode(key)
+	if err != nil {
+		t.Fatalf("Error encoding key: %s", err)
+	}
+
+	var expiresAt uint64
+	err = store.Badger().View(func(tx *badger.Txn) error {
+		item, err := tx.Get(append([]byte("bh_"+typeName), gk...))
+		if err != nil {
+			return err
+		}
+		expiresAt = item.ExpiresAt()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading record: %s", err)
+	}
+	return expiresAt
+}
+
+func countExpiries(t *testing.T, store *hold.Store) int {
+	count := 0
+	err := store.Badger().View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte("_bhExpiry:")
+		it := tx.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error counting expiries: %s", err)
+	}
+	return count
+}
+
+func TestInsertWithTTL(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		session := &Session{User: "alice", Token: "t1"}
+		err := store.InsertWithTTL("s1", session, time.Hour)
+		if err != nil {
+			t.Fatalf("Error inserting data for test: %s", err)
+		}
+
+		// rounded up to the second
+		if session.Expires.Before(time.Now().Add(59*time.Minute)) ||
+			session.Expires.After(time.Now().Add(time.Hour+time.Second)) {
+			t.Fatalf("Expires field was set to %s, wanted an hour from now", session.Expires)
+		}
+		if expiresAt := recordExpiresAt(t, store, "Session", "s1"); expiresAt != uint64(session.Expires.Unix()) {
+			t.Fatalf("Record expires at %d, wanted %d", expiresAt, session.Expires.Unix())
+		}
+
+		result := &Session{}
+		err = store.Get("s1", result)
+		if err != nil {
+			t.Fatalf("Error getting data from hold: %s", err)
+		}
+		if !result.Expires.Equal(session.Expires) {
+			t.Fatalf("Got expiry %s wanted %s", result.Expires, session.Expires)
+		}
+
+		// the expires field decides without a TTL
+		result.Expires = time.Now().Add(2 * time.Hour).Truncate(time.Second)
+		err = store.Update("s1", result)
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+		if expiresAt := recordExpiresAt(t, store, "Session", "s1"); expiresAt != uint64(result.Expires.Unix()) {
+			t.Fatalf("Record expires at %d, wanted %d", expiresAt, result.Expires.Unix())
+		}
+
+		// a zero expires field, of a record passed by value, keeps the expiry stored
+		expires := result.Expires
+		err = store.Upsert("s1", Session{User: "alice", Token: "t1"})
+		if err != nil {
+			t.Fatalf("Error upserting data: %s", err)
+		}
+		if expiresAt := recordExpiresAt(t, store, "Session", "s1"); expiresAt != uint64(expires.Unix()) {
+			t.Fatalf("Record expires at %d, wanted it to keep expiring at %d", expiresAt, expires.Unix())
+		}
+
+		result.Expires = time.Time{}
+		err = store.Update("s1", result)
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+		if !result.Expires.Equal(expires) {
+			t.Fatalf("Expires field was set to %s, wanted the expiry kept %s", result.Expires, expires)
+		}
+	})
+}
+
+func TestSubSecondTTL(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		ttl := 300 * time.Millisecond
+		written := time.Now()
+		err := store.InsertWithTTL("key", &ItemTest{Name: "short"}, ttl)
+		if err != nil {
+			t.Fatalf("Error inserting data for test: %s", err)
+		}
+
+		// the expiry is rounded up, the record lives at least its ttl
+		expiresAt := recordExpiresAt(t, store, "ItemTest", "key")
+		if time.Unix(int64(expiresAt), 0).Before(written.Add(ttl)) {
+			t.Fatalf("Record expires at %d, before its ttl of %s", expiresAt, ttl)
+		}
+		if err = store.Get("key", &ItemTest{}); err != nil {
+			t.Fatalf("Error getting the record right after it was written: %s", err)
+		}
+	})
+}
+
+func TestUpdateKeepsTTL(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		err := store.UpsertWithTTL("key", &ItemTest{Name: "first", Category: "a"}, time.Hour)
+		if err != nil {
+			t.Fatalf("Error upserting data for test: %s", err)
+		}
+		expiresAt := recordExpiresAt(t, store, "ItemTest", "key")
+		if expiresAt == 0 {
+			t.Fatalf("Record doesn't expire")
+		}
+
+		err = store.Update("key", &ItemTest{Name: "second", Category: "b"})
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+		if got := recordExpiresAt(t, store, "ItemTest", "key"); got != expiresAt {
+			t.Fatalf("Update changed the expiry from %d to %d", expiresAt, got)
+		}
+
+		err = store.UpdateMatching(&ItemTest{}, hold.Where("Category").Eq("b").Index("Category"),
+			func(record interface{}) error {
+				record.(*ItemTest).Name = "third"
+				return nil
+			})
+		if err != nil {
+			t.Fatalf("Error updating matching data: %s", err)
+		}
+		if got := recordExpiresAt(t, store, "ItemTest", "key"); got != expiresAt {
+			t.Fatalf("UpdateMatching changed the expiry from %d to %d", expiresAt, got)
+		}
+
+		err = store.Upsert("key", &ItemTest{Name: "fourth", Category: "b"})
+		if err != nil {
+			t.Fatalf("Error upserting data: %s", err)
+		}
+		if got := recordExpiresAt(t, store, "ItemTest", "key"); got != expiresAt {
+			t.Fatalf("Upsert changed the expiry from %d to %d", expiresAt, got)
+		}
+
+		err = store.Delete("key", &ItemTest{})
+		if err != nil {
+			t.Fatalf("Error deleting data: %s", err)
+		}
+		if count := countExpiries(t, store); count != 0 {
+			t.Fatalf("%d expiries left after delete, wanted 0", count)
+		}
+	})
+}
+
+func TestExpiredIndexes(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		// records expiring in the past are expired by badger right away, leaving their index entries behind
+		past := time.Now().Add(-time.Minute)
+		for _, session := range []*Session{
+			{ID: "expired", User: "alice", Token: "t1", Expires: past},
+			{ID: "expired2", User: "alice", Token: "t2", Expires: past},
+			{ID: "live", User: "alice", Token: "t3", Expires: time.Now().Add(time.Hour)},
+			{ID: "forever", User: "bob", Token: "t4"},
+		} {
+			err := store.Insert(session.ID, session)
+			if err != nil {
+				t.Fatalf("Error inserting data for test: %s", err)
+			}
+		}
+
+		var result []Session
+		err := store.Find(&result, hold.Where("User").Eq("alice").Index("User"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(result) != 1 || result[0].ID != "live" {
+			t.Fatalf("Found %v, wanted only the live session", result)
+		}
+
+		count, err := store.Count(&Session{}, hold.Where("Token").Eq("t1").Index("Token"))
+		if err != nil || count != 0 {
+			t.Fatalf("Counted %d, %v, wanted no record", count, err)
+		}
+
+		// the unique value of an expired record can be used again
+		err = store.Insert("new", &Session{User: "carol", Token: "t1"})
+		if err != nil {
+			t.Fatalf("Error inserting the token of an expired record: %s", err)
+		}
+		err = store.Insert("new2", &Session{User: "carol", Token: "t3"})
+		if err != hold.ErrUniqueExists {
+			t.Fatalf("Got %v inserting the token of a live record, wanted ErrUniqueExists", err)
+		}
+
+		// inserting with the key of an expired record cleans up after it
+		err = store.Insert("expired2", &Session{User: "dave", Token: "t5"})
+		if err != nil {
+			t.Fatalf("Error inserting with the key of an expired record: %s", err)
+		}
+
+		swept, err := store.SweepExpired()
+		if err != nil {
+			t.Fatalf("Error sweeping: %s", err)
+		}
+		if swept != 1 {
+			t.Fatalf("Swept %d records, wanted 1", swept)
+		}
+		if count := countExpiries(t, store); count != 1 {
+			t.Fatalf("%d expiries left, wanted the live one", count)
+		}
+
+		// no index entry points to an expired record anymore
+		err = store.Badger().View(func(tx *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = []byte("_bhIndex:Session:")
+			it := tx.NewIterator(opts)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				var keys [][]byte
+				err := it.Item().Value(func(v []byte) error {
+					return hold.DefaultDecode(v, &keys)
+				})
+				if err != nil {
+					return err
+				}
+				for _, key := range keys {
+					if _, err := tx.Get(key); err != nil {
+						t.Fatalf("Index %s points to %q: %s", it.Item().Key(), key, err)
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error reading indexes: %s", err)
+		}
+	})
+}
+
+func TestSweeper(t *testing.T) {
+	opt := testOptions()
+	opt.SweepInterval = 100 * time.Millisecond
+	store, err := hold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	err = store.InsertWithTTL("s1", &Session{User: "alice", Token: "t1"}, time.Second)
+	if err != nil {
+		t.Fatalf("Error inserting data for test: %s", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for countExpiries(t, store) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expired record wasn't swept")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	err = store.Get("s1", &Session{})
+	if err != hold.ErrNotFound {
+		t.Fatalf("Got %v, wanted the record to be expired", err)
+	}
+}
@@ -0,0 +1,264 @@
+package hold
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// ImportError is returned when a record of an import can't be read, Line is the position of the record
+// in the import starting at 1
+type ImportError struct {
+	Line int
+	Err  error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("import line %d: %s", e.Line, e.Err)
+}
+
+// Unwrap returns the error which made the record fail
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// exportedRecord is a line of an export
+type exportedRecord struct {
+	Type  string          `json:"type"`
+	Key   json.RawMessage `json:"key,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+// keyTyped is a type without a key field passed to an export or import along with the type of its keys
+type keyTyped struct {
+	dataType interface{}
+	keyType  reflect.Type
+}
+
+// WithKeyType returns dataType, a type without a key field, to pass to Export, ExportMatching, Import and
+// ValidateImport so the keys of its records are exported and imported as values of the type of key
+func WithKeyType(dataType, key interface{}) interface{} {
+	return &keyTyped{dataType: dataType, keyType: reflect.TypeOf(key)}
+}
+
+// exportType returns the type passed to an export or import, the type of its records and the type of its keys
+func exportType(dataType interface{}) (interface{}, reflect.Type, reflect.Type) {
+	var keyType reflect.Type
+	if typed, ok := dataType.(*keyTyped); ok {
+		dataType, keyType = typed.dataType, typed.keyType
+	}
+
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	if keyField, ok := getKeyField(tp); ok {
+		keyType = keyField.Type
+	}
+	return dataType, tp, keyType
+}
+
+// importedRecord is a record of an import ready to be written
+type importedRecord struct {
+	storer Storer
+	key    []byte
+	value  interface{}
+}
+
+// Export writes every record of the passed in types to w as JSON lines holding the type name, key and value
+// of a record. Unlike a backup, an export doesn't depend on the encoder of the store and can be read by people.
+// Types without a key field have to be passed through WithKeyType.
+func (s *Store) Export(w io.Writer, dataTypes ...interface{}) error {
+	return s.db.View(func(tx *badger.Txn) error {
+		enc := json.NewEncoder(w)
+		for _, dataType := range dataTypes {
+			err := s.export(tx, enc, dataType, nil)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ExportMatching writes the records that match the passed in query to w, in the same format as Export
+func (s *Store) ExportMatching(w io.Writer, dataType interface{}, query *Query) error {
+	return s.db.View(func(tx *badger.Txn) error {
+		return s.export(tx, json.NewEncoder(w), dataType, query)
+	})
+}
+
+func (s *Store) export(tx *badger.Txn, enc *json.Encoder, dataType interface{}, query *Query) error {
+	if query == nil {
+		query = &Query{}
+	}
+
+	dataType, _, keyType := exportType(dataType)
+	storer := s.newStorer(dataType)
+	typeName := storer.Type()
+	if keyType == nil {
+		return fmt.Errorf("The type %s has no key field, pass it through WithKeyType to export its keys", typeName)
+	}
+
+	return s.runQuery(tx, dataType, query, nil, query.skip, func(r *record) error {
+		line := exportedRecord{Type: typeName}
+
+		key := reflect.New(keyType)
+		err := s.decodeKey(r.key, key.Interface(), typeName)
+		if err != nil {
+			return err
+		}
+
+		line.Key, err = json.Marshal(key.Interface())
+		if err != nil {
+			return err
+		}
+
+		line.Value, err = json.Marshal(r.value.Interface())
+		if err != nil {
+			return err
+		}
+
+		return enc.Encode(line)
+	})
+}
+
+// Import upserts the records of an export read from r and rebuilds their indexes, every type in the
+// import must be passed in. It returns the number of records imported.
+// Records are written in batches of DefaultBatchSize, if an import fails the records of the batches
+// already written stay imported.
+func (s *Store) Import(r io.Reader, dataTypes ...interface{}) (int, error) {
+	var batch []*importedRecord
+	imported := 0
+
+	write := func() error {
+		err := s.update(func(tx *badger.Txn) error {
+			for _, rec := range batch {
+				err := s.upsert(tx, rec.storer, rec.key, rec.value)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		imported += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	err := s.readImport(r, dataTypes, func(rec *importedRecord) error {
+		batch = append(batch, rec)
+		if len(batch) < DefaultBatchSize {
+			return nil
+		}
+		return write()
+	})
+	if err != nil {
+		return imported, err
+	}
+
+	if len(batch) > 0 {
+		err = write()
+	}
+	return imported, err
+}
+
+// ValidateImport reads an export from r and checks every record could be imported, without writing anything
+// It returns the number of records read, and an *ImportError for the first invalid record.
+func (s *Store) ValidateImport(r io.Reader, dataTypes ...interface{}) (int, error) {
+	count := 0
+	err := s.readImport(r, dataTypes, func(rec *importedRecord) error {
+		_, err := s.encodeValue(rec.key, rec.value)
+		if err != nil {
+			return err
+		}
+
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// readImport decodes the records of the export read from r and calls fn with each of them
+func (s *Store) readImport(r io.Reader, dataTypes []interface{}, fn func(rec *importedRecord) error) error {
+	storers := make(map[string]Storer, len(dataTypes))
+	types := make(map[string]reflect.Type, len(dataTypes))
+	keyTypes := make(map[string]reflect.Type, len(dataTypes))
+	for _, dataType := range dataTypes {
+		dataType, tp, keyType := exportType(dataType)
+		storer := s.newStorer(dataType)
+
+		storers[storer.Type()] = storer
+		types[storer.Type()] = tp
+		keyTypes[storer.Type()] = keyType
+	}
+
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var exported exportedRecord
+		err := dec.Decode(&exported)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &ImportError{Line: line, Err: err}
+		}
+
+		rec, err := s.importRecord(&exported, storers, types, keyTypes)
+		if err == nil {
+			err = fn(rec)
+		}
+		if err != nil {
+			return &ImportError{Line: line, Err: err}
+		}
+	}
+}
+
+func (s *Store) importRecord(exported *exportedRecord, storers map[string]Storer,
+	types map[string]reflect.Type, keyTypes map[string]reflect.Type) (*importedRecord, error) {
+	storer, ok := storers[exported.Type]
+	if !ok {
+		return nil, fmt.Errorf("The type %s was not passed in to the import", exported.Type)
+	}
+	tp := types[exported.Type]
+
+	value := reflect.New(tp)
+	err := json.Unmarshal(exported.Value, value.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &importedRecord{storer: storer, value: value.Interface()}
+
+	keyType := keyTypes[exported.Type]
+	switch {
+	case keyType != nil && exported.Key != nil:
+		key := reflect.New(keyType)
+		err = json.Unmarshal(exported.Key, key.Interface())
+		if err != nil {
+			return nil, err
+		}
+
+		if keyField, ok := getKeyField(tp); ok {
+			value.Elem().FieldByName(keyField.Name).Set(key.Elem())
+		}
+		rec.key, err = s.encodeKey(key.Elem().Interface(), exported.Type)
+		if err != nil {
+			return nil, err
+		}
+	case exported.Key != nil:
+		return nil, fmt.Errorf("The type %s has no key field, pass it through WithKeyType to import its keys",
+			exported.Type)
+	default:
+		return nil, fmt.Errorf("The record of type %s has no key", exported.Type)
+	}
+
+	return rec, nil
+}
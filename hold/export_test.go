@@ -0,0 +1,147 @@
+package hold_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/xurwxj/kvdb/hold"
+)
+
+type ExportKeyed struct {
+	ID   string `holdKey:"ID"`
+	Name string `holdIndex:"Name"`
+}
+
+func TestExportImport(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		keyed := []ExportKeyed{{Name: "first"}, {Name: "second"}}
+		for i, k := range []string{"one", "two"} {
+			err := store.Insert(k, &keyed[i])
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		var export bytes.Buffer
+		err := store.Export(&export, &ItemTest{}, &ExportKeyed{})
+		if err == nil {
+			t.Fatalf("Exported a type without a key field or key type")
+		}
+		export.Reset()
+
+		err = store.Export(&export, hold.WithKeyType(&ItemTest{}, 0), &ExportKeyed{})
+		if err != nil {
+			t.Fatalf("Error exporting hold: %s", err)
+		}
+
+		lines := strings.Count(export.String(), "\n")
+		if lines != len(testData)+len(keyed) {
+			t.Fatalf("Exported %d lines wanted %d", lines, len(testData)+len(keyed))
+		}
+		if !strings.Contains(export.String(), `"key":"two"`) {
+			t.Fatalf("Export is missing the decoded key of a keyed record: %s", export.String())
+		}
+
+		// imported with another encoder
+		opt := testOptions()
+		opt.Encoder = json.Marshal
+		opt.Decoder = json.Unmarshal
+		imported, err := hold.Open(opt)
+		if err != nil {
+			t.Fatalf("Error opening %s: %s", opt.Dir, err)
+		}
+		defer os.RemoveAll(opt.Dir)
+		defer imported.Close()
+
+		count, err := imported.ValidateImport(bytes.NewReader(export.Bytes()), hold.WithKeyType(&ItemTest{}, 0),
+			&ExportKeyed{})
+		if err != nil {
+			t.Fatalf("Error validating import: %s", err)
+		}
+		if count != lines {
+			t.Fatalf("Validated %d records wanted %d", count, lines)
+		}
+		if n, _ := imported.Count(&ItemTest{}, nil); n != 0 {
+			t.Fatalf("Validating an import wrote %d records", n)
+		}
+
+		count, err = imported.Import(&export, hold.WithKeyType(&ItemTest{}, 0), &ExportKeyed{})
+		if err != nil {
+			t.Fatalf("Error importing: %s", err)
+		}
+		if count != lines {
+			t.Fatalf("Imported %d records wanted %d", count, lines)
+		}
+
+		var result []ItemTest
+		err = imported.Find(&result, hold.Where("Category").Eq("vehicle").Index("Category"))
+		if err != nil {
+			t.Fatalf("Error finding imported data: %s", err)
+		}
+		var wanted []ItemTest
+		err = store.Find(&wanted, hold.Where("Category").Eq("vehicle").Index("Category"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(result) == 0 || len(result) != len(wanted) {
+			t.Fatalf("Found %d imported records by index wanted %d", len(result), len(wanted))
+		}
+
+		var item ItemTest
+		err = imported.Get(testData[3].Key, &item)
+		if err != nil {
+			t.Fatalf("Error getting imported record by key: %s", err)
+		}
+		if !item.equal(&testData[3]) {
+			t.Fatalf("Got %+v for the imported record wanted %+v", item, testData[3])
+		}
+
+		var got ExportKeyed
+		err = imported.Get("two", &got)
+		if err != nil {
+			t.Fatalf("Error getting imported keyed record: %s", err)
+		}
+		if got.ID != "two" || got.Name != "second" {
+			t.Fatalf("Got %+v for the imported keyed record", got)
+		}
+	})
+}
+
+func TestImportInvalid(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		tests := []struct {
+			name   string
+			export string
+		}{
+			{"Unknown Type", `{"type":"Unknown","key":1,"value":{}}`},
+			{"No Key", `{"type":"ItemTest","value":{"Name":"nokey"}}`},
+			{"No Key Type", `{"type":"BadType","key":1,"value":{}}`},
+			{"Bad Key", `{"type":"ItemTest","key":"one","value":{"Name":"keyed"}}`},
+			{"Raw Key", `{"type":"ItemTest","rawKey":"AQ==","value":{"Name":"raw"}}`},
+			{"Bad Value", `{"type":"ItemTest","key":1,"value":{"Name":1}}`},
+			{"Bad JSON", `{"type":`},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				export := `{"type":"ItemTest","key":1,"value":{"Name":"valid"}}` + "\n" + tst.export + "\n"
+
+				_, err := store.ValidateImport(strings.NewReader(export), hold.WithKeyType(&ItemTest{}, 0),
+					&BadType{})
+				var importErr *hold.ImportError
+				if !errors.As(err, &importErr) {
+					t.Fatalf("Got %v validating an invalid import wanted an *ImportError", err)
+				}
+				if importErr.Line != 2 {
+					t.Fatalf("Got an error on line %d wanted line 2", importErr.Line)
+				}
+			})
+		}
+	})
+}
@@ -28,15 +28,53 @@ func (s *Store) TxGet(tx *badger.Txn, key, result interface{}) error {
 		return err
 	}
 
+	if s.cache == nil {
+		_, _, err = s.txGet(tx, gk, result, storer)
+		return err
+	}
+
+	if found, err := s.cacheGet(gk, result, storer); found {
+		return err
+	}
+
+	reservation := s.cache.Reserve(string(gk))
+	value, expiresAt, err := s.txGet(tx, gk, result, storer)
+	if expiresAt != 0 || !s.cacheCurrent(tx, gk) {
+		// the cache doesn't expire entries, records with a TTL are always read from badger, and tx may read a
+		// record older than a write already committed
+		s.cache.Release(string(gk), reservation)
+		return err
+	}
+	s.cacheAdd(gk, value, err, reservation)
+	return err
+}
+
+// txGet reads the record stored at gk into result and returns its encoded value and the time it expires at
+func (s *Store) txGet(tx *badger.Txn, gk []byte, result interface{}, storer Storer) ([]byte, uint64, error) {
 	item, err := tx.Get(gk)
 	if err == badger.ErrKeyNotFound {
-		return ErrNotFound
+		return nil, 0, ErrNotFound
+	}
+	if err != nil {
+		return nil, 0, err
 	}
 
-	err = item.Value(func(value []byte) error {
-		return s.decode(value, result)
-	})
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = s.decodeRecord(gk, value, result, storer)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return value, item.ExpiresAt(), nil
+}
 
+// decodeRecord decodes the value of the record stored at gk into result, along with its key field
+func (s *Store) decodeRecord(gk []byte, value []byte, result interface{}, storer Storer) error {
+	err := s.decodeValue(gk, value, result)
 	if err != nil {
 		return err
 	}
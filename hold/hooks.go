@@ -0,0 +1,141 @@
+package hold
+
+import (
+	"reflect"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// The hooks below are optional interfaces of the records written to a store, detected on the record or a pointer
+// to it. They run within the transaction writing the record, an error returned by a hook aborts the write and is
+// returned by it. Hooks don't run for the records written by Import.
+
+// BeforeInserter is implemented by records running code before they are inserted, or upserted without an existing
+// record, after their version and timestamps are set
+type BeforeInserter interface {
+	BeforeInsert(tx *badger.Txn) error
+}
+
+// AfterInserter is implemented by records running code after they are inserted
+type AfterInserter interface {
+	AfterInsert(tx *badger.Txn) error
+}
+
+// BeforeUpdater is implemented by records running code before they replace a record, old is a pointer to the
+// record being replaced
+type BeforeUpdater interface {
+	BeforeUpdate(tx *badger.Txn, old interface{}) error
+}
+
+// AfterUpdater is implemented by records running code after they replaced a record
+type AfterUpdater interface {
+	AfterUpdate(tx *badger.Txn) error
+}
+
+// BeforeDeleter is implemented by records running code before they are deleted
+type BeforeDeleter interface {
+	BeforeDelete(tx *badger.Txn) error
+}
+
+// AfterDeleter is implemented by records running code after they are deleted
+type AfterDeleter interface {
+	AfterDelete(tx *badger.Txn) error
+}
+
+// Validator is implemented by records checking they can be written, after the Before hooks ran
+type Validator interface {
+	Validate() error
+}
+
+var hookTypes = []reflect.Type{
+	reflect.TypeOf((*BeforeInserter)(nil)).Elem(),
+	reflect.TypeOf((*AfterInserter)(nil)).Elem(),
+	reflect.TypeOf((*BeforeUpdater)(nil)).Elem(),
+	reflect.TypeOf((*AfterUpdater)(nil)).Elem(),
+	reflect.TypeOf((*BeforeDeleter)(nil)).Elem(),
+	reflect.TypeOf((*AfterDeleter)(nil)).Elem(),
+	reflect.TypeOf((*Validator)(nil)).Elem(),
+}
+
+// hooked returns data as a pointer if it's passed by value but has hooks with pointer receivers
+func hooked(data interface{}) interface{} {
+	tp := reflect.TypeOf(data)
+	if tp.Kind() == reflect.Ptr {
+		return data
+	}
+
+	ptrType := reflect.PtrTo(tp)
+	for _, hookType := range hookTypes {
+		if !tp.Implements(hookType) && ptrType.Implements(hookType) {
+			data, _ = settable(data)
+			return data
+		}
+	}
+	return data
+}
+
+// beforeInsert runs the BeforeInsert and Validate hooks of data
+func beforeInsert(tx *badger.Txn, data interface{}) error {
+	if hook, ok := data.(BeforeInserter); ok {
+		err := hook.BeforeInsert(tx)
+		if err != nil {
+			return err
+		}
+	}
+	return validate(data)
+}
+
+func afterInsert(tx *badger.Txn, data interface{}) error {
+	if hook, ok := data.(AfterInserter); ok {
+		return hook.AfterInsert(tx)
+	}
+	return nil
+}
+
+// beforeUpdate runs the BeforeUpdate and Validate hooks of data
+func beforeUpdate(tx *badger.Txn, data, old interface{}) error {
+	if hook, ok := data.(BeforeUpdater); ok {
+		err := hook.BeforeUpdate(tx, recordPtr(old))
+		if err != nil {
+			return err
+		}
+	}
+	return validate(data)
+}
+
+func afterUpdate(tx *badger.Txn, data interface{}) error {
+	if hook, ok := data.(AfterUpdater); ok {
+		return hook.AfterUpdate(tx)
+	}
+	return nil
+}
+
+func beforeDelete(tx *badger.Txn, data interface{}) error {
+	if hook, ok := recordPtr(data).(BeforeDeleter); ok {
+		return hook.BeforeDelete(tx)
+	}
+	return nil
+}
+
+func afterDelete(tx *badger.Txn, data interface{}) error {
+	if hook, ok := recordPtr(data).(AfterDeleter); ok {
+		return hook.AfterDelete(tx)
+	}
+	return nil
+}
+
+func validate(data interface{}) error {
+	if validator, ok := data.(Validator); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+// recordPtr returns a pointer to the record data points to, through any number of pointers
+func recordPtr(data interface{}) interface{} {
+	dataVal := reflect.ValueOf(data)
+	for dataVal.Kind() == reflect.Ptr && dataVal.Elem().Kind() == reflect.Ptr {
+		dataVal = dataVal.Elem()
+	}
+	return dataVal.Interface()
+}
@@ -0,0 +1,182 @@
+package hold_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/hold"
+)
+
+var errInvalidAccount = errors.New("account name is required")
+
+// hookCalls lists the hooks run, in order
+var hookCalls []string
+
+type Account struct {
+	ID     string `hold:"key"`
+	Name   string `hold:"index"`
+	Search string
+}
+
+func (a *Account) BeforeInsert(tx *badger.Txn) error {
+	hookCalls = append(hookCalls, "BeforeInsert "+a.Name)
+	a.Search = strings.ToLower(a.Name)
+	return nil
+}
+
+func (a *Account) AfterInsert(tx *badger.Txn) error {
+	hookCalls = append(hookCalls, "AfterInsert "+a.Name)
+	return nil
+}
+
+func (a *Account) BeforeUpdate(tx *badger.Txn, old interface{}) error {
+	hookCalls = append(hookCalls, "BeforeUpdate "+old.(*Account).Name+" "+a.Name)
+	a.Search = strings.ToLower(a.Name)
+	return nil
+}
+
+func (a *Account) AfterUpdate(tx *badger.Txn) error {
+	hookCalls = append(hookCalls, "AfterUpdate "+a.Name)
+	return nil
+}
+
+func (a *Account) BeforeDelete(tx *badger.Txn) error {
+	hookCalls = append(hookCalls, "BeforeDelete "+a.Name)
+	if a.Name == "Locked" {
+		return errors.New("locked")
+	}
+	return nil
+}
+
+func (a *Account) AfterDelete(tx *badger.Txn) error {
+	hookCalls = append(hookCalls, "AfterDelete "+a.Name)
+	// write within the deleting transaction
+	return tx.Set([]byte("deleted:"+a.Name), nil)
+}
+
+func (a *Account) Validate() error {
+	if a.Name == "" {
+		return errInvalidAccount
+	}
+	return nil
+}
+
+func expectHooks(t *testing.T, expected ...string) {
+	t.Helper()
+	if !reflect.DeepEqual(hookCalls, expected) {
+		t.Fatalf("Hooks ran %q, wanted %q", hookCalls, expected)
+	}
+	hookCalls = nil
+}
+
+func TestHooks(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		hookCalls = nil
+
+		account := &Account{Name: "Alice"}
+		err := store.Insert("a", account)
+		if err != nil {
+			t.Fatalf("Error inserting data for test: %s", err)
+		}
+		expectHooks(t, "BeforeInsert Alice", "AfterInsert Alice")
+		if account.ID != "a" || account.Search != "alice" {
+			t.Fatalf("Inserted %+v, wanted its key and search fields set", account)
+		}
+
+		// passed by value, the pointer hooks still run on a copy
+		err = store.Upsert("b", Account{Name: "Bob"})
+		if err != nil {
+			t.Fatalf("Error upserting data: %s", err)
+		}
+		expectHooks(t, "BeforeInsert Bob", "AfterInsert Bob")
+
+		result := &Account{}
+		if err = store.Get("b", result); err != nil {
+			t.Fatalf("Error getting data from hold: %s", err)
+		}
+		if result.Search != "bob" {
+			t.Fatalf("BeforeInsert changes weren't stored: %+v", result)
+		}
+
+		err = store.Update("a", &Account{Name: "Alicia"})
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+		expectHooks(t, "BeforeUpdate Alice Alicia", "AfterUpdate Alicia")
+
+		err = store.Upsert("a", &Account{Name: "Ally"})
+		if err != nil {
+			t.Fatalf("Error upserting data: %s", err)
+		}
+		expectHooks(t, "BeforeUpdate Alicia Ally", "AfterUpdate Ally")
+
+		err = store.UpdateMatching(&Account{}, hold.Where("Name").Eq("Bob").Index("Name"),
+			func(record interface{}) error {
+				record.(*Account).Name = "Robert"
+				return nil
+			})
+		if err != nil {
+			t.Fatalf("Error updating matching data: %s", err)
+		}
+		expectHooks(t, "BeforeUpdate Bob Robert", "AfterUpdate Robert")
+
+		err = store.Delete("a", &Account{})
+		if err != nil {
+			t.Fatalf("Error deleting data: %s", err)
+		}
+		expectHooks(t, "BeforeDelete Ally", "AfterDelete Ally")
+
+		err = store.DeleteMatching(&Account{}, nil)
+		if err != nil {
+			t.Fatalf("Error deleting matching data: %s", err)
+		}
+		expectHooks(t, "BeforeDelete Robert", "AfterDelete Robert")
+
+		err = store.Badger().View(func(tx *badger.Txn) error {
+			_, err := tx.Get([]byte("deleted:Robert"))
+			return err
+		})
+		if err != nil {
+			t.Fatalf("AfterDelete write wasn't committed: %s", err)
+		}
+	})
+}
+
+func TestHooksAbort(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		hookCalls = nil
+
+		err := store.Insert("a", &Account{})
+		if err != errInvalidAccount {
+			t.Fatalf("Got %v, wanted the validation error", err)
+		}
+		expectHooks(t, "BeforeInsert ")
+
+		if err = store.Get("a", &Account{}); err != hold.ErrNotFound {
+			t.Fatalf("Invalid record was inserted: %v", err)
+		}
+
+		err = store.Insert("locked", &Account{Name: "Locked"})
+		if err != nil {
+			t.Fatalf("Error inserting data for test: %s", err)
+		}
+
+		err = store.Update("locked", &Account{})
+		if err != errInvalidAccount {
+			t.Fatalf("Got %v, wanted the validation error", err)
+		}
+
+		err = store.DeleteMatching(&Account{}, nil)
+		if err == nil || err.Error() != "locked" {
+			t.Fatalf("Got %v, wanted the BeforeDelete error", err)
+		}
+
+		count, err := store.Count(&Account{}, hold.Where("Name").Eq("Locked").Index("Name"))
+		if err != nil || count != 1 {
+			t.Fatalf("Counted %d, %v, wanted the locked account to be left as it was", count, err)
+		}
+	})
+}
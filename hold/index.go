@@ -70,15 +70,23 @@ func (s *Store) indexUpdate(typeName, indexName string, index Index, tx *badger.
 	}
 
 	if err != badger.ErrKeyNotFound {
-		if index.Unique && !delete {
-			return ErrUniqueExists
-		}
 		err = item.Value(func(iVal []byte) error {
 			return s.decode(iVal, &indexValue)
 		})
 		if err != nil {
 			return err
 		}
+
+		if index.Unique && !delete {
+			// records expired by badger stay listed until they are swept
+			indexValue, err = liveKeys(tx, indexValue)
+			if err != nil {
+				return err
+			}
+			if len(indexValue) > 0 {
+				return ErrUniqueExists
+			}
+		}
 	}
 
 	if delete {
@@ -227,7 +235,7 @@ func (s *Store) newIterator(tx *badger.Txn, typeName string, query *Query, bookm
 					val := reflect.New(query.dataType)
 
 					err := item.Value(func(v []byte) error {
-						return s.decode(v, val.Interface())
+						return s.decodeValue(key, v, val.Interface())
 					})
 					if err != nil {
 						return nil, err
@@ -312,39 +320,45 @@ func (i *iterator) Next() (key []byte, value []byte) {
 		return nil, nil
 	}
 
-	if len(i.keyCache) == 0 {
-		newKeys, err := i.nextKeys(i.iter)
+	for {
+		if len(i.keyCache) == 0 {
+			newKeys, err := i.nextKeys(i.iter)
+			if err != nil {
+				i.err = err
+				return nil, nil
+			}
+
+			if len(newKeys) == 0 {
+				return nil, nil
+			}
+
+			i.keyCache = append(i.keyCache, newKeys...)
+		}
+
+		key = i.keyCache[0]
+		i.keyCache = i.keyCache[1:]
+
+		item, err := i.tx.Get(key)
+		if err == badger.ErrKeyNotFound {
+			// the index still points to a record badger expired
+			continue
+		}
 		if err != nil {
 			i.err = err
 			return nil, nil
 		}
 
-		if len(newKeys) == 0 {
+		err = item.Value(func(val []byte) error {
+			value = val
+			return nil
+		})
+		if err != nil {
+			i.err = err
 			return nil, nil
 		}
 
-		i.keyCache = append(i.keyCache, newKeys...)
-	}
-
-	key = i.keyCache[0]
-	i.keyCache = i.keyCache[1:]
-
-	item, err := i.tx.Get(key)
-	if err != nil {
-		i.err = err
-		return nil, nil
-	}
-
-	err = item.Value(func(val []byte) error {
-		value = val
-		return nil
-	})
-	if err != nil {
-		i.err = err
-		return nil, nil
+		return key, value
 	}
-
-	return
 }
 
 // Error returns the last error, iterator.Next() will not continue if there is an error present
@@ -0,0 +1,381 @@
+package hold
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/pb"
+	"github.com/xurwxj/kvdb/db"
+)
+
+// outboxPrefix is the prefix of the keys of the events emitted and not delivered yet, followed by the topic, a
+// zero byte and the sequence of the event
+const outboxPrefix = "_bhOutbox:"
+
+// outboxHeadPrefix is the prefix of the keys holding the sequence of the last event emitted to a topic,
+// followed by the topic
+const outboxHeadPrefix = "_bhOutboxHead:"
+
+// errBadOutboxHead is returned when the head of a topic can't be decoded
+var errBadOutboxHead = errors.New("invalid outbox head")
+
+// outboxEntry is an event waiting in the outbox
+type outboxEntry struct {
+	Payload  []byte
+	Emitted  int64
+	Attempts int
+}
+
+// OutboxEvent is an event emitted to a topic, passed to the handler of Dispatch
+// Attempts is the number of times the delivery of the event failed before.
+type OutboxEvent struct {
+	Topic    string
+	Sequence uint64
+	Emitted  time.Time
+	Attempts int
+
+	key     []byte
+	payload []byte
+	store   *Store
+}
+
+// Decode decodes the payload of the event into payload
+func (e *OutboxEvent) Decode(payload interface{}) error {
+	return e.store.decodeValue(e.key, e.payload, payload)
+}
+
+// DispatchOptions allows you to change how Dispatch delivers events
+type DispatchOptions struct {
+	// Topics are the topics delivered, every topic if empty
+	Topics []string
+	// Backoff returns how long to wait before delivering an event again after it failed attempts times,
+	// doubling from 100ms up to a minute by default
+	Backoff func(attempts int) time.Duration
+	// PollInterval is how often events are looked for besides when they are emitted, a second by default
+	PollInterval time.Duration
+}
+
+// Emit adds an event with payload to the outbox of topic, see TxEmit
+// Emit is retried after a backoff while it conflicts with other transactions emitting to topic, and returns
+// badger.ErrConflict once db.DefaultTxnRetries attempts conflicted.
+func (s *Store) Emit(topic string, payload interface{}) (err error) {
+	for i := 0; i < db.DefaultTxnRetries; i++ {
+		if i > 0 {
+			db.RetryBackoff(i)
+		}
+		err = s.update(func(tx *badger.Txn) error {
+			return s.TxEmit(tx, topic, payload)
+		})
+		if err != badger.ErrConflict {
+			return err
+		}
+	}
+	return err
+}
+
+// TxEmit adds an event with payload to the outbox of topic within tx, the event is delivered by Dispatch only
+// if tx is committed, along with the records it writes. Events of a topic are delivered in the order their
+// transactions were committed in: the sequence of the event is drawn from the head of the topic within tx, so
+// transactions emitting to the same topic conflict, and all but the first of them to commit fail with
+// badger.ErrConflict and have to be retried.
+func (s *Store) TxEmit(tx *badger.Txn, topic string, payload interface{}) error {
+	seq, err := s.outboxHead(tx, topic)
+	if err != nil {
+		return err
+	}
+	seq++
+	err = tx.Set(outboxHeadKey(topic), encodeSequence(seq))
+	if err != nil {
+		return err
+	}
+	key := outboxKey(topic, seq)
+
+	entry := &outboxEntry{Emitted: s.now().UnixNano()}
+	entry.Payload, err = s.encodeValue(key, payload)
+	if err != nil {
+		return err
+	}
+
+	value, err := s.encode(entry)
+	if err != nil {
+		return err
+	}
+	return tx.Set(key, value)
+}
+
+// Dispatch calls handler with the events emitted to the outbox until ctx is done, and removes the events it
+// handled. An event the handler returns an error for is delivered again after a backoff, the following events
+// of its topic wait for it, events are delivered at least once. Dispatch returns ctx.Err() once ctx is done,
+// or the first error reading or removing events. Run a single dispatcher per topic.
+func (s *Store) Dispatch(ctx context.Context, opts DispatchOptions, handler func(event *OutboxEvent) error) error {
+	if opts.Backoff == nil {
+		opts.Backoff = defaultBackoff
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// wake up as soon as events are emitted
+	wake := make(chan struct{}, 1)
+	subscribed := make(chan error, 1)
+	go func() {
+		subscribed <- s.db.Subscribe(ctx, func(kvs *badger.KVList) error {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+			return nil
+		}, []pb.Match{{Prefix: []byte(outboxPrefix)}})
+	}()
+
+	retryAt := make(map[string]time.Time)
+	for {
+		next, err := s.dispatchPending(ctx, opts, retryAt, handler)
+		if err != nil {
+			return err
+		}
+
+		wait := opts.PollInterval
+		if !next.IsZero() && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case err = <-subscribed:
+			timer.Stop()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err == nil {
+				// the subscription ends without an error when the store is closed
+				err = badger.ErrDBClosed
+			}
+			return err
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// dispatchPending delivers the pending events whose retry is due, and returns the time the earliest retry is
+// due at, zero if there's none. retryAt holds the retry time of the events which failed, by key.
+func (s *Store) dispatchPending(ctx context.Context, opts DispatchOptions, retryAt map[string]time.Time,
+	handler func(event *OutboxEvent) error) (time.Time, error) {
+	for {
+		var next time.Time
+		events, full, err := s.pendingEvents(opts.Topics, retryAt, &next)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		blocked := make(map[string]bool)
+		for _, event := range events {
+			if ctx.Err() != nil {
+				return time.Time{}, nil
+			}
+			if blocked[event.Topic] {
+				continue
+			}
+
+			if handler(event) != nil {
+				blocked[event.Topic] = true
+				retry := time.Now().Add(opts.Backoff(event.Attempts + 1))
+				retryAt[string(event.key)] = retry
+				if next.IsZero() || retry.Before(next) {
+					next = retry
+				}
+
+				err = s.outboxFailed(event.key)
+			} else {
+				delete(retryAt, string(event.key))
+				err = s.db.Update(func(tx *badger.Txn) error {
+					return tx.Delete(event.key)
+				})
+			}
+			if err != nil {
+				return time.Time{}, err
+			}
+		}
+
+		if !full {
+			return next, nil
+		}
+	}
+}
+
+// pendingEvents reads a batch of pending events of topics, every topic if empty, in key order
+// The topics of events waiting for a retry are skipped, next is set to the earliest retry time. full is true if
+// there may be more events than the batch holds.
+func (s *Store) pendingEvents(topics []string, retryAt map[string]time.Time, next *time.Time) (
+	events []*OutboxEvent, full bool, err error) {
+	prefixes := [][]byte{[]byte(outboxPrefix)}
+	if len(topics) != 0 {
+		prefixes = prefixes[:0]
+		for _, topic := range topics {
+			prefixes = append(prefixes, outboxTopicPrefix(topic))
+		}
+	}
+
+	now := time.Now()
+	err = s.db.View(func(tx *badger.Txn) error {
+		for _, prefix := range prefixes {
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = prefix
+			it := tx.NewIterator(opts)
+
+			for it.Rewind(); it.Valid(); {
+				if len(events) == DefaultBatchSize {
+					full = true
+					break
+				}
+
+				key := it.Item().KeyCopy(nil)
+				topic, seq := outboxKeyParts(key)
+				if retry, ok := retryAt[string(key)]; ok && retry.After(now) {
+					if next.IsZero() || retry.Before(*next) {
+						*next = retry
+					}
+					// skip the rest of the topic, its events wait for this one
+					it.Seek(append([]byte(outboxPrefix+topic), 1))
+					continue
+				}
+
+				var entry outboxEntry
+				err := it.Item().Value(func(v []byte) error {
+					return s.decode(v, &entry)
+				})
+				if err != nil {
+					it.Close()
+					return err
+				}
+
+				events = append(events, &OutboxEvent{
+					Topic:    topic,
+					Sequence: seq,
+					Emitted:  time.Unix(0, entry.Emitted),
+					Attempts: entry.Attempts,
+					key:      key,
+					payload:  entry.Payload,
+					store:    s,
+				})
+				it.Next()
+			}
+			it.Close()
+		}
+		return nil
+	})
+	return events, full, err
+}
+
+// outboxFailed counts a failed delivery of the event stored at key
+func (s *Store) outboxFailed(key []byte) error {
+	return s.db.Update(func(tx *badger.Txn) error {
+		item, err := tx.Get(key)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var entry outboxEntry
+		err = item.Value(func(v []byte) error {
+			return s.decode(v, &entry)
+		})
+		if err != nil {
+			return err
+		}
+
+		entry.Attempts++
+		value, err := s.encode(entry)
+		if err != nil {
+			return err
+		}
+		return tx.Set(key, value)
+	})
+}
+
+// defaultBackoff doubles from 100ms up to a minute
+func defaultBackoff(attempts int) time.Duration {
+	backoff := 100 * time.Millisecond
+	for i := 1; i < attempts && backoff < time.Minute; i++ {
+		backoff *= 2
+	}
+	if backoff > time.Minute {
+		backoff = time.Minute
+	}
+	return backoff
+}
+
+// outboxHead returns the sequence of the last event emitted to topic within tx, reading it conflicts with
+// every other transaction emitting to topic
+func (s *Store) outboxHead(tx *badger.Txn, topic string) (uint64, error) {
+	item, err := tx.Get(outboxHeadKey(topic))
+	if err == nil {
+		var seq uint64
+		err = item.Value(func(v []byte) error {
+			if len(v) != 8 {
+				return errBadOutboxHead
+			}
+			seq = binary.BigEndian.Uint64(v)
+			return nil
+		})
+		return seq, err
+	}
+	if err != badger.ErrKeyNotFound {
+		return 0, err
+	}
+
+	// outboxes written before topics had a head continue from their last pending event
+	opts := badger.DefaultIteratorOptions
+	opts.Reverse = true
+	opts.PrefetchValues = false
+	prefix := outboxTopicPrefix(topic)
+	opts.Prefix = prefix
+	it := tx.NewIterator(opts)
+	defer it.Close()
+
+	it.Seek(append(append([]byte{}, prefix...), 0xff))
+	if !it.ValidForPrefix(prefix) {
+		return 0, nil
+	}
+	_, seq := outboxKeyParts(it.Item().Key())
+	return seq, nil
+}
+
+func outboxHeadKey(topic string) []byte {
+	return []byte(outboxHeadPrefix + topic)
+}
+
+func encodeSequence(seq uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seq)
+	return b
+}
+
+func outboxTopicPrefix(topic string) []byte {
+	return append([]byte(outboxPrefix+topic), 0)
+}
+
+func outboxKey(topic string, seq uint64) []byte {
+	key := outboxTopicPrefix(topic)
+	key = append(key, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(key[len(key)-8:], seq)
+	return key
+}
+
+// outboxKeyParts returns the topic and sequence of an outbox key
+func outboxKeyParts(key []byte) (string, uint64) {
+	return string(key[len(outboxPrefix) : len(key)-9]), binary.BigEndian.Uint64(key[len(key)-8:])
+}
@@ -0,0 +1,189 @@
+package hold_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/hold"
+)
+
+type OrderPlaced struct {
+	OrderID uint64
+	Total   int
+}
+
+// dispatch runs a dispatcher sending the events it handles to delivered, handle decides if they're handled
+func dispatch(store *hold.Store, opts hold.DispatchOptions, handle func(event *hold.OutboxEvent) error) (
+	delivered chan string, cancel func() error) {
+	ctx, stop := context.WithCancel(context.Background())
+	delivered = make(chan string, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- store.Dispatch(ctx, opts, func(event *hold.OutboxEvent) error {
+			var payload OrderPlaced
+			if err := event.Decode(&payload); err != nil {
+				return err
+			}
+			err := handle(event)
+			if err == nil {
+				delivered <- fmt.Sprintf("%s %d", event.Topic, payload.OrderID)
+			}
+			return err
+		})
+	}()
+
+	return delivered, func() error {
+		stop()
+		return <-done
+	}
+}
+
+func nextDelivery(t *testing.T, delivered chan string, expected string) {
+	t.Helper()
+	select {
+	case got := <-delivered:
+		if got != expected {
+			t.Fatalf("Delivered %s, wanted %s", got, expected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %s", expected)
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		err := store.Badger().Update(func(tx *badger.Txn) error {
+			err := store.TxInsert(tx, uint64(1), &Order{Status: "new"})
+			if err != nil {
+				return err
+			}
+			return store.TxEmit(tx, "orders", OrderPlaced{OrderID: 1})
+		})
+		if err != nil {
+			t.Fatalf("Error inserting data for test: %s", err)
+		}
+
+		// events of transactions not committed are never delivered
+		err = store.Badger().Update(func(tx *badger.Txn) error {
+			err := store.TxEmit(tx, "orders", OrderPlaced{OrderID: 2})
+			if err != nil {
+				return err
+			}
+			return errors.New("rolled back")
+		})
+		if err == nil {
+			t.Fatalf("Transaction wasn't rolled back")
+		}
+
+		delivered, cancel := dispatch(store, hold.DispatchOptions{}, func(event *hold.OutboxEvent) error {
+			return nil
+		})
+		nextDelivery(t, delivered, "orders 1")
+
+		for i := uint64(3); i <= 5; i++ {
+			err = store.Emit("orders", OrderPlaced{OrderID: i})
+			if err != nil {
+				t.Fatalf("Error emitting event: %s", err)
+			}
+		}
+		nextDelivery(t, delivered, "orders 3")
+		nextDelivery(t, delivered, "orders 4")
+		nextDelivery(t, delivered, "orders 5")
+
+		if err = cancel(); err != context.Canceled {
+			t.Fatalf("Dispatch returned %v, wanted context.Canceled", err)
+		}
+
+		// delivered events are removed
+		delivered, cancel = dispatch(store, hold.DispatchOptions{PollInterval: 10 * time.Millisecond},
+			func(event *hold.OutboxEvent) error {
+				return nil
+			})
+		defer cancel()
+		select {
+		case got := <-delivered:
+			t.Fatalf("Delivered %s again", got)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+}
+
+func TestOutboxRetry(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		for i, topic := range []string{"orders", "orders", "emails"} {
+			err := store.Emit(topic, OrderPlaced{OrderID: uint64(i + 1)})
+			if err != nil {
+				t.Fatalf("Error emitting event: %s", err)
+			}
+		}
+
+		var attempts []int
+		var failing *uint64
+		opts := hold.DispatchOptions{
+			Topics: []string{"orders", "emails"},
+			Backoff: func(attempts int) time.Duration {
+				return 20 * time.Millisecond
+			},
+		}
+		delivered, cancel := dispatch(store, opts, func(event *hold.OutboxEvent) error {
+			if event.Topic != "orders" {
+				return nil
+			}
+			if failing == nil {
+				failing = &event.Sequence
+			}
+			if event.Sequence != *failing {
+				return nil
+			}
+
+			attempts = append(attempts, event.Attempts)
+			if event.Attempts < 2 {
+				return errors.New("unavailable")
+			}
+			return nil
+		})
+		defer cancel()
+
+		// emails aren't held back by the failing orders
+		nextDelivery(t, delivered, "emails 3")
+		// the second order waits for the first
+		nextDelivery(t, delivered, "orders 1")
+		nextDelivery(t, delivered, "orders 2")
+
+		if fmt.Sprint(attempts) != "[0 1 2]" {
+			t.Fatalf("Attempted %v, wanted the first order retried twice", attempts)
+		}
+	})
+}
+
+func TestOutboxCommitOrder(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		first := store.Badger().NewTransaction(true)
+		defer first.Discard()
+		if err := store.TxEmit(first, "orders", OrderPlaced{OrderID: 1}); err != nil {
+			t.Fatalf("Error emitting event: %s", err)
+		}
+
+		// emitted later, committed first
+		if err := store.Emit("orders", OrderPlaced{OrderID: 2}); err != nil {
+			t.Fatalf("Error emitting event: %s", err)
+		}
+		if err := first.Commit(); err != badger.ErrConflict {
+			t.Fatalf("Committed %v, wanted badger.ErrConflict", err)
+		}
+		if err := store.Emit("orders", OrderPlaced{OrderID: 1}); err != nil {
+			t.Fatalf("Error emitting event: %s", err)
+		}
+
+		delivered, cancel := dispatch(store, hold.DispatchOptions{}, func(event *hold.OutboxEvent) error {
+			return nil
+		})
+		defer cancel()
+		nextDelivery(t, delivered, "orders 2")
+		nextDelivery(t, delivered, "orders 1")
+	})
+}
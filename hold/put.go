@@ -3,6 +3,7 @@ package hold
 import (
 	"errors"
 	"reflect"
+	"time"
 
 	"github.com/dgraph-io/badger/v3"
 )
@@ -33,13 +34,18 @@ func NextSequence() interface{} {
 //
 // To use this with hold.NextSequence() use a type of `uint64` for the key field.
 func (s *Store) Insert(key, data interface{}) error {
-	return s.Badger().Update(func(tx *badger.Txn) error {
+	return s.write(func(tx *badger.Txn) error {
 		return s.TxInsert(tx, key, data)
 	})
 }
 
 // TxInsert is the same as Insert except it allows you specify your own transaction
 func (s *Store) TxInsert(tx *badger.Txn, key, data interface{}) error {
+	return s.insert(tx, key, data, 0)
+}
+
+// insert inserts data at key, the record expires after ttl if it's positive
+func (s *Store) insert(tx *badger.Txn, key, data interface{}, ttl time.Duration) error {
 	storer := s.newStorer(data)
 	var err error
 
@@ -61,51 +67,126 @@ func (s *Store) TxInsert(tx *badger.Txn, key, data interface{}) error {
 		return ErrKeyExists
 	}
 
-	value, err := s.encode(data)
+	// clean up after an expired record with the same key
+	expired, err := s.getExpiry(tx, gk)
+	if err != nil {
+		return err
+	}
+	err = s.clearExpired(tx, gk, expired)
 	if err != nil {
 		return err
 	}
 
-	// insert data
-	err = tx.Set(gk, value)
+	// insert a copy of data and any indexes, as the first version of the record
+	now := s.now()
+	written := withTimestamps(withVersion(hooked(writeCopy(data)), 1), now, now)
+	err = beforeInsert(tx, written)
+	if err != nil {
+		return err
+	}
 
+	err = s.putRecord(tx, storer, gk, written, nil, recordExpiry(written, ttl, 0))
 	if err != nil {
 		return err
 	}
 
-	// insert any indexes
-	err = s.indexAdd(storer, tx, gk, data)
+	err = s.logChange(tx, storer, gk, ChangeInsert, nil, written)
 	if err != nil {
 		return err
 	}
 
+	setKeyField(written, key)
+	err = afterInsert(tx, written)
+	if err != nil {
+		return err
+	}
+
+	s.onCommit(tx, func() { copyBack(data, written) })
+	return nil
+}
+
+// writeCopy returns a copy of the record data points to, so its version, timestamps and key field can be set
+// and its hooks run without changing data until the write is committed. Records passed by value are returned
+// as is, they are copied when their fields are set.
+func writeCopy(data interface{}) interface{} {
+	dataVal := reflect.ValueOf(data)
+	if dataVal.Kind() != reflect.Ptr {
+		return data
+	}
+
+	copied := reflect.New(dataVal.Elem().Type())
+	copied.Elem().Set(dataVal.Elem())
+	return copied.Interface()
+}
+
+// copyBack copies the record written to the one data points to, if data is passed by reference
+func copyBack(data, written interface{}) {
+	dataVal := reflect.ValueOf(data)
+	if dataVal.Kind() != reflect.Ptr || dataVal.IsNil() {
+		return
+	}
+	dataVal.Elem().Set(reflect.ValueOf(written).Elem())
+}
+
+// write runs fn in a read-write transaction like update, and runs the funcs fn registered with onCommit once the
+// transaction is committed
+func (s *Store) write(fn func(tx *badger.Txn) error) error {
+	var committed []func()
+	err := s.update(func(tx *badger.Txn) error {
+		s.commitHooks.Store(tx, &committed)
+		defer s.commitHooks.Delete(tx)
+		return fn(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range committed {
+		hook()
+	}
+	return nil
+}
+
+// onCommit runs hook once the transaction tx started by write is committed, or right away if tx is the
+// caller's own transaction, whose commit the store doesn't see
+func (s *Store) onCommit(tx *badger.Txn, hook func()) {
+	committed, ok := s.commitHooks.Load(tx)
+	if !ok {
+		hook()
+		return
+	}
+	list := committed.(*[]func())
+	*list = append(*list, hook)
+}
+
+// setKeyField sets the key field of data to the key it was inserted at, if data is passed by reference and
+// its key field is of the type of the key and still has its zero value
+func setKeyField(data, key interface{}) {
 	dataVal := reflect.Indirect(reflect.ValueOf(data))
 	if !dataVal.CanSet() {
-		return nil
+		return
 	}
 
 	if keyField, ok := getKeyField(dataVal.Type()); ok {
 		fieldValue := dataVal.FieldByName(keyField.Name)
 		keyValue := reflect.ValueOf(key)
 		if keyValue.Type() != keyField.Type {
-			return nil
+			return
 		}
 		if !fieldValue.CanSet() {
-			return nil
+			return
 		}
 		if !reflect.DeepEqual(fieldValue.Interface(), reflect.Zero(keyField.Type).Interface()) {
-			return nil
+			return
 		}
 		fieldValue.Set(keyValue)
 	}
-
-	return nil
 }
 
 // Update updates an existing record in the hold
 // if the Key doesn't already exist in the store, then it fails with ErrNotFound
 func (s *Store) Update(key interface{}, data interface{}) error {
-	return s.Badger().Update(func(tx *badger.Txn) error {
+	return s.write(func(tx *badger.Txn) error {
 		return s.TxUpdate(tx, key, data)
 	})
 }
@@ -132,35 +213,57 @@ func (s *Store) TxUpdate(tx *badger.Txn, key interface{}, data interface{}) erro
 	existingVal := reflect.New(reflect.TypeOf(data)).Interface()
 
 	err = existingItem.Value(func(existing []byte) error {
-		return s.decode(existing, existingVal)
+		return s.decodeValue(gk, existing, existingVal)
 	})
 	if err != nil {
 		return err
 	}
+
+	// the next version and timestamps are set on a copy of data, copied back once the write is committed
+	written, err := nextVersion(storer.Type(), hooked(writeCopy(data)), recordVersion(existingVal))
+	if err != nil {
+		return err
+	}
+	written = withTimestamps(written, recordCreated(existingVal), s.now())
+	err = beforeUpdate(tx, written, existingVal)
+	if err != nil {
+		return err
+	}
+
 	err = s.indexDelete(storer, tx, gk, existingVal)
 	if err != nil {
 		return err
 	}
 
-	value, err := s.encode(data)
+	old, err := s.getExpiry(tx, gk)
+	if err != nil {
+		return err
+	}
+
+	// put data and insert any new indexes, keeping the existing expiry
+	err = s.putRecord(tx, storer, gk, written, old, recordExpiry(written, 0, existingItem.ExpiresAt()))
+	if err != nil {
+		return err
+	}
+
+	err = s.logChange(tx, storer, gk, ChangeUpdate, existingVal, written)
 	if err != nil {
 		return err
 	}
 
-	// put data
-	err = tx.Set(gk, value)
+	err = afterUpdate(tx, written)
 	if err != nil {
 		return err
 	}
 
-	// insert any new indexes
-	return s.indexAdd(storer, tx, gk, data)
+	s.onCommit(tx, func() { copyBack(data, written) })
+	return nil
 }
 
 // Upsert inserts the record into the hold if it doesn't exist.  If it does already exist, then it updates
 // the existing record
 func (s *Store) Upsert(key interface{}, data interface{}) error {
-	return s.Badger().Update(func(tx *badger.Txn) error {
+	return s.write(func(tx *badger.Txn) error {
 		return s.TxUpsert(tx, key, data)
 	})
 }
@@ -175,51 +278,126 @@ func (s *Store) TxUpsert(tx *badger.Txn, key interface{}, data interface{}) erro
 		return err
 	}
 
+	return s.upsertWithTTL(tx, storer, gk, data, 0, true)
+}
+
+// upsert writes data to the record stored at gk and replaces the indexes of the record it overwrites
+// The version and timestamps of data are written as is, and its hooks don't run.
+func (s *Store) upsert(tx *badger.Txn, storer Storer, gk []byte, data interface{}) error {
+	return s.upsertWithTTL(tx, storer, gk, data, 0, false)
+}
+
+// upsertWithTTL is upsert for a record expiring after ttl if it's positive
+// With managed set, the version of data is checked against the stored one and incremented, its timestamps are set
+// and its hooks run.
+func (s *Store) upsertWithTTL(tx *badger.Txn, storer Storer, gk []byte, data interface{}, ttl time.Duration,
+	managed bool) error {
 	existingItem, err := tx.Get(gk)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
 
-	if err == nil {
+	old, err := s.getExpiry(tx, gk)
+	if err != nil {
+		return err
+	}
+
+	// a managed write sets the version and timestamps on a copy of data, copied back once the write is committed
+	written := data
+	if managed {
+		written = hooked(writeCopy(data))
+	}
+
+	var existingVal interface{}
+	if existingItem != nil {
 		// existing entry found
 		// delete any existing indexes
-		existingVal := reflect.New(reflect.TypeOf(data)).Interface()
+		existingVal = reflect.New(reflect.TypeOf(data)).Interface()
 
 		err = existingItem.Value(func(existing []byte) error {
-			return s.decode(existing, existingVal)
+			return s.decodeValue(gk, existing, existingVal)
 		})
 		if err != nil {
 			return err
 		}
 
+		if managed {
+			written, err = nextVersion(storer.Type(), written, recordVersion(existingVal))
+			if err != nil {
+				return err
+			}
+			written = withTimestamps(written, recordCreated(existingVal), s.now())
+			err = beforeUpdate(tx, written, existingVal)
+			if err != nil {
+				return err
+			}
+		}
+
 		err = s.indexDelete(storer, tx, gk, existingVal)
 		if err != nil {
 			return err
 		}
-	} else if err != badger.ErrKeyNotFound {
-		return err
-	}
+	} else {
+		if managed {
+			written, err = nextVersion(storer.Type(), written, 0)
+			if err != nil {
+				return err
+			}
+			now := s.now()
+			written = withTimestamps(written, now, now)
+			err = beforeInsert(tx, written)
+			if err != nil {
+				return err
+			}
+		}
 
-	// existing entry not found
+		// existing entry not found, clean up after an expired record with the same key
+		err = s.clearExpired(tx, gk, old)
+		if err != nil {
+			return err
+		}
+		old = nil
+	}
 
-	value, err := s.encode(data)
+	// put data and insert any new indexes, an existing record keeps its expiry unless a ttl is given, like Update
+	var kept uint64
+	if existingItem != nil {
+		kept = existingItem.ExpiresAt()
+	}
+	err = s.putRecord(tx, storer, gk, written, old, recordExpiry(written, ttl, kept))
 	if err != nil {
 		return err
 	}
 
-	// put data
-	err = tx.Set(gk, value)
+	if existingItem != nil {
+		err = s.logChange(tx, storer, gk, ChangeUpdate, existingVal, written)
+	} else {
+		err = s.logChange(tx, storer, gk, ChangeInsert, nil, written)
+	}
+	if err != nil || !managed {
+		return err
+	}
+
+	if existingItem != nil {
+		err = afterUpdate(tx, written)
+	} else {
+		err = afterInsert(tx, written)
+	}
 	if err != nil {
 		return err
 	}
 
-	// insert any new indexes
-	return s.indexAdd(storer, tx, gk, data)
+	s.onCommit(tx, func() { copyBack(data, written) })
+	return nil
 }
 
-// UpdateMatching runs the update function for every record that match the passed in query
+// UpdateMatching runs the update function for every record that match the passed in query in a single
+// transaction, it fails with db.ErrAtomicityRequired if the records don't fit in one, see UpdateMatchingInBatches.
 // Note that the type  of record in the update func always has to be a pointer
 func (s *Store) UpdateMatching(dataType interface{}, query *Query, update func(record interface{}) error) error {
-	return s.Badger().Update(func(tx *badger.Txn) error {
+	return atomicityError(s.update(func(tx *badger.Txn) error {
 		return s.TxUpdateMatching(tx, dataType, query, update)
-	})
+	}))
 }
 
 // TxUpdateMatching does the same as UpdateMatching, but allows you to specify your own transaction
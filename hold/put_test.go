@@ -2,10 +2,12 @@ package hold_test
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
 	"github.com/xurwxj/kvdb/hold"
 )
 
@@ -277,6 +279,85 @@ func TestUpdateMatching(t *testing.T) {
 	}
 }
 
+func TestUpdateMatchingTooBig(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		// too big for a single transaction
+		value := strings.Repeat("v", 1<<20-1024)
+		err := store.UpdateMatching(&ItemTest{}, nil, func(record interface{}) error {
+			record.(*ItemTest).UpdateField = value
+			return nil
+		})
+		if err != db.ErrAtomicityRequired {
+			t.Fatalf("Got %v updating too many records wanted %s", err, db.ErrAtomicityRequired)
+		}
+
+		err = store.UpdateMatchingInBatches(&ItemTest{}, nil, hold.BatchOptions{}, func(record interface{}) error {
+			record.(*ItemTest).UpdateField = value
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error updating in batches: %s", err)
+		}
+	})
+}
+
+func TestUpdateMatchingInBatches(t *testing.T) {
+	for _, tst := range testResults {
+		t.Run(tst.name, func(t *testing.T) {
+			testWrap(t, func(store *hold.Store, t *testing.T) {
+
+				insertTestData(t, store)
+
+				err := store.UpdateMatchingInBatches(&ItemTest{}, tst.query, hold.BatchOptions{Size: 2},
+					func(record interface{}) error {
+						update, ok := record.(*ItemTest)
+						if !ok {
+							return fmt.Errorf("Record isn't the correct type!  Wanted Itemtest, got %T",
+								record)
+						}
+
+						update.UpdateField = "updated"
+						update.UpdateIndex = "updated index"
+
+						return nil
+					})
+
+				if err != nil {
+					t.Fatalf("Error updating data from hold: %s", err)
+				}
+
+				var result []ItemTest
+				err = store.Find(&result, hold.Where("UpdateIndex").Eq("updated index").
+					And("UpdateField").Eq("updated"))
+				if err != nil {
+					t.Fatalf("Error finding result after update from hold: %s", err)
+				}
+
+				if len(result) != len(tst.result) {
+					t.Fatalf("Find result count after update is %d wanted %d.", len(result),
+						len(tst.result))
+				}
+
+				for i := range result {
+					found := false
+					for k := range tst.result {
+						if result[i].Key == testData[tst.result[k]].Key {
+							found = true
+							break
+						}
+					}
+
+					if !found {
+						t.Fatalf("Could not find %v in the updated result set!", result[i])
+					}
+				}
+			})
+		})
+	}
+}
+
 func TestIssue14(t *testing.T) {
 	testWrap(t, func(store *hold.Store, t *testing.T) {
 		key := "testKey"
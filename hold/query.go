@@ -6,6 +6,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/dgraph-io/badger/v3"
@@ -603,7 +604,7 @@ func (s *Store) runQuery(tx *badger.Txn, dataType interface{}, query *Query, ret
 
 		val := reflect.New(reflect.TypeOf(tp))
 
-		err := s.decode(v, val.Interface())
+		err := s.decodeValue(k, v, val.Interface())
 		if err != nil {
 			return err
 		}
@@ -859,13 +860,7 @@ func (s *Store) deleteQuery(tx *badger.Txn, dataType interface{}, query *Query)
 	storer := s.newStorer(dataType)
 
 	for i := range records {
-		err := tx.Delete(records[i].key)
-		if err != nil {
-			return err
-		}
-
-		// remove any indexes
-		err = s.indexDelete(storer, tx, records[i].key, records[i].value.Interface())
+		err := s.deleteRecord(tx, storer, records[i])
 		if err != nil {
 			return err
 		}
@@ -874,6 +869,37 @@ func (s *Store) deleteQuery(tx *badger.Txn, dataType interface{}, query *Query)
 	return nil
 }
 
+func (s *Store) deleteRecord(tx *badger.Txn, storer Storer, r *record) error {
+	err := beforeDelete(tx, r.value.Interface())
+	if err != nil {
+		return err
+	}
+
+	s.cacheWrite(tx, r.key)
+	err = tx.Delete(r.key)
+	if err != nil {
+		return err
+	}
+
+	err = s.deleteExpiry(tx, r.key)
+	if err != nil {
+		return err
+	}
+
+	// remove any indexes
+	err = s.indexDelete(storer, tx, r.key, r.value.Interface())
+	if err != nil {
+		return err
+	}
+
+	err = s.logChange(tx, storer, r.key, ChangeDelete, r.value.Interface(), nil)
+	if err != nil {
+		return err
+	}
+
+	return afterDelete(tx, r.value.Interface())
+}
+
 func (s *Store) updateQuery(tx *badger.Txn, dataType interface{}, query *Query, update func(record interface{}) error) error {
 	if query == nil {
 		query = &Query{}
@@ -896,37 +922,65 @@ func (s *Store) updateQuery(tx *badger.Txn, dataType interface{}, query *Query,
 
 	storer := s.newStorer(dataType)
 	for i := range records {
-		upVal := records[i].value.Interface()
-
-		// delete any existing indexes bad on original value
-		err := s.indexDelete(storer, tx, records[i].key, upVal)
+		err := s.updateRecord(tx, storer, records[i], update)
 		if err != nil {
 			return err
 		}
+	}
 
-		err = update(upVal)
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		encVal, err := s.encode(upVal)
-		if err != nil {
-			return err
-		}
+func (s *Store) updateRecord(tx *badger.Txn, storer Storer, r *record, update func(record interface{}) error) error {
+	upVal := r.value.Interface()
+	stored := recordVersion(upVal)
+	// shallow copy of the record for the BeforeUpdate hook
+	old := reflect.New(r.value.Elem().Type())
+	old.Elem().Set(r.value.Elem())
 
-		err = tx.Set(records[i].key, encVal)
-		if err != nil {
-			return err
-		}
+	// delete any existing indexes bad on original value
+	err := s.indexDelete(storer, tx, r.key, upVal)
+	if err != nil {
+		return err
+	}
 
-		// insert any new indexes
-		err = s.indexAdd(storer, tx, records[i].key, upVal)
-		if err != nil {
-			return err
-		}
+	err = update(upVal)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	// the record is stored at the next version, unless the update func set another version than the one stored
+	upVal, err = nextVersion(storer.Type(), upVal, stored)
+	if err != nil {
+		return err
+	}
+	upVal = withTimestamps(upVal, time.Time{}, s.now())
+	err = beforeUpdate(tx, upVal, old.Interface())
+	if err != nil {
+		return err
+	}
+
+	existing, err := s.getExpiry(tx, r.key)
+	if err != nil {
+		return err
+	}
+	var kept uint64
+	if existing != nil {
+		kept = existing.ExpiresAt
+	}
+
+	// put data and insert any new indexes, keeping the existing expiry
+	err = s.putRecord(tx, storer, r.key, upVal, existing, recordExpiry(upVal, 0, kept))
+	if err != nil {
+		return err
+	}
+
+	err = s.logChange(tx, storer, r.key, ChangeUpdate, old.Interface(), upVal)
+	if err != nil {
+		return err
+	}
+
+	return afterUpdate(tx, upVal)
 }
 
 func (s *Store) aggregateQuery(tx *badger.Txn, dataType interface{}, query *Query, groupBy ...string) ([]*AggregateResult, error) {
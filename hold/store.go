@@ -1,12 +1,14 @@
 package hold
 
 import (
+	"context"
 	"reflect"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
 )
 
 const (
@@ -29,8 +31,29 @@ type Store struct {
 	sequenceBandwith uint64
 	sequences        *sync.Map
 
-	encode EncodeFunc
-	decode DecodeFunc
+	encode     EncodeFunc
+	decode     DecodeFunc
+	cipher     ValueCipher
+	compressor *db.Compressor
+
+	cache       *db.LRU
+	cacheMisses bool
+	cacheWrites sync.Map
+	cacheStop   context.CancelFunc
+	cacheDone   chan struct{}
+	// cacheVersions holds the last commit version invalidating the records of each stripe of the cache
+	cacheVersions []uint64
+
+	sweepStop chan struct{}
+	sweepDone chan struct{}
+
+	now func() time.Time
+	// commitHooks holds the funcs run once each transaction started by write is committed
+	commitHooks sync.Map
+
+	// subscribers counts the subscribers of each type, changes are logged while it isn't zero
+	subscribers sync.Map
+	changeSeq   uint64
 }
 
 // Options allows you set different options from the defaults
@@ -39,9 +62,33 @@ type Options struct {
 	Encoder          EncodeFunc
 	Decoder          DecodeFunc
 	SequenceBandwith uint64
+	// ValueCipher, if set, encrypts every record value, keys and indexes are stored in plaintext
+	// Existing records can be re-encrypted with the current key by running an UpdateMatching that
+	// doesn't change them
+	ValueCipher ValueCipher
+	// Compressor, if set, compresses every record value, its rules are matched against the badger keys of
+	// records, "bh_" followed by the type name. Keys and indexes are never compressed so they keep their order.
+	Compressor *db.Compressor
+	// CacheSize, if set, is the size in bytes of a cache of the records read by Get and TxGet
+	// Records are cached encoded and decoded by every get, results never share memory. With CacheMisses,
+	// keys that aren't found are cached too.
+	CacheSize   int64
+	CacheMisses bool
+	// SweepInterval, if set, is how often the records expired by badger are removed from their indexes in the
+	// background, see SweepExpired
+	SweepInterval time.Duration
+	// Now, if set, is the clock setting the `hold:"created"` and `hold:"updated"` fields, time.Now by default
+	Now func() time.Time
 	badger.Options
 }
 
+// ValueCipher seals record values before they are written to badger and opens them after they are read
+// key is the badger key of the record, db.Keyring implements it with AES-GCM
+type ValueCipher interface {
+	Seal(key, value []byte) ([]byte, error)
+	Open(key, value []byte) ([]byte, error)
+}
+
 // DefaultOptions are a default set of options for opening a Hold database
 // Includes badgers own default options
 var DefaultOptions = Options{
@@ -54,21 +101,50 @@ var DefaultOptions = Options{
 // Open opens or creates a hold file.
 func Open(options Options) (*Store, error) {
 
-	db, err := badger.Open(options.Options)
+	bdb, err := badger.Open(options.Options)
 	if err != nil {
 		return nil, err
 	}
 
-	go runStorageGC(db)
+	go runStorageGC(bdb)
 
-	return &Store{
-		db:               db,
+	s := &Store{
+		db:               bdb,
 		sequenceBandwith: options.SequenceBandwith,
 		sequences:        &sync.Map{},
 
-		encode: options.Encoder,
-		decode: options.Decoder,
-	}, nil
+		encode:     options.Encoder,
+		decode:     options.Decoder,
+		cipher:     options.ValueCipher,
+		compressor: options.Compressor,
+
+		now: options.Now,
+
+		// change log keys of an earlier run may not have expired yet
+		changeSeq: uint64(time.Now().UnixNano()),
+	}
+	if s.now == nil {
+		s.now = time.Now
+	}
+
+	if options.CacheSize > 0 {
+		s.cache = db.NewLRU(options.CacheSize)
+		s.cacheMisses = options.CacheMisses
+		s.cacheVersions = make([]uint64, cacheVersionStripes)
+		s.cacheDone = make(chan struct{})
+
+		var ctx context.Context
+		ctx, s.cacheStop = context.WithCancel(context.Background())
+		go s.watchCache(ctx)
+	}
+
+	if options.SweepInterval > 0 {
+		s.sweepStop = make(chan struct{})
+		s.sweepDone = make(chan struct{})
+		go s.runSweeper(options.SweepInterval)
+	}
+
+	return s, nil
 }
 
 func runStorageGC(db *badger.DB) {
@@ -107,6 +183,14 @@ func (s *Store) Close() error {
 	if err != nil {
 		return err
 	}
+	if s.cache != nil {
+		s.cacheStop()
+		<-s.cacheDone
+	}
+	if s.sweepStop != nil {
+		close(s.sweepStop)
+		<-s.sweepDone
+	}
 	return s.db.Close()
 }
 
@@ -219,8 +303,11 @@ func (s *Store) getSequence(typeName string) (uint64, error) {
 	return seq.(*badger.Sequence).Next()
 }
 
+// typePrefixRoot is the prefix of the keys of every record
+const typePrefixRoot = "bh_"
+
 func typePrefix(typeName string) []byte {
-	return []byte("bh_" + typeName)
+	return []byte(typePrefixRoot + typeName)
 }
 
 func getKeyField(tp reflect.Type) (reflect.StructField, bool) {
@@ -1,6 +1,7 @@
 package hold_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -8,8 +9,12 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
 	"github.com/xurwxj/kvdb/hold"
 )
 
@@ -77,6 +82,297 @@ func TestAlternateEncoding(t *testing.T) {
 
 }
 
+func TestValueCompression(t *testing.T) {
+	compressor, err := db.NewCompressor(db.CompressionOptions{
+		Threshold: 64,
+		Rules:     []db.CompressionRule{{Prefix: []byte("bh_ExportKeyed"), Compress: false}},
+	})
+	if err != nil {
+		t.Fatalf("Error creating compressor: %s", err)
+	}
+
+	opt := testOptions()
+	opt.Compressor = compressor
+	store, err := hold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	insertTestData(t, store)
+
+	var result []ItemTest
+	err = store.Find(&result, hold.Where("Category").Eq("vehicle").Index("Category").And(hold.Key).Gt(0))
+	if err != nil {
+		t.Fatalf("Error finding data from hold: %s", err)
+	}
+	if len(result) == 0 {
+		t.Fatalf("Find returned no compressed records")
+	}
+
+	// keys keep their order
+	var ranged []ItemTest
+	err = store.Find(&ranged, hold.Where(hold.Key).Ge(testData[2].Key).And(hold.Key).Lt(testData[5].Key))
+	if err != nil {
+		t.Fatalf("Error finding data from hold: %s", err)
+	}
+	if len(ranged) != 3 {
+		t.Fatalf("Found %d records in a key range wanted 3", len(ranged))
+	}
+
+	large := strings.Repeat("repetitive ", 100)
+	err = store.Insert("large", &ItemTest{Name: large})
+	if err != nil {
+		t.Fatalf("Error inserting large record: %s", err)
+	}
+	err = store.Insert("large", &ExportKeyed{Name: large})
+	if err != nil {
+		t.Fatalf("Error inserting large record: %s", err)
+	}
+
+	storedSize := func(typeName string) int64 {
+		var size int64
+		err := store.Badger().View(func(tx *badger.Txn) error {
+			key, err := hold.DefaultEncode("large")
+			if err != nil {
+				return err
+			}
+			stored, err := tx.Get(append([]byte("bh_"+typeName), key...))
+			if err != nil {
+				return err
+			}
+			size = stored.ValueSize()
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error reading raw value: %s", err)
+		}
+		return size
+	}
+	if size := storedSize("ItemTest"); size >= int64(len(large)) {
+		t.Fatalf("Large record was not compressed, stored %d bytes", size)
+	}
+	// the rules of the compressor apply to the keys of records
+	if size := storedSize("ExportKeyed"); size < int64(len(large)) {
+		t.Fatalf("Large record excluded by a rule was compressed, stored %d bytes", size)
+	}
+
+	var got ItemTest
+	err = store.Get("large", &got)
+	if err != nil {
+		t.Fatalf("Error getting data from hold: %s", err)
+	}
+	if got.Name != large {
+		t.Fatalf("Compressed record was not restored")
+	}
+}
+
+func TestValueCipher(t *testing.T) {
+	keyring, err := db.NewKeyring(1, map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatalf("Error creating keyring: %s", err)
+	}
+
+	opt := testOptions()
+	opt.ValueCipher = keyring
+	store, err := hold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	insertTestData(t, store)
+
+	err = store.Badger().View(func(tx *badger.Txn) error {
+		it := tx.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek([]byte("bh_ItemTest")); it.ValidForPrefix([]byte("bh_ItemTest")); it.Next() {
+			err := it.Item().Value(func(value []byte) error {
+				if bytes.Contains(value, []byte("pizza")) {
+					t.Fatalf("Record value is stored in plaintext")
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading raw values: %s", err)
+	}
+
+	var result []ItemTest
+	err = store.Find(&result, hold.Where("Category").Eq("food").Index("Category"))
+	if err != nil {
+		t.Fatalf("Error finding data from hold: %s", err)
+	}
+	if len(result) == 0 {
+		t.Fatalf("Find returned no encrypted records")
+	}
+
+	err = store.UpdateMatching(&ItemTest{}, hold.Where("Name").Eq("pizza"), func(record interface{}) error {
+		record.(*ItemTest).Fruit = "tomato"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error updating encrypted records: %s", err)
+	}
+
+	var item ItemTest
+	err = store.Get(testData[4].Key, &item)
+	if err != nil {
+		t.Fatalf("Error getting data from hold: %s", err)
+	}
+	if item.Fruit != "tomato" {
+		t.Fatalf("Update of encrypted record was not stored")
+	}
+}
+
+func TestCache(t *testing.T) {
+	opt := testOptions()
+	opt.CacheSize = 1 << 20
+	opt.CacheMisses = true
+	store, err := hold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	var result ItemTest
+	err = store.Get(1, &result)
+	if err != hold.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound getting a missing record, got %v", err)
+	}
+
+	err = store.Insert(1, &ItemTest{Name: "cached", Category: "test"})
+	if err != nil {
+		t.Fatalf("Error inserting data: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		result = ItemTest{}
+		err = store.Get(1, &result)
+		if err != nil {
+			t.Fatalf("Error getting data from hold: %s", err)
+		}
+		if result.Name != "cached" {
+			t.Fatalf("Got %+v wanted the inserted record", result)
+		}
+	}
+
+	if hits := store.CacheStats().Hits; hits != 1 {
+		t.Fatalf("Got %d cache hits wanted 1", hits)
+	}
+
+	err = store.Badger().Update(func(tx *badger.Txn) error {
+		err := store.TxUpdate(tx, 1, &ItemTest{Name: "updated", Category: "test"})
+		if err != nil {
+			return err
+		}
+
+		var inTx ItemTest
+		err = store.TxGet(tx, 1, &inTx)
+		if err != nil {
+			return err
+		}
+		if inTx.Name != "updated" {
+			t.Fatalf("Got %q within the transaction wanted the uncommitted update", inTx.Name)
+		}
+
+		var outside ItemTest
+		err = store.Get(1, &outside)
+		if err != nil {
+			return err
+		}
+		if outside.Name != "cached" {
+			t.Fatalf("Got %q outside the transaction wanted the committed record", outside.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error updating data: %s", err)
+	}
+
+	err = store.Get(1, &result)
+	if err != nil {
+		t.Fatalf("Error getting data from hold: %s", err)
+	}
+	if result.Name != "updated" {
+		t.Fatalf("Got stale record %q after an update", result.Name)
+	}
+
+	err = store.DeleteMatching(&ItemTest{}, hold.Where("Category").Eq("test"))
+	if err != nil {
+		t.Fatalf("Error deleting data: %s", err)
+	}
+	err = store.Get(1, &result)
+	if err != hold.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound getting a deleted record, got %v", err)
+	}
+}
+
+func TestCacheStaleRead(t *testing.T) {
+	opt := testOptions()
+	opt.CacheSize = 1 << 20
+	store, err := hold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	err = store.Insert(1, &ItemTest{Name: "old", Tags: []string{"a"}})
+	if err != nil {
+		t.Fatalf("Error inserting data: %s", err)
+	}
+
+	old := store.Badger().NewTransaction(false)
+	defer old.Discard()
+
+	err = store.Update(1, &ItemTest{Name: "new", Tags: []string{"a"}})
+	if err != nil {
+		t.Fatalf("Error updating data: %s", err)
+	}
+	// give the update time to reach the invalidation of the cache
+	time.Sleep(50 * time.Millisecond)
+
+	var result ItemTest
+	err = store.TxGet(old, 1, &result)
+	if err != nil {
+		t.Fatalf("Error getting data from hold: %s", err)
+	}
+	if result.Name != "old" {
+		t.Fatalf("Got %q wanted the record of the snapshot", result.Name)
+	}
+
+	// the older snapshot never fills the cache
+	for i := 0; i < 2; i++ {
+		result = ItemTest{}
+		err = store.Get(1, &result)
+		if err != nil {
+			t.Fatalf("Error getting data from hold: %s", err)
+		}
+		if result.Name != "new" || result.Tags[0] != "a" {
+			t.Fatalf("Got stale or modified record %+v", result)
+		}
+
+		// results of cached gets don't share memory
+		result.Tags[0] = "modified"
+	}
+	if hits := store.CacheStats().Hits; hits != 1 {
+		t.Fatalf("Got %d cache hits wanted 1", hits)
+	}
+}
+
 func TestGetUnknownType(t *testing.T) {
 	opt := testOptions()
 	store, err := hold.Open(opt)
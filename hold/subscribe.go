@@ -0,0 +1,214 @@
+package hold
+
+import (
+	"context"
+	"encoding/binary"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/pb"
+)
+
+// changePrefix is the prefix of the keys of the change log read by subscribers
+const changePrefix = "_bhChange:"
+
+// changeRetention is how long a change log entry is kept, subscribers read it as soon as it's committed
+const changeRetention = time.Minute
+
+// ChangeOp is the kind of write a Change reports
+type ChangeOp int
+
+const (
+	// ChangeInsert is the insert of a record, or the upsert of a record that didn't exist
+	ChangeInsert ChangeOp = iota + 1
+	// ChangeUpdate is the update or upsert of an existing record
+	ChangeUpdate
+	// ChangeDelete is the delete of a record
+	ChangeDelete
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangeInsert:
+		return "insert"
+	case ChangeUpdate:
+		return "update"
+	case ChangeDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// Change is a write to a record reported to subscribers
+// Before and After are pointers to the record before and after the write, Before is nil for inserts and After
+// is nil for deletes. Key is the key of the record if its type has a key field, the key field of Before and
+// After is set too.
+type Change struct {
+	Op     ChangeOp
+	Key    interface{}
+	Before interface{}
+	After  interface{}
+
+	gk       []byte
+	typeName string
+	store    *Store
+}
+
+// DecodeKey decodes the key of the record changed into key, for types without a key field
+func (c *Change) DecodeKey(key interface{}) error {
+	return c.store.decodeKey(c.gk, key, c.typeName)
+}
+
+// changeEntry is a change log entry, written in the same transaction as the change
+type changeEntry struct {
+	Op     ChangeOp
+	Key    []byte
+	Before []byte
+	After  []byte
+}
+
+// Subscribe calls fn with the changes committed to records of dataType matching query, which can be nil, until
+// ctx is done or fn returns an error, which Subscribe returns. Updates are reported if the record matches
+// query before or after the update. Only the criteria of query are used, not its sorting, skip or limit.
+// Changes are logged only while a type has subscribers, a change committed by a transaction that started
+// before Subscribe was called may not be reported. Expired records aren't reported and Import is.
+func (s *Store) Subscribe(ctx context.Context, dataType interface{}, query *Query,
+	fn func(change *Change) error) error {
+	storer := s.newStorer(dataType)
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	if query == nil {
+		query = &Query{}
+	}
+
+	subscribers := s.subscriberCount(storer.Type())
+	atomic.AddInt64(subscribers, 1)
+	defer atomic.AddInt64(subscribers, -1)
+
+	return s.db.Subscribe(ctx, func(kvs *badger.KVList) error {
+		return s.db.View(func(tx *badger.Txn) error {
+			for _, kv := range kvs.Kv {
+				if len(kv.Value) == 0 {
+					// change log entries are never deleted, only expired
+					continue
+				}
+
+				change, ok, err := s.decodeChange(tx, storer.Type(), tp, query, kv.Value)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+
+				err = fn(change)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}, []pb.Match{{Prefix: changeKeyPrefix(storer.Type())}})
+}
+
+// decodeChange decodes a change log entry of a record of type tp, ok is false if the record doesn't match
+// query
+func (s *Store) decodeChange(tx *badger.Txn, typeName string, tp reflect.Type, query *Query,
+	value []byte) (*Change, bool, error) {
+	entry := &changeEntry{}
+	err := s.decode(value, entry)
+	if err != nil {
+		return nil, false, err
+	}
+
+	change := &Change{
+		Op:       entry.Op,
+		gk:       entry.Key,
+		typeName: typeName,
+		store:    s,
+	}
+
+	keyField, hasKeyField := getKeyField(tp)
+	if hasKeyField {
+		key := reflect.New(keyField.Type)
+		err = s.decodeKey(entry.Key, key.Interface(), typeName)
+		if err != nil {
+			return nil, false, err
+		}
+		change.Key = key.Elem().Interface()
+	}
+
+	matched := false
+	records := []*interface{}{&change.Before, &change.After}
+	for i, encoded := range [][]byte{entry.Before, entry.After} {
+		if encoded == nil {
+			continue
+		}
+
+		val := reflect.New(tp)
+		err = s.decodeValue(entry.Key, encoded, val.Interface())
+		if err != nil {
+			return nil, false, err
+		}
+
+		ok, err := query.recordMatches(s, tx, entry.Key, val)
+		if err != nil {
+			return nil, false, err
+		}
+		matched = matched || ok
+
+		if hasKeyField {
+			val.Elem().FieldByName(keyField.Name).Set(reflect.ValueOf(change.Key))
+		}
+		*records[i] = val.Interface()
+	}
+
+	return change, matched, nil
+}
+
+// logChange writes a change to the record stored at gk to the change log, if its type has subscribers
+// before and after are nil for inserts and deletes.
+func (s *Store) logChange(tx *badger.Txn, storer Storer, gk []byte, op ChangeOp, before, after interface{}) error {
+	if atomic.LoadInt64(s.subscriberCount(storer.Type())) == 0 {
+		return nil
+	}
+
+	entry := &changeEntry{Op: op, Key: gk}
+	var err error
+	if before != nil {
+		entry.Before, err = s.encodeValue(gk, before)
+		if err != nil {
+			return err
+		}
+	}
+	if after != nil {
+		entry.After, err = s.encodeValue(gk, after)
+		if err != nil {
+			return err
+		}
+	}
+
+	value, err := s.encode(entry)
+	if err != nil {
+		return err
+	}
+
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, atomic.AddUint64(&s.changeSeq, 1))
+	key = append(changeKeyPrefix(storer.Type()), key...)
+	return tx.SetEntry(badger.NewEntry(key, value).WithTTL(changeRetention))
+}
+
+// subscriberCount returns the number of subscribers to typeName
+func (s *Store) subscriberCount(typeName string) *int64 {
+	count, _ := s.subscribers.LoadOrStore(typeName, new(int64))
+	return count.(*int64)
+}
+
+func changeKeyPrefix(typeName string) []byte {
+	return []byte(changePrefix + typeName + ":")
+}
@@ -0,0 +1,135 @@
+package hold_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xurwxj/kvdb/hold"
+)
+
+type Order struct {
+	ID     uint64 `hold:"key"`
+	Status string `hold:"index"`
+	Total  int
+}
+
+// subscribe collects the changes to orders matching query until cancel is called
+func subscribe(store *hold.Store, query *hold.Query) (changes chan *hold.Change, cancel func() error) {
+	ctx, stop := context.WithCancel(context.Background())
+	changes = make(chan *hold.Change, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- store.Subscribe(ctx, &Order{}, query, func(change *hold.Change) error {
+			changes <- change
+			return nil
+		})
+	}()
+	// let the subscription start
+	time.Sleep(50 * time.Millisecond)
+
+	return changes, func() error {
+		stop()
+		return <-done
+	}
+}
+
+func nextChange(t *testing.T, changes chan *hold.Change) *hold.Change {
+	t.Helper()
+	select {
+	case change := <-changes:
+		return change
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for a change")
+	}
+	return nil
+}
+
+func TestSubscribe(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		changes, cancel := subscribe(store, nil)
+		defer cancel()
+
+		err := store.Insert(uint64(1), &Order{Status: "new", Total: 10})
+		if err != nil {
+			t.Fatalf("Error inserting data for test: %s", err)
+		}
+		change := nextChange(t, changes)
+		if change.Op != hold.ChangeInsert || change.Key != uint64(1) || change.Before != nil {
+			t.Fatalf("Got %s of %v, wanted the insert of 1", change.Op, change.Key)
+		}
+		if after := change.After.(*Order); after.ID != 1 || after.Total != 10 {
+			t.Fatalf("Inserted %+v, wanted the order inserted", after)
+		}
+
+		err = store.Update(uint64(1), &Order{Status: "paid", Total: 10})
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+		change = nextChange(t, changes)
+		if change.Op != hold.ChangeUpdate || change.Before.(*Order).Status != "new" ||
+			change.After.(*Order).Status != "paid" {
+			t.Fatalf("Got %s from %+v to %+v, wanted the update from new to paid", change.Op, change.Before,
+				change.After)
+		}
+
+		err = store.Delete(uint64(1), Order{})
+		if err != nil {
+			t.Fatalf("Error deleting data: %s", err)
+		}
+		change = nextChange(t, changes)
+		if change.Op != hold.ChangeDelete || change.After != nil || change.Before.(*Order).ID != 1 {
+			t.Fatalf("Got %s of %+v, wanted the delete of 1", change.Op, change.Before)
+		}
+
+		var key uint64
+		if err = change.DecodeKey(&key); err != nil || key != 1 {
+			t.Fatalf("Decoded key %d, %v, wanted 1", key, err)
+		}
+	})
+}
+
+func TestSubscribeQuery(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		changes, cancel := subscribe(store, hold.Where("Status").Eq("paid").Index("Status"))
+
+		for i, status := range []string{"new", "paid", "new"} {
+			err := store.Insert(uint64(i+1), &Order{Status: status})
+			if err != nil {
+				t.Fatalf("Error inserting data for test: %s", err)
+			}
+		}
+
+		// no longer matches, but did before
+		err := store.UpdateMatching(&Order{}, hold.Where(hold.Key).Eq(uint64(2)), func(record interface{}) error {
+			record.(*Order).Status = "shipped"
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error updating matching data: %s", err)
+		}
+
+		err = store.DeleteMatching(&Order{}, hold.Where("Status").Eq("new"))
+		if err != nil {
+			t.Fatalf("Error deleting matching data: %s", err)
+		}
+
+		change := nextChange(t, changes)
+		if change.Op != hold.ChangeInsert || change.Key != uint64(2) {
+			t.Fatalf("Got %s of %v, wanted the insert of 2", change.Op, change.Key)
+		}
+		change = nextChange(t, changes)
+		if change.Op != hold.ChangeUpdate || change.After.(*Order).Status != "shipped" {
+			t.Fatalf("Got %s of %v, wanted the update of 2", change.Op, change.Key)
+		}
+
+		if err = cancel(); err != nil && err != context.Canceled {
+			t.Fatalf("Error subscribing: %s", err)
+		}
+		select {
+		case change = <-changes:
+			t.Fatalf("Got %s of %v, wanted no other change", change.Op, change.Key)
+		default:
+		}
+	})
+}
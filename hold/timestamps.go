@@ -0,0 +1,80 @@
+package hold
+
+import (
+	"reflect"
+	"time"
+)
+
+// tags of the time.Time fields holding the time a record was inserted at and last written at
+const (
+	holdPrefixCreatedValue = "created"
+	holdPrefixUpdatedValue = "updated"
+)
+
+// getTimeField returns the time.Time field with the hold tag value
+func getTimeField(tp reflect.Type, value string) (reflect.StructField, bool) {
+	for i := 0; i < tp.NumField(); i++ {
+		if tp.Field(i).Tag.Get(holdPrefixTag) == value && tp.Field(i).Type == timeType {
+			return tp.Field(i), true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// recordCreated returns the time a record was created at, zero if its type has no created field
+func recordCreated(data interface{}) time.Time {
+	dataVal := reflect.ValueOf(data)
+	for dataVal.Kind() == reflect.Ptr {
+		dataVal = dataVal.Elem()
+	}
+
+	field, ok := getTimeField(dataVal.Type(), holdPrefixCreatedValue)
+	if !ok {
+		return time.Time{}
+	}
+	return dataVal.FieldByName(field.Name).Interface().(time.Time)
+}
+
+// withTimestamps sets the created and updated fields of data, the ones its type has
+// The created field is only set if created isn't zero. data is copied if it isn't passed by reference, so the
+// fields can be set.
+func withTimestamps(data interface{}, created, updated time.Time) interface{} {
+	dataVal := reflect.ValueOf(data)
+	for dataVal.Kind() == reflect.Ptr {
+		dataVal = dataVal.Elem()
+	}
+
+	createdField, hasCreated := getTimeField(dataVal.Type(), holdPrefixCreatedValue)
+	updatedField, hasUpdated := getTimeField(dataVal.Type(), holdPrefixUpdatedValue)
+	hasCreated = hasCreated && !created.IsZero()
+	if !hasCreated && !hasUpdated {
+		return data
+	}
+
+	data, dataVal = settable(data)
+	if hasCreated {
+		dataVal.FieldByName(createdField.Name).Set(reflect.ValueOf(created))
+	}
+	if hasUpdated {
+		dataVal.FieldByName(updatedField.Name).Set(reflect.ValueOf(updated))
+	}
+	return data
+}
+
+// settable returns data and the struct it points to, copied if data isn't passed by reference so its
+// fields can be set
+func settable(data interface{}) (interface{}, reflect.Value) {
+	dataVal := reflect.ValueOf(data)
+	for dataVal.Kind() == reflect.Ptr {
+		dataVal = dataVal.Elem()
+	}
+
+	if dataVal.CanSet() {
+		return data, dataVal
+	}
+
+	copied := reflect.New(dataVal.Type())
+	copied.Elem().Set(dataVal)
+	return copied.Interface(), copied.Elem()
+}
@@ -0,0 +1,89 @@
+package hold_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/xurwxj/kvdb/hold"
+)
+
+type Note struct {
+	ID      string    `hold:"key"`
+	Text    string    `hold:"index"`
+	Created time.Time `hold:"created"`
+	Updated time.Time `hold:"updated"`
+}
+
+func TestTimestamps(t *testing.T) {
+	clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	opt := testOptions()
+	opt.Now = func() time.Time {
+		return clock
+	}
+	store, err := hold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	inserted := clock
+	note := &Note{Text: "a"}
+	if err = store.Insert("n1", note); err != nil {
+		t.Fatalf("Error inserting data for test: %s", err)
+	}
+	if !note.Created.Equal(inserted) || !note.Updated.Equal(inserted) {
+		t.Fatalf("Inserted %+v, wanted both timestamps set to %s", note, inserted)
+	}
+
+	clock = clock.Add(time.Hour)
+	// an update made without the created time read keeps the stored one
+	if err = store.Update("n1", &Note{Text: "b"}); err != nil {
+		t.Fatalf("Error updating data: %s", err)
+	}
+	result := &Note{}
+	if err = store.Get("n1", result); err != nil {
+		t.Fatalf("Error getting data from hold: %s", err)
+	}
+	if !result.Created.Equal(inserted) || !result.Updated.Equal(clock) {
+		t.Fatalf("Updated %+v, wanted created %s and updated %s", result, inserted, clock)
+	}
+
+	clock = clock.Add(time.Hour)
+	if err = store.Upsert("n1", Note{Text: "c"}); err != nil {
+		t.Fatalf("Error upserting data: %s", err)
+	}
+	if err = store.Upsert("n2", &Note{Text: "d"}); err != nil {
+		t.Fatalf("Error upserting data: %s", err)
+	}
+
+	var found []Note
+	if err = store.Find(&found, nil); err != nil {
+		t.Fatalf("Error finding data: %s", err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("Found %d notes, wanted 2", len(found))
+	}
+	if !found[0].Created.Equal(inserted) || !found[0].Updated.Equal(clock) {
+		t.Fatalf("Upserted %+v, wanted created %s and updated %s", found[0], inserted, clock)
+	}
+	if !found[1].Created.Equal(clock) || !found[1].Updated.Equal(clock) {
+		t.Fatalf("Upserted %+v, wanted both timestamps set to %s", found[1], clock)
+	}
+
+	clock = clock.Add(time.Hour)
+	err = store.UpdateMatching(&Note{}, hold.Where("Text").Eq("d").Index("Text"), func(record interface{}) error {
+		record.(*Note).Text = "e"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error updating matching data: %s", err)
+	}
+	if err = store.Get("n2", result); err != nil {
+		t.Fatalf("Error getting data from hold: %s", err)
+	}
+	if !result.Created.Equal(clock.Add(-time.Hour)) || !result.Updated.Equal(clock) {
+		t.Fatalf("Updated %+v, wanted only the updated time to change", result)
+	}
+}
@@ -0,0 +1,91 @@
+package hold
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// holdPrefixVersionValue tags the integer field holding the version of a record
+const holdPrefixVersionValue = "version"
+
+// ErrVersionConflict is returned when a record is written with a version other than the version stored, the
+// record was changed by someone else since it was read. Stored is zero if the record doesn't exist anymore.
+type ErrVersionConflict struct {
+	Type    string
+	Version uint64
+	Stored  uint64
+}
+
+func (e *ErrVersionConflict) Error() string {
+	return fmt.Sprintf("%s was written with version %d but version %d is stored", e.Type, e.Version, e.Stored)
+}
+
+// getVersionField returns the integer field tagged as `hold:"version"`
+func getVersionField(tp reflect.Type) (reflect.StructField, bool) {
+	for i := 0; i < tp.NumField(); i++ {
+		if tp.Field(i).Tag.Get(holdPrefixTag) != holdPrefixVersionValue {
+			continue
+		}
+
+		switch tp.Field(i).Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return tp.Field(i), true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// recordVersion returns the version of a record, zero if its type has no version field
+func recordVersion(data interface{}) uint64 {
+	dataVal := reflect.ValueOf(data)
+	for dataVal.Kind() == reflect.Ptr {
+		dataVal = dataVal.Elem()
+	}
+
+	field, ok := getVersionField(dataVal.Type())
+	if !ok {
+		return 0
+	}
+
+	fieldValue := dataVal.FieldByName(field.Name)
+	switch fieldValue.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fieldValue.Uint()
+	}
+	return uint64(fieldValue.Int())
+}
+
+// nextVersion checks data has the version stored for the record it replaces, zero if there's none, and returns
+// data with its version set to the next one
+func nextVersion(typeName string, data interface{}, stored uint64) (interface{}, error) {
+	if version := recordVersion(data); version != stored {
+		return nil, &ErrVersionConflict{Type: typeName, Version: version, Stored: stored}
+	}
+	return withVersion(data, stored+1), nil
+}
+
+// withVersion sets the version of data if its type has a version field
+// data is copied if it isn't passed by reference, so the version can be set.
+func withVersion(data interface{}, version uint64) interface{} {
+	dataVal := reflect.ValueOf(data)
+	for dataVal.Kind() == reflect.Ptr {
+		dataVal = dataVal.Elem()
+	}
+
+	field, ok := getVersionField(dataVal.Type())
+	if !ok {
+		return data
+	}
+
+	data, dataVal = settable(data)
+	fieldValue := dataVal.FieldByName(field.Name)
+	switch fieldValue.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fieldValue.SetUint(version)
+	default:
+		fieldValue.SetInt(int64(version))
+	}
+	return data
+}
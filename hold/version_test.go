@@ -0,0 +1,234 @@
+package hold_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/hold"
+)
+
+type Document struct {
+	ID      string `hold:"key"`
+	Title   string `hold:"index"`
+	Version uint64 `hold:"version"`
+}
+
+func TestVersion(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		doc := &Document{Title: "draft"}
+		err := store.Insert("doc", doc)
+		if err != nil {
+			t.Fatalf("Error inserting data for test: %s", err)
+		}
+		if doc.Version != 1 {
+			t.Fatalf("Inserted version %d, wanted 1", doc.Version)
+		}
+
+		// two users read the same version
+		first, second := &Document{}, &Document{}
+		if err = store.Get("doc", first); err != nil {
+			t.Fatalf("Error getting data from hold: %s", err)
+		}
+		if err = store.Get("doc", second); err != nil {
+			t.Fatalf("Error getting data from hold: %s", err)
+		}
+
+		first.Title = "first"
+		err = store.Update("doc", first)
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+		if first.Version != 2 {
+			t.Fatalf("Updated to version %d, wanted 2", first.Version)
+		}
+
+		second.Title = "second"
+		err = store.Update("doc", second)
+		conflict, ok := err.(*hold.ErrVersionConflict)
+		if !ok || conflict.Version != 1 || conflict.Stored != 2 || conflict.Type != "Document" {
+			t.Fatalf("Got %v, wanted a version conflict", err)
+		}
+
+		err = store.Upsert("doc", second)
+		if _, ok := err.(*hold.ErrVersionConflict); !ok {
+			t.Fatalf("Got %v upserting, wanted a version conflict", err)
+		}
+
+		// passed by value, the stored version is still incremented
+		err = store.Upsert("doc", Document{Title: "third", Version: 2})
+		if err != nil {
+			t.Fatalf("Error upserting data: %s", err)
+		}
+
+		result := &Document{}
+		if err = store.Get("doc", result); err != nil {
+			t.Fatalf("Error getting data from hold: %s", err)
+		}
+		if result.Title != "third" || result.Version != 3 {
+			t.Fatalf("Got %+v, wanted the third title at version 3", result)
+		}
+
+		// the update failing didn't change the index
+		var found []Document
+		err = store.Find(&found, hold.Where("Title").Eq("third").Index("Title"))
+		if err != nil || len(found) != 1 {
+			t.Fatalf("Found %v, %v, wanted the third document", found, err)
+		}
+
+		// a record deleted since it was read is a conflict too
+		err = store.Upsert("missing", &Document{Version: 4})
+		conflict, ok = err.(*hold.ErrVersionConflict)
+		if !ok || conflict.Stored != 0 {
+			t.Fatalf("Got %v, wanted a version conflict with nothing stored", err)
+		}
+	})
+}
+
+// draftInterrupt, if set, runs once in the BeforeUpdate hook of a Draft, after it read the draft:lock key
+var draftInterrupt func()
+
+type Draft struct {
+	ID      string `hold:"key"`
+	Title   string
+	Version uint64    `hold:"version"`
+	Updated time.Time `hold:"updated"`
+}
+
+func (d *Draft) BeforeUpdate(tx *badger.Txn, old interface{}) error {
+	_, err := tx.Get([]byte("draft:lock"))
+	if err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	if draftInterrupt != nil {
+		interrupt := draftInterrupt
+		draftInterrupt = nil
+		interrupt()
+	}
+	return nil
+}
+
+func TestVersionRetry(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		draft := &Draft{Title: "first"}
+		if err := store.Insert("draft", draft); err != nil {
+			t.Fatalf("Error inserting data for test: %s", err)
+		}
+		inserted := *draft
+
+		// the lock read by the update is written before it commits
+		draftInterrupt = func() {
+			err := store.Badger().Update(func(tx *badger.Txn) error {
+				return tx.Set([]byte("draft:lock"), nil)
+			})
+			if err != nil {
+				t.Fatalf("Error writing lock: %s", err)
+			}
+		}
+		draft.Title = "second"
+		err := store.Update("draft", draft)
+		if err != badger.ErrConflict {
+			t.Fatalf("Got %v, wanted badger.ErrConflict", err)
+		}
+		if draft.Version != inserted.Version || !draft.Updated.Equal(inserted.Updated) {
+			t.Fatalf("Failed update changed the draft to %+v", draft)
+		}
+
+		// retried with the same struct
+		if err = store.Update("draft", draft); err != nil {
+			t.Fatalf("Error retrying update: %s", err)
+		}
+		if draft.Version != 2 {
+			t.Fatalf("Updated to version %d, wanted 2", draft.Version)
+		}
+
+		result := &Draft{}
+		if err = store.Get("draft", result); err != nil {
+			t.Fatalf("Error getting data from hold: %s", err)
+		}
+		if result.Title != "second" || result.Version != 2 {
+			t.Fatalf("Got %+v, wanted the second title at version 2", result)
+		}
+	})
+}
+
+func TestVersionUpdateMatching(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		for _, id := range []string{"a", "b"} {
+			if err := store.Insert(id, &Document{Title: id}); err != nil {
+				t.Fatalf("Error inserting data for test: %s", err)
+			}
+		}
+
+		err := store.UpdateMatching(&Document{}, nil, func(record interface{}) error {
+			record.(*Document).Title += "!"
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error updating matching data: %s", err)
+		}
+
+		var found []Document
+		if err = store.Find(&found, nil); err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		for _, doc := range found {
+			if doc.Version != 2 {
+				t.Fatalf("Got version %d for %s, wanted 2", doc.Version, doc.ID)
+			}
+		}
+
+		// setting the version read makes the update check it
+		err = store.UpdateMatching(&Document{}, hold.Where(hold.Key).Eq("a"), func(record interface{}) error {
+			record.(*Document).Version = 1
+			return nil
+		})
+		if _, ok := err.(*hold.ErrVersionConflict); !ok {
+			t.Fatalf("Got %v, wanted a version conflict", err)
+		}
+
+		// the version stored is incremented, whether the update func sets it or not
+		err = store.UpdateMatching(&Document{}, hold.Where(hold.Key).Eq("a"), func(record interface{}) error {
+			record.(*Document).Version = 2
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error updating matching data: %s", err)
+		}
+		result := &Document{}
+		if err = store.Get("a", result); err != nil || result.Version != 3 {
+			t.Fatalf("Got version %d, %v, wanted 3", result.Version, err)
+		}
+	})
+}
+
+func TestVersionExportImport(t *testing.T) {
+	testWrap(t, func(store *hold.Store, t *testing.T) {
+		if err := store.Insert("doc", &Document{Title: "t"}); err != nil {
+			t.Fatalf("Error inserting data for test: %s", err)
+		}
+		if err := store.Update("doc", &Document{Title: "u", Version: 1}); err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+
+		var export bytes.Buffer
+		if err := store.Export(&export, &Document{}); err != nil {
+			t.Fatalf("Error exporting: %s", err)
+		}
+
+		testWrap(t, func(imported *hold.Store, t *testing.T) {
+			if _, err := imported.Import(&export, &Document{}); err != nil {
+				t.Fatalf("Error importing: %s", err)
+			}
+
+			result := &Document{}
+			if err := imported.Get("doc", result); err != nil {
+				t.Fatalf("Error getting data from hold: %s", err)
+			}
+			if result.Version != 2 {
+				t.Fatalf("Imported version %d, wanted the exported version 2", result.Version)
+			}
+		})
+	})
+}
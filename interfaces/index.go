@@ -1,28 +1,124 @@
 package interfaces
 
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
 // OpSet identifier for set data into storeage
 const OpSet = "set"
 
 // OpDel identifier for delete data from storage
 const OpDel = "del"
 
+// OpIncr identifier for adding Delta to the int64 counter stored in the key
+const OpIncr = "incr"
+
+// OpCheckEquals identifier for a precondition that the key holds exactly Value
+const OpCheckEquals = "checkEquals"
+
+// OpCheckAbsent identifier for a precondition that the key doesn't exist
+const OpCheckAbsent = "checkAbsent"
+
+// OpCheckExists identifier for a precondition that the key exists
+const OpCheckExists = "checkExists"
+
+// ErrPreconditionFailed is the error returned when a precondition operation of a batch doesn't hold,
+// none of the operations of the batch are applied
+type ErrPreconditionFailed struct {
+	Key string
+	Op  string
+}
+
+func (e *ErrPreconditionFailed) Error() string {
+	return fmt.Sprintf("precondition %s failed for key %q", e.Op, e.Key)
+}
+
 // Operation represents structure to set/del from storage
+// TTL is only used by OpSet, the key expires after TTL if it's positive
+// Delta is only used by OpIncr
 type Operation struct {
 	Key   string
 	Value []byte
 	Op    string
+	TTL   time.Duration
+	Delta int64
+}
+
+// RangeOptions controls how a key range is iterated
+// The start key is inclusive and the end key exclusive unless told otherwise, a nil start or end
+// leaves that side of the range open. Reverse iterates from end to start, KeysOnly skips reading
+// values and passes nil values to the callback, a zero Limit iterates the whole range.
+type RangeOptions struct {
+	ExcludeStart bool
+	IncludeEnd   bool
+	Reverse      bool
+	KeysOnly     bool
+	Limit        uint64
+}
+
+// Event represents a committed change of a key delivered to a watcher
+// Op is either OpSet or OpDel, ExpiresAt is the unix time the key expires at or zero
+type Event struct {
+	Key       []byte
+	Value     []byte
+	Op        string
+	Version   uint64
+	ExpiresAt uint64
+}
+
+// WatchOptions controls how events are delivered to a watcher
+// Buffer is the number of event batches queued for a slow callback, with a zero Buffer the callback runs
+// on the notification path. When the buffer is full, writers are held back until the callback catches up,
+// unless DropWhenFull is set in which case the events are dropped and passed to OnDrop if it's set.
+type WatchOptions struct {
+	Buffer       int
+	DropWhenFull bool
+	OnDrop       func(events []Event)
+}
+
+// MergeFunc merges value into the existing value of a key and returns the result
+type MergeFunc func(existing, value []byte) []byte
+
+// Merger accumulates values added to a single key with a MergeFunc
+// Stop must be called once the Merger is no longer used
+type Merger interface {
+	Add(value []byte) error
+	Get() ([]byte, error)
+	Stop()
+}
+
+// Txn represents an explicit transaction against a db storage
+// A Txn must always be finished with either Commit or Discard
+type Txn interface {
+	Get(key string) (value []byte, err error)
+	Set(key string, value []byte) (err error)
+	SetWithTTL(key string, value []byte, ttl time.Duration) (err error)
+	Del(key string) (err error)
+	IterateByPrefix(prefix []byte, limit uint64, fn func(key []byte, value []byte)) uint64
+	Commit() error
+	Discard()
 }
 
 // DbStorage represent base db storage interface
 type DbStorage interface {
 	Set(key string, value []byte) (err error)
+	SetWithTTL(key string, value []byte, ttl time.Duration) (err error)
+	TTL(key string) (ttl time.Duration, err error)
 	Del(key string) (err error)
 	Get(key string) (value []byte, err error)
 	Iterate(fn func(key []byte, value []byte))
 	IterateByPrefix(prefix []byte, limit uint64, fn func(key []byte, value []byte)) uint64
 	IterateByPrefixFrom(prefix []byte, from []byte, limit uint64, fn func(key []byte, value []byte)) uint64
+	IterateRange(start, end []byte, opts RangeOptions, fn func(key []byte, value []byte)) uint64
 	DeleteByPrefix(prefix []byte)
+	Watch(ctx context.Context, prefixes [][]byte, opts WatchOptions, fn func(events []Event) error) error
 	KeysByPrefixCount(prefix []byte) uint64
 	ProcessBatch(batch []*Operation) (err error)
+	CompareAndSwap(key string, old, new []byte) (swapped bool, err error)
+	Increment(key string, delta int64) (value int64, err error)
+	Merge(key string, fn MergeFunc, interval time.Duration) Merger
+	Begin(writable bool) (Txn, error)
 	Close() error
 }
@@ -0,0 +1,229 @@
+// Package lock implements named locks held for a lease on any DbStorage
+//
+// A lock is a key written with a TTL by a conditional batch, so it's only taken if it's free and it frees
+// itself once its lease isn't renewed. Every acquisition draws a fencing token, greater than the tokens of
+// the previous holders of the lock, which the resources a lock protects can use to reject stale holders.
+// Processes sharing a storage through db.Remote share its locks.
+package lock
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// DefaultRetryInterval is how often Lock tries to take a held lock when no interval is given
+const DefaultRetryInterval = 100 * time.Millisecond
+
+// tokenRetries is the number of times Acquire draws a token again when another acquisition drew it first
+const tokenRetries = 10
+
+// ErrLocked is returned when acquiring a lock held by someone else
+var ErrLocked = errors.New("lock is held")
+
+// ErrLeaseLost is returned when using a lease which expired, or was released
+var ErrLeaseLost = errors.New("lock lease lost")
+
+// ErrNotLocked is returned by Holder when a lock isn't held
+var ErrNotLocked = errors.New("lock is not held")
+
+// ErrInvalidTTL is returned when taking or renewing a lease for a ttl which isn't positive, the lock would
+// never expire
+var ErrInvalidTTL = errors.New("lock ttl must be positive")
+
+// Options allows you to change how a Locker waits for locks
+type Options struct {
+	RetryInterval time.Duration
+}
+
+// Locker takes locks of a storage on behalf of an owner
+type Locker struct {
+	storage interfaces.DbStorage
+	owner   string
+	opts    Options
+}
+
+// Lease is a lock held by a Locker, until it expires or is released
+// Token is the fencing token of the lease, Expires the time it expires at unless renewed.
+type Lease struct {
+	Name    string
+	Owner   string
+	Token   uint64
+	Expires time.Time
+
+	storage interfaces.DbStorage
+	value   []byte
+}
+
+// New returns a Locker taking the locks of storage for owner, which identifies the holder of a lock
+func New(storage interfaces.DbStorage, owner string, opts Options) *Locker {
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = DefaultRetryInterval
+	}
+	return &Locker{storage: storage, owner: owner, opts: opts}
+}
+
+// Acquire takes the lock name for ttl, or returns ErrLocked if it's held
+// Badger rounds the expiry of the lock up to the next second, so the lock is held at least until lease.Expires.
+func (l *Locker) Acquire(name string, ttl time.Duration) (*Lease, error) {
+	if ttl <= 0 {
+		return nil, ErrInvalidTTL
+	}
+	key := lockKey(name)
+	tokenKey := tokenKey(name)
+
+	var err error
+	for i := 0; i < tokenRetries; i++ {
+		var token int64
+		token, err = getToken(l.storage, tokenKey)
+		if err != nil {
+			return nil, err
+		}
+
+		checkToken := &interfaces.Operation{Key: tokenKey, Op: interfaces.OpCheckAbsent}
+		if token != 0 {
+			checkToken = &interfaces.Operation{Key: tokenKey, Value: db.EncodeInt64(token), Op: interfaces.OpCheckEquals}
+		}
+
+		lease := &Lease{
+			Name:    name,
+			Owner:   l.owner,
+			Token:   uint64(token + 1),
+			Expires: time.Now().Add(ttl),
+			storage: l.storage,
+		}
+		lease.value = encodeHolder(lease.Token, l.owner)
+
+		err = l.storage.ProcessBatch([]*interfaces.Operation{
+			{Key: key, Op: interfaces.OpCheckAbsent},
+			checkToken,
+			{Key: tokenKey, Value: db.EncodeInt64(token + 1), Op: interfaces.OpSet},
+			{Key: key, Value: lease.value, Op: interfaces.OpSet, TTL: ttl},
+		})
+		if failed, ok := err.(*interfaces.ErrPreconditionFailed); ok {
+			if failed.Key == key {
+				return nil, ErrLocked
+			}
+			// another acquisition drew the token first
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return lease, nil
+	}
+	return nil, err
+}
+
+// Lock takes the lock name for ttl, waiting for it to be free until ctx is done
+func (l *Locker) Lock(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	ticker := time.NewTicker(l.opts.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		lease, err := l.Acquire(name, ttl)
+		if err != ErrLocked {
+			return lease, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Holder returns the owner and fencing token of the lease holding the lock name, or ErrNotLocked
+func (l *Locker) Holder(name string) (owner string, token uint64, err error) {
+	value, err := l.storage.Get(lockKey(name))
+	if err == badger.ErrKeyNotFound {
+		return "", 0, ErrNotLocked
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	token, owner = decodeHolder(value)
+	return owner, token, nil
+}
+
+// Renew extends the lease for ttl from now, ErrLeaseLost is returned if it expired
+func (lease *Lease) Renew(ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	expires := time.Now().Add(ttl)
+	err := lease.ProcessBatch([]*interfaces.Operation{
+		{Key: lockKey(lease.Name), Value: lease.value, Op: interfaces.OpSet, TTL: ttl},
+	})
+	if err != nil {
+		return err
+	}
+
+	lease.Expires = expires
+	return nil
+}
+
+// Release frees the lock, ErrLeaseLost is returned if the lease expired
+func (lease *Lease) Release() error {
+	return lease.ProcessBatch([]*interfaces.Operation{
+		{Key: lockKey(lease.Name), Op: interfaces.OpDel},
+	})
+}
+
+// Fence returns a precondition holding while the lease is held, a batch of the storage starting with it is
+// only applied by the holder of the lease
+func (lease *Lease) Fence() *interfaces.Operation {
+	return &interfaces.Operation{Key: lockKey(lease.Name), Value: lease.value, Op: interfaces.OpCheckEquals}
+}
+
+// ProcessBatch applies batch to the storage of the lock if the lease is still held, ErrLeaseLost is returned
+// otherwise
+func (lease *Lease) ProcessBatch(batch []*interfaces.Operation) error {
+	err := lease.storage.ProcessBatch(append([]*interfaces.Operation{lease.Fence()}, batch...))
+	if failed, ok := err.(*interfaces.ErrPreconditionFailed); ok && failed.Key == lockKey(lease.Name) &&
+		failed.Op == interfaces.OpCheckEquals {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// getToken returns the last fencing token drawn for a lock, zero if none was
+func getToken(storage interfaces.DbStorage, key string) (int64, error) {
+	value, err := storage.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return db.DecodeInt64(value)
+}
+
+// encodeHolder encodes the token and owner of a lease as the value of its lock
+func encodeHolder(token uint64, owner string) []byte {
+	b := make([]byte, 8, 8+len(owner))
+	binary.BigEndian.PutUint64(b, token)
+	return append(b, owner...)
+}
+
+func decodeHolder(b []byte) (uint64, string) {
+	if len(b) < 8 {
+		return 0, ""
+	}
+	return binary.BigEndian.Uint64(b), string(b[8:])
+}
+
+func lockKey(name string) string {
+	return "lock:" + name
+}
+
+func tokenKey(name string) string {
+	return "lock-token:" + name
+}
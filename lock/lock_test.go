@@ -0,0 +1,177 @@
+package lock_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+	"github.com/xurwxj/kvdb/lock"
+)
+
+func testWrap(t *testing.T, tests func(storage *db.Badger, t *testing.T)) {
+	storage := db.NewBadgerInMemory()
+	defer storage.Close()
+
+	tests(storage, t)
+}
+
+func TestAcquire(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		alice := lock.New(storage, "alice", lock.Options{})
+		bob := lock.New(storage, "bob", lock.Options{})
+
+		lease, err := alice.Acquire("cron", time.Minute)
+		if err != nil {
+			t.Fatalf("Error acquiring lock: %s", err)
+		}
+		if lease.Token != 1 || lease.Owner != "alice" {
+			t.Fatalf("Got lease %+v, wanted token 1 held by alice", lease)
+		}
+
+		if _, err = bob.Acquire("cron", time.Minute); err != lock.ErrLocked {
+			t.Fatalf("Got %v, wanted ErrLocked", err)
+		}
+		owner, token, err := bob.Holder("cron")
+		if err != nil || owner != "alice" || token != 1 {
+			t.Fatalf("Got holder %s with token %d, %v, wanted alice with token 1", owner, token, err)
+		}
+
+		if err = lease.Renew(time.Minute); err != nil {
+			t.Fatalf("Error renewing lease: %s", err)
+		}
+		if err = lease.Release(); err != nil {
+			t.Fatalf("Error releasing lock: %s", err)
+		}
+		if err = lease.Release(); err != lock.ErrLeaseLost {
+			t.Fatalf("Released twice, got %v", err)
+		}
+		if _, _, err = bob.Holder("cron"); err != lock.ErrNotLocked {
+			t.Fatalf("Got %v, wanted ErrNotLocked", err)
+		}
+
+		lease, err = bob.Acquire("cron", time.Minute)
+		if err != nil {
+			t.Fatalf("Error acquiring lock: %s", err)
+		}
+		if lease.Token != 2 {
+			t.Fatalf("Got token %d, wanted 2", lease.Token)
+		}
+	})
+}
+
+func TestInvalidTTL(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		alice := lock.New(storage, "alice", lock.Options{})
+
+		for _, ttl := range []time.Duration{0, -time.Second} {
+			if _, err := alice.Acquire("cron", ttl); err != lock.ErrInvalidTTL {
+				t.Fatalf("Got %v acquiring for %s, wanted ErrInvalidTTL", err, ttl)
+			}
+		}
+		if _, _, err := alice.Holder("cron"); err != lock.ErrNotLocked {
+			t.Fatalf("Got %v, wanted ErrNotLocked", err)
+		}
+
+		lease, err := alice.Acquire("cron", time.Minute)
+		if err != nil {
+			t.Fatalf("Error acquiring lock: %s", err)
+		}
+		if err = lease.Renew(0); err != lock.ErrInvalidTTL {
+			t.Fatalf("Got %v renewing for 0, wanted ErrInvalidTTL", err)
+		}
+	})
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		alice := lock.New(storage, "alice", lock.Options{})
+		bob := lock.New(storage, "bob", lock.Options{})
+
+		stale, err := alice.Acquire("cron", time.Second)
+		if err != nil {
+			t.Fatalf("Error acquiring lock: %s", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		lease, err := bob.Lock(ctx, "cron", time.Minute)
+		if err != nil {
+			t.Fatalf("Error waiting for lock: %s", err)
+		}
+		if lease.Token <= stale.Token {
+			t.Fatalf("Got token %d, wanted more than %d", lease.Token, stale.Token)
+		}
+
+		// the stale holder is fenced off
+		write := &interfaces.Operation{Key: "report", Value: []byte("alice"), Op: interfaces.OpSet}
+		if err = stale.ProcessBatch([]*interfaces.Operation{write}); err != lock.ErrLeaseLost {
+			t.Fatalf("Got %v, wanted ErrLeaseLost", err)
+		}
+		if err = stale.Renew(time.Minute); err != lock.ErrLeaseLost {
+			t.Fatalf("Renewed an expired lease, got %v", err)
+		}
+		if err = stale.Release(); err != lock.ErrLeaseLost {
+			t.Fatalf("Released an expired lease, got %v", err)
+		}
+
+		write.Value = []byte("bob")
+		if err = lease.ProcessBatch([]*interfaces.Operation{write}); err != nil {
+			t.Fatalf("Error writing with the lease: %s", err)
+		}
+		value, err := storage.Get("report")
+		if err != nil || string(value) != "bob" {
+			t.Fatalf("Got %s, %v, wanted bob", value, err)
+		}
+	})
+}
+
+func TestLockContention(t *testing.T) {
+	testWrap(t, func(storage *db.Badger, t *testing.T) {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		held := 0
+		tokens := make(map[uint64]bool)
+
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				locker := lock.New(storage, "worker", lock.Options{RetryInterval: time.Millisecond})
+				for j := 0; j < 5; j++ {
+					lease, err := locker.Lock(context.Background(), "counter", time.Minute)
+					if err != nil {
+						t.Errorf("Error waiting for lock: %s", err)
+						return
+					}
+
+					mu.Lock()
+					held++
+					if held > 1 || tokens[lease.Token] {
+						t.Errorf("Lock held %d times, token %d seen before: %v", held, lease.Token,
+							tokens[lease.Token])
+					}
+					tokens[lease.Token] = true
+					mu.Unlock()
+
+					time.Sleep(time.Millisecond)
+					mu.Lock()
+					held--
+					mu.Unlock()
+
+					if err = lease.Release(); err != nil {
+						t.Errorf("Error releasing lock: %s", err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if len(tokens) != 25 {
+			t.Fatalf("Drew %d tokens, wanted 25", len(tokens))
+		}
+	})
+}
@@ -0,0 +1,389 @@
+// Package queue implements named durable FIFO queues on any DbStorage
+//
+// A queue keeps its messages under "queue:<name>:", ready messages keyed by their position so the head of the
+// queue is the first key of its prefix. Dequeued messages are leased for a visibility timeout and go back to
+// the queue if they aren't acknowledged in time, messages failing too many times are moved to a dead letter
+// list. Delayed messages wait under their due time and join the queue once due.
+package queue
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// DefaultVisibilityTimeout is how long a dequeued message is leased when no timeout is given
+const DefaultVisibilityTimeout = 30 * time.Second
+
+// requeueBatch is the number of due messages moved back to the queue per transaction
+const requeueBatch = 100
+
+// ErrEmpty is returned by Dequeue when no message is ready
+var ErrEmpty = errors.New("queue is empty")
+
+// ErrLeaseExpired is returned when acknowledging a message whose lease expired, the message went back to the
+// queue, or which was already acknowledged
+var ErrLeaseExpired = errors.New("message lease expired")
+
+// errBadMessage is returned when a stored message can't be decoded
+var errBadMessage = errors.New("invalid queue message")
+
+// Options allows you to change how a queue handles its messages
+// MaxAttempts is the number of times a message is dequeued before it's moved to the dead letters when it
+// isn't acknowledged, zero never moves messages. Now replaces the clock, time.Now by default.
+type Options struct {
+	VisibilityTimeout time.Duration
+	MaxAttempts       int
+	Now               func() time.Time
+}
+
+// Message is a message of a queue
+// Attempts is the number of times the message was dequeued, this time included.
+type Message struct {
+	ID         uint64
+	Body       []byte
+	Attempts   int
+	EnqueuedAt time.Time
+
+	lease string
+}
+
+// Stats is the number of messages of a queue in each state
+type Stats struct {
+	Ready    uint64
+	Delayed  uint64
+	InFlight uint64
+	Dead     uint64
+}
+
+// Queue is a named durable queue stored in a DbStorage
+type Queue struct {
+	storage interfaces.DbStorage
+	opts    Options
+
+	seqKey   string
+	ready    string
+	delayed  string
+	inFlight string
+	dead     string
+}
+
+// New returns the queue name of storage, queues are created as messages are enqueued
+func New(storage interfaces.DbStorage, name string, opts Options) *Queue {
+	if opts.VisibilityTimeout <= 0 {
+		opts.VisibilityTimeout = DefaultVisibilityTimeout
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	prefix := "queue:" + name + ":"
+	return &Queue{
+		storage: storage,
+		opts:    opts,
+
+		seqKey:   prefix + "seq",
+		ready:    prefix + "ready:",
+		delayed:  prefix + "delayed:",
+		inFlight: prefix + "inflight:",
+		dead:     prefix + "dead:",
+	}
+}
+
+// Enqueue adds a message with body to the tail of the queue and returns its ID
+func (q *Queue) Enqueue(body []byte) (uint64, error) {
+	return q.EnqueueDelayed(body, 0)
+}
+
+// EnqueueDelayed adds a message with body to the queue once delay has passed, and returns its ID
+func (q *Queue) EnqueueDelayed(body []byte, delay time.Duration) (uint64, error) {
+	id, err := q.nextSeq()
+	if err != nil {
+		return 0, err
+	}
+
+	now := q.opts.Now()
+	msg := &Message{ID: id, Body: body, EnqueuedAt: now}
+	key := q.ready + seqString(id)
+	if delay > 0 {
+		key = q.delayed + timeString(now.Add(delay)) + seqString(id)
+	}
+
+	return id, q.storage.Set(key, encodeMessage(msg))
+}
+
+// Dequeue takes the message at the head of the queue and leases it for the visibility timeout, it must be
+// acknowledged with Ack or Nack before the lease expires or it goes back to the queue. ErrEmpty is returned
+// if no message is ready.
+func (q *Queue) Dequeue() (*Message, error) {
+	now := q.opts.Now()
+	err := q.requeue(now)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var head string
+		q.storage.IterateByPrefixFrom([]byte(q.ready), []byte(q.ready), 1, func(key []byte, value []byte) {
+			head = string(key)
+		})
+		if head == "" {
+			return nil, ErrEmpty
+		}
+
+		var msg *Message
+		err = db.RetryTxn(q.storage, 0, func(txn interfaces.Txn) error {
+			value, err := txn.Get(head)
+			if err != nil {
+				return err
+			}
+
+			msg, err = decodeMessage(value)
+			if err != nil {
+				return err
+			}
+			msg.Attempts++
+			msg.lease = q.inFlight + timeString(now.Add(q.opts.VisibilityTimeout)) + seqString(msg.ID)
+
+			err = txn.Del(head)
+			if err != nil {
+				return err
+			}
+			return txn.Set(msg.lease, encodeMessage(msg))
+		})
+		if err == badger.ErrKeyNotFound {
+			// taken by another client, try the next head
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+}
+
+// Ack removes a dequeued message from the queue
+func (q *Queue) Ack(msg *Message) error {
+	return db.RetryTxn(q.storage, 0, func(txn interfaces.Txn) error {
+		err := q.release(txn, msg)
+		if err != nil {
+			return err
+		}
+		return txn.Del(msg.lease)
+	})
+}
+
+// Nack gives a dequeued message back to the queue, at its position if delay isn't positive or once delay has
+// passed. The message is moved to the dead letters instead if it was dequeued MaxAttempts times.
+func (q *Queue) Nack(msg *Message, delay time.Duration) error {
+	now := q.opts.Now()
+	return db.RetryTxn(q.storage, 0, func(txn interfaces.Txn) error {
+		err := q.release(txn, msg)
+		if err != nil {
+			return err
+		}
+
+		err = txn.Del(msg.lease)
+		if err != nil {
+			return err
+		}
+
+		key := q.ready + seqString(msg.ID)
+		if q.opts.MaxAttempts > 0 && msg.Attempts >= q.opts.MaxAttempts {
+			key = q.dead + seqString(msg.ID)
+		} else if delay > 0 {
+			key = q.delayed + timeString(now.Add(delay)) + seqString(msg.ID)
+		}
+		return txn.Set(key, encodeMessage(msg))
+	})
+}
+
+// Stats returns the number of messages of the queue in each state
+// Messages whose delay or lease just ended are counted as delayed or in flight until the next Dequeue.
+func (q *Queue) Stats() Stats {
+	return Stats{
+		Ready:    q.storage.KeysByPrefixCount([]byte(q.ready)),
+		Delayed:  q.storage.KeysByPrefixCount([]byte(q.delayed)),
+		InFlight: q.storage.KeysByPrefixCount([]byte(q.inFlight)),
+		Dead:     q.storage.KeysByPrefixCount([]byte(q.dead)),
+	}
+}
+
+// DeadLetters returns up to limit messages moved to the dead letters, oldest first, a zero limit returns all
+func (q *Queue) DeadLetters(limit uint64) ([]*Message, error) {
+	var msgs []*Message
+	var err error
+	q.storage.IterateByPrefix([]byte(q.dead), limit, func(key []byte, value []byte) {
+		msg, decodeErr := decodeMessage(value)
+		if decodeErr != nil {
+			err = decodeErr
+			return
+		}
+		msgs = append(msgs, msg)
+	})
+	return msgs, err
+}
+
+// Redrive moves the dead letters back to the tail of the queue with their attempts reset, and returns how many
+// it moved
+func (q *Queue) Redrive() (int, error) {
+	moved := 0
+	for {
+		var keys []string
+		q.storage.IterateByPrefix([]byte(q.dead), requeueBatch, func(key []byte, value []byte) {
+			keys = append(keys, string(key))
+		})
+		if len(keys) == 0 {
+			return moved, nil
+		}
+
+		err := q.move(keys, func(msg *Message) (string, error) {
+			seq, err := q.nextSeq()
+			msg.Attempts = 0
+			return q.ready + seqString(seq), err
+		})
+		if err != nil {
+			return moved, err
+		}
+		moved += len(keys)
+	}
+}
+
+// requeue moves the delayed messages due at now to the tail of the queue, and the messages whose lease
+// expired back to their position or to the dead letters
+func (q *Queue) requeue(now time.Time) error {
+	end := []byte(timeString(now.Add(1)))
+	for _, prefix := range []string{q.delayed, q.inFlight} {
+		for {
+			var keys []string
+			q.storage.IterateRange([]byte(prefix), append([]byte(prefix), end...),
+				interfaces.RangeOptions{KeysOnly: true, Limit: requeueBatch},
+				func(key []byte, value []byte) {
+					keys = append(keys, string(key))
+				})
+			if len(keys) == 0 {
+				break
+			}
+
+			var err error
+			if prefix == q.delayed {
+				err = q.move(keys, func(msg *Message) (string, error) {
+					seq, err := q.nextSeq()
+					return q.ready + seqString(seq), err
+				})
+			} else {
+				err = q.move(keys, func(msg *Message) (string, error) {
+					if q.opts.MaxAttempts > 0 && msg.Attempts >= q.opts.MaxAttempts {
+						return q.dead + seqString(msg.ID), nil
+					}
+					return q.ready + seqString(msg.ID), nil
+				})
+			}
+			if err != nil {
+				return err
+			}
+
+			if len(keys) < requeueBatch {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// move moves the messages stored at keys to the key returned by to, in one transaction, skipping the keys
+// other clients moved already
+func (q *Queue) move(keys []string, to func(msg *Message) (string, error)) error {
+	// keys are chosen before the transaction so retries don't draw sequences again
+	targets := make(map[string]string, len(keys))
+	msgs := make(map[string]*Message, len(keys))
+
+	return db.RetryTxn(q.storage, 0, func(txn interfaces.Txn) error {
+		for _, key := range keys {
+			value, err := txn.Get(key)
+			if err == badger.ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			msg, err := decodeMessage(value)
+			if err != nil {
+				return err
+			}
+			if _, ok := targets[key]; !ok {
+				targets[key], err = to(msg)
+				if err != nil {
+					return err
+				}
+				msgs[key] = msg
+			}
+
+			err = txn.Del(key)
+			if err != nil {
+				return err
+			}
+			err = txn.Set(targets[key], encodeMessage(msgs[key]))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// release checks msg still holds its lease within txn
+func (q *Queue) release(txn interfaces.Txn, msg *Message) error {
+	if msg.lease == "" {
+		return ErrLeaseExpired
+	}
+
+	_, err := txn.Get(msg.lease)
+	if err == badger.ErrKeyNotFound {
+		return ErrLeaseExpired
+	}
+	return err
+}
+
+func (q *Queue) nextSeq() (uint64, error) {
+	seq, err := q.storage.Increment(q.seqKey, 1)
+	return uint64(seq), err
+}
+
+// encodeMessage encodes a message as its ID, enqueue time and attempts followed by its body
+func encodeMessage(msg *Message) []byte {
+	b := make([]byte, 20, 20+len(msg.Body))
+	binary.BigEndian.PutUint64(b, msg.ID)
+	binary.BigEndian.PutUint64(b[8:], uint64(msg.EnqueuedAt.UnixNano()))
+	binary.BigEndian.PutUint32(b[16:], uint32(msg.Attempts))
+	return append(b, msg.Body...)
+}
+
+func decodeMessage(b []byte) (*Message, error) {
+	if len(b) < 20 {
+		return nil, errBadMessage
+	}
+	return &Message{
+		ID:         binary.BigEndian.Uint64(b),
+		EnqueuedAt: time.Unix(0, int64(binary.BigEndian.Uint64(b[8:]))),
+		Attempts:   int(binary.BigEndian.Uint32(b[16:])),
+		Body:       append([]byte{}, b[20:]...),
+	}, nil
+}
+
+// seqString encodes a sequence so keys sort in sequence order
+func seqString(seq uint64) string {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seq)
+	return string(b)
+}
+
+// timeString encodes a time so keys sort in time order
+func timeString(t time.Time) string {
+	return seqString(uint64(t.UnixNano()))
+}
@@ -0,0 +1,198 @@
+package queue_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/queue"
+)
+
+// testClock is a clock moved by the tests
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func testWrap(t *testing.T, opts queue.Options, tests func(q *queue.Queue, clock *testClock, t *testing.T)) {
+	storage := db.NewBadgerInMemory()
+	defer storage.Close()
+
+	clock := &testClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	opts.Now = clock.Now
+	tests(queue.New(storage, "jobs", opts), clock, t)
+}
+
+func dequeue(t *testing.T, q *queue.Queue, expected string) *queue.Message {
+	t.Helper()
+	msg, err := q.Dequeue()
+	if err != nil {
+		t.Fatalf("Error dequeuing %s: %s", expected, err)
+	}
+	if string(msg.Body) != expected {
+		t.Fatalf("Dequeued %s, wanted %s", msg.Body, expected)
+	}
+	return msg
+}
+
+func TestQueueOrder(t *testing.T) {
+	testWrap(t, queue.Options{}, func(q *queue.Queue, clock *testClock, t *testing.T) {
+		for _, body := range []string{"a", "b", "c"} {
+			if _, err := q.Enqueue([]byte(body)); err != nil {
+				t.Fatalf("Error enqueuing: %s", err)
+			}
+		}
+
+		a := dequeue(t, q, "a")
+		b := dequeue(t, q, "b")
+		if stats := q.Stats(); stats.Ready != 1 || stats.InFlight != 2 {
+			t.Fatalf("Got stats %+v, wanted 1 ready and 2 in flight", stats)
+		}
+
+		if err := q.Ack(a); err != nil {
+			t.Fatalf("Error acknowledging: %s", err)
+		}
+		if err := q.Ack(a); err != queue.ErrLeaseExpired {
+			t.Fatalf("Acknowledged twice, got %v", err)
+		}
+
+		// a nacked message goes back to its position
+		if err := q.Nack(b, 0); err != nil {
+			t.Fatalf("Error nacking: %s", err)
+		}
+		b = dequeue(t, q, "b")
+		if b.Attempts != 2 {
+			t.Fatalf("Got %d attempts, wanted 2", b.Attempts)
+		}
+		dequeue(t, q, "c")
+
+		if _, err := q.Dequeue(); err != queue.ErrEmpty {
+			t.Fatalf("Got %v, wanted ErrEmpty", err)
+		}
+	})
+}
+
+func TestQueueDelayed(t *testing.T) {
+	testWrap(t, queue.Options{}, func(q *queue.Queue, clock *testClock, t *testing.T) {
+		if _, err := q.EnqueueDelayed([]byte("later"), time.Minute); err != nil {
+			t.Fatalf("Error enqueuing: %s", err)
+		}
+		if _, err := q.Enqueue([]byte("now")); err != nil {
+			t.Fatalf("Error enqueuing: %s", err)
+		}
+
+		msg := dequeue(t, q, "now")
+		if _, err := q.Dequeue(); err != queue.ErrEmpty {
+			t.Fatalf("Got %v, wanted the delayed message to wait", err)
+		}
+
+		if err := q.Nack(msg, 2*time.Minute); err != nil {
+			t.Fatalf("Error nacking: %s", err)
+		}
+		if stats := q.Stats(); stats.Delayed != 2 {
+			t.Fatalf("Got stats %+v, wanted 2 delayed", stats)
+		}
+
+		clock.now = clock.now.Add(time.Minute)
+		msg = dequeue(t, q, "later")
+		if err := q.Ack(msg); err != nil {
+			t.Fatalf("Error acknowledging: %s", err)
+		}
+		clock.now = clock.now.Add(time.Minute)
+		dequeue(t, q, "now")
+	})
+}
+
+func TestQueueVisibilityTimeout(t *testing.T) {
+	opts := queue.Options{VisibilityTimeout: time.Minute, MaxAttempts: 2}
+	testWrap(t, opts, func(q *queue.Queue, clock *testClock, t *testing.T) {
+		if _, err := q.Enqueue([]byte("a")); err != nil {
+			t.Fatalf("Error enqueuing: %s", err)
+		}
+		if _, err := q.Enqueue([]byte("b")); err != nil {
+			t.Fatalf("Error enqueuing: %s", err)
+		}
+
+		expired := dequeue(t, q, "a")
+		clock.now = clock.now.Add(time.Minute)
+
+		// the expired message goes back ahead of b
+		msg := dequeue(t, q, "a")
+		if err := q.Ack(expired); err != queue.ErrLeaseExpired {
+			t.Fatalf("Acknowledged an expired lease, got %v", err)
+		}
+
+		// dequeued MaxAttempts times, failing moves it to the dead letters
+		if err := q.Nack(msg, 0); err != nil {
+			t.Fatalf("Error nacking: %s", err)
+		}
+		dequeue(t, q, "b")
+
+		dead, err := q.DeadLetters(0)
+		if err != nil {
+			t.Fatalf("Error listing dead letters: %s", err)
+		}
+		if len(dead) != 1 || string(dead[0].Body) != "a" || dead[0].ID != msg.ID {
+			t.Fatalf("Got %d dead letters, wanted a", len(dead))
+		}
+
+		moved, err := q.Redrive()
+		if err != nil || moved != 1 {
+			t.Fatalf("Redrove %d, %v, wanted 1", moved, err)
+		}
+		msg = dequeue(t, q, "a")
+		if msg.Attempts != 1 {
+			t.Fatalf("Got %d attempts, wanted them reset", msg.Attempts)
+		}
+		if stats := q.Stats(); stats != (queue.Stats{InFlight: 2}) {
+			t.Fatalf("Got stats %+v, wanted 2 in flight", stats)
+		}
+	})
+}
+
+func TestQueueConcurrentDequeue(t *testing.T) {
+	testWrap(t, queue.Options{}, func(q *queue.Queue, clock *testClock, t *testing.T) {
+		const messages = 50
+		for i := 0; i < messages; i++ {
+			if _, err := q.Enqueue([]byte{byte(i)}); err != nil {
+				t.Fatalf("Error enqueuing: %s", err)
+			}
+		}
+
+		var mu sync.Mutex
+		seen := make(map[uint64]bool)
+		var wg sync.WaitGroup
+		for w := 0; w < 5; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					msg, err := q.Dequeue()
+					if err == queue.ErrEmpty {
+						return
+					}
+					if err != nil {
+						t.Errorf("Error dequeuing: %s", err)
+						return
+					}
+
+					mu.Lock()
+					if seen[msg.ID] {
+						t.Errorf("Message %d was dequeued twice", msg.ID)
+					}
+					seen[msg.ID] = true
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if len(seen) != messages {
+			t.Fatalf("Dequeued %d messages, wanted %d", len(seen), messages)
+		}
+	})
+}
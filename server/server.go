@@ -0,0 +1,482 @@
+// Package server serves a DbStorage over HTTP, so several processes can share a storage
+// which only one of them can open, db.Remote is its client
+//
+// Keys, prefixes and range bounds are passed as query parameters, values as raw request and response bodies.
+// Batches, events and iterated key-value pairs are JSON, []byte fields being base64 encoded, iterations and
+// watches are streamed as one JSON object per line. Errors are JSON objects with an error message and a code
+// the client turns back into the error the storage returned.
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// DefaultMaxBodySize is the size in bytes of the largest request body accepted when no size is given
+const DefaultMaxBodySize = 32 << 20
+
+// DefaultWatchBuffer is the number of event batches queued for a watcher when it asks for less
+const DefaultWatchBuffer = 64
+
+// iteratePage is the number of keys an iteration reads at once, a client gone away is noticed between pages
+const iteratePage = 1000
+
+// error codes sent to clients
+const (
+	CodeNotFound           = "notFound"
+	CodePreconditionFailed = "preconditionFailed"
+	CodeConflict           = "conflict"
+	CodeTxnTooBig          = "txnTooBig"
+	CodeNotCounter         = "notCounter"
+	CodeWatchLagged        = "watchLagged"
+	CodeForbidden          = "forbidden"
+	CodeBadRequest         = "badRequest"
+	CodeInternal           = "internal"
+)
+
+// AuthFunc decides whether a request is allowed, write is set for the requests changing the storage
+// Returning an error rejects the request with 403 Forbidden.
+type AuthFunc func(r *http.Request, write bool) error
+
+// Options allows you to change how the storage is served
+// Without Auth every request is allowed. MaxBodySize bounds the size of values and batches.
+type Options struct {
+	Auth        AuthFunc
+	MaxBodySize int64
+}
+
+// Error is the body of an error response, and the last line of a stream which failed
+// Key and Op are set for CodePreconditionFailed.
+type Error struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+	Key   string `json:"key,omitempty"`
+	Op    string `json:"op,omitempty"`
+}
+
+// KV is a line of an iteration stream, Value is null when only keys are iterated
+type KV struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// WatchLine is a line of a watch stream, holding either events or the error which ended the stream
+type WatchLine struct {
+	Events []interfaces.Event `json:"events,omitempty"`
+	*Error
+}
+
+// CompareAndSwap is the body of a compare and swap request, a null Old means the key must not exist
+type CompareAndSwap struct {
+	Key string `json:"key"`
+	Old []byte `json:"old"`
+	New []byte `json:"new"`
+}
+
+// Server is an http.Handler serving a DbStorage
+type Server struct {
+	storage interfaces.DbStorage
+	opts    Options
+	mux     *http.ServeMux
+}
+
+// New returns a server for storage, closing the storage is left to the caller
+func New(storage interfaces.DbStorage, opts Options) *Server {
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultMaxBodySize
+	}
+
+	s := &Server{storage: storage, opts: opts, mux: http.NewServeMux()}
+	s.handle("/kv", s.kv, http.MethodGet, http.MethodPut, http.MethodDelete)
+	s.handle("/ttl", s.ttl, http.MethodGet)
+	s.handle("/batch", s.batch, http.MethodPost)
+	s.handle("/cas", s.compareAndSwap, http.MethodPost)
+	s.handle("/incr", s.increment, http.MethodPost)
+	s.handle("/iterate", s.iterate, http.MethodGet)
+	s.handle("/range", s.iterateRange, http.MethodGet)
+	s.handle("/count", s.count, http.MethodGet)
+	s.handle("/delete-prefix", s.deletePrefix, http.MethodPost)
+	s.handle("/watch", s.watch, http.MethodGet)
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handle registers handler for path, for the methods given, the first one being the only one reading
+func (s *Server) handle(path string, handler http.HandlerFunc, methods ...string) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		allowed := false
+		for _, method := range methods {
+			allowed = allowed || r.Method == method
+		}
+		if !allowed {
+			w.Header().Set("Allow", methods[0])
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if s.opts.Auth != nil {
+			write := r.Method != http.MethodGet
+			if err := s.opts.Auth(r, write); err != nil {
+				writeError(w, http.StatusForbidden, &Error{Error: err.Error(), Code: CodeForbidden})
+				return
+			}
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, s.opts.MaxBodySize)
+		handler(w, r)
+	})
+}
+
+func (s *Server) kv(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+
+	switch r.Method {
+	case http.MethodGet:
+		value, err := s.storage.Get(key)
+		if err != nil {
+			storageError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(value)
+	case http.MethodPut:
+		value, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+
+		ttl, err := durationParam(r, "ttl")
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		if ttl > 0 {
+			err = s.storage.SetWithTTL(key, value, ttl)
+		} else {
+			err = s.storage.Set(key, value)
+		}
+		if err != nil {
+			storageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := s.storage.Del(key); err != nil {
+			storageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) ttl(w http.ResponseWriter, r *http.Request) {
+	ttl, err := s.storage.TTL(r.URL.Query().Get("key"))
+	if err != nil {
+		storageError(w, err)
+		return
+	}
+	writeJSON(w, map[string]time.Duration{"ttl": ttl})
+}
+
+func (s *Server) batch(w http.ResponseWriter, r *http.Request) {
+	var batch []*interfaces.Operation
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	if err := s.storage.ProcessBatch(batch); err != nil {
+		storageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) compareAndSwap(w http.ResponseWriter, r *http.Request) {
+	var cas CompareAndSwap
+	if err := json.NewDecoder(r.Body).Decode(&cas); err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	swapped, err := s.storage.CompareAndSwap(cas.Key, cas.Old, cas.New)
+	if err != nil {
+		storageError(w, err)
+		return
+	}
+	writeJSON(w, map[string]bool{"swapped": swapped})
+}
+
+func (s *Server) increment(w http.ResponseWriter, r *http.Request) {
+	delta, err := strconv.ParseInt(r.URL.Query().Get("delta"), 10, 64)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	value, err := s.storage.Increment(r.URL.Query().Get("key"), delta)
+	if err != nil {
+		storageError(w, err)
+		return
+	}
+	writeJSON(w, map[string]int64{"value": value})
+}
+
+// iterate streams the keys with a prefix, from a key if it's given
+func (s *Server) iterate(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, err := uintParam(r, "limit")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	prefix := []byte(query.Get("prefix"))
+	from := prefix
+	if _, ok := query["from"]; ok {
+		from = []byte(query.Get("from"))
+	}
+
+	// iterate a page at a time, to stop once the client is gone
+	stream := newStream(w)
+	var sent uint64
+	for stream.open(r) {
+		page := pageLimit(limit, sent)
+		var last []byte
+		n := s.storage.IterateByPrefixFrom(prefix, from, page, func(key, value []byte) {
+			last = key
+			stream.kv(key, value)
+		})
+		sent += n
+		if n < page || sent == limit {
+			break
+		}
+		from = append(append([]byte(nil), last...), 0)
+	}
+	stream.Flush()
+}
+
+func (s *Server) iterateRange(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var start, end []byte
+	if _, ok := query["start"]; ok {
+		start = []byte(query.Get("start"))
+	}
+	if _, ok := query["end"]; ok {
+		end = []byte(query.Get("end"))
+	}
+
+	limit, err := uintParam(r, "limit")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	opts := interfaces.RangeOptions{
+		ExcludeStart: query.Get("excludeStart") == "true",
+		IncludeEnd:   query.Get("includeEnd") == "true",
+		Reverse:      query.Get("reverse") == "true",
+		KeysOnly:     query.Get("keysOnly") == "true",
+		Limit:        limit,
+	}
+
+	// iterate a page at a time, to stop once the client is gone
+	stream := newStream(w)
+	var sent uint64
+	for stream.open(r) {
+		opts.Limit = pageLimit(limit, sent)
+		var last []byte
+		n := s.storage.IterateRange(start, end, opts, func(key, value []byte) {
+			last = key
+			stream.kv(key, value)
+		})
+		sent += n
+		if n < opts.Limit || sent == limit {
+			break
+		}
+		if opts.Reverse {
+			end, opts.IncludeEnd = last, false
+		} else {
+			start, opts.ExcludeStart = last, true
+		}
+	}
+	stream.Flush()
+}
+
+func (s *Server) count(w http.ResponseWriter, r *http.Request) {
+	count := s.storage.KeysByPrefixCount([]byte(r.URL.Query().Get("prefix")))
+	writeJSON(w, map[string]uint64{"count": count})
+}
+
+func (s *Server) deletePrefix(w http.ResponseWriter, r *http.Request) {
+	s.storage.DeleteByPrefix([]byte(r.URL.Query().Get("prefix")))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// watch streams the events of the prefixes until the client goes away
+// Writers are never held back by a remote watcher, one falling behind gets a CodeWatchLagged error and
+// its stream is ended.
+func (s *Server) watch(w http.ResponseWriter, r *http.Request) {
+	var prefixes [][]byte
+	for _, prefix := range r.URL.Query()["prefix"] {
+		prefixes = append(prefixes, []byte(prefix))
+	}
+
+	buffer, err := uintParam(r, "buffer")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if buffer < DefaultWatchBuffer {
+		buffer = DefaultWatchBuffer
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	var lagged int32
+	opts := interfaces.WatchOptions{
+		Buffer:       int(buffer),
+		DropWhenFull: true,
+		OnDrop: func(events []interfaces.Event) {
+			atomic.StoreInt32(&lagged, 1)
+			cancel()
+		},
+	}
+
+	stream := newStream(w)
+	stream.Flush()
+	err = s.storage.Watch(ctx, prefixes, opts, func(events []interfaces.Event) error {
+		if err := stream.line(WatchLine{Events: events}); err != nil {
+			return err
+		}
+		stream.Flush()
+		return nil
+	})
+
+	if atomic.LoadInt32(&lagged) == 1 {
+		stream.line(WatchLine{Error: &Error{Error: "watcher fell behind", Code: CodeWatchLagged}})
+	} else if err != nil && !errors.Is(err, context.Canceled) {
+		stream.line(WatchLine{Error: &Error{Error: err.Error(), Code: CodeInternal}})
+	}
+	stream.Flush()
+}
+
+// stream writes JSON lines to a response, flushing them as they are buffered
+// err is the first error writing a key-value pair, the following ones aren't written.
+type stream struct {
+	*bufio.Writer
+	w       http.ResponseWriter
+	encoder *json.Encoder
+	err     error
+}
+
+func newStream(w http.ResponseWriter) *stream {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	s := &stream{w: w}
+	s.Writer = bufio.NewWriter(flushWriter{w})
+	s.encoder = json.NewEncoder(s.Writer)
+	return s
+}
+
+func (s *stream) line(v interface{}) error {
+	return s.encoder.Encode(v)
+}
+
+func (s *stream) kv(key, value []byte) {
+	if s.err == nil {
+		s.err = s.line(KV{Key: key, Value: value})
+	}
+}
+
+// open reports whether lines can still be written for r, false once a write failed or the client went away
+func (s *stream) open(r *http.Request) bool {
+	return s.err == nil && r.Context().Err() == nil
+}
+
+// pageLimit returns the number of keys of the next page of an iteration limited to limit, sent already sent
+func pageLimit(limit, sent uint64) uint64 {
+	if limit != 0 && limit-sent < iteratePage {
+		return limit - sent
+	}
+	return iteratePage
+}
+
+// flushWriter flushes the response every time the buffer of a stream is written to it
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+func durationParam(r *http.Request, name string) (time.Duration, error) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(param)
+}
+
+func uintParam(r *http.Request, name string) (uint64, error) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(param, 10, 64)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, e *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
+
+func badRequest(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusBadRequest, &Error{Error: err.Error(), Code: CodeBadRequest})
+}
+
+// storageError sends an error returned by the storage with the code the client turns back into it
+func storageError(w http.ResponseWriter, err error) {
+	var precondition *interfaces.ErrPreconditionFailed
+	switch {
+	case errors.Is(err, badger.ErrKeyNotFound):
+		writeError(w, http.StatusNotFound, &Error{Error: err.Error(), Code: CodeNotFound})
+	case errors.As(err, &precondition):
+		writeError(w, http.StatusConflict, &Error{Error: err.Error(), Code: CodePreconditionFailed,
+			Key: precondition.Key, Op: precondition.Op})
+	case errors.Is(err, badger.ErrConflict):
+		writeError(w, http.StatusConflict, &Error{Error: err.Error(), Code: CodeConflict})
+	case errors.Is(err, badger.ErrTxnTooBig):
+		writeError(w, http.StatusRequestEntityTooLarge, &Error{Error: err.Error(), Code: CodeTxnTooBig})
+	case errors.Is(err, db.ErrNotCounter):
+		writeError(w, http.StatusUnprocessableEntity, &Error{Error: err.Error(), Code: CodeNotCounter})
+	default:
+		writeError(w, http.StatusInternalServerError, &Error{Error: err.Error(), Code: CodeInternal})
+	}
+}
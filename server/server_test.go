@@ -0,0 +1,195 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+	"github.com/xurwxj/kvdb/server"
+)
+
+func testWrap(t *testing.T, opts server.Options, tests func(storage *db.Badger, srv *httptest.Server, t *testing.T)) {
+	dir, err := ioutil.TempDir("", "kvdb-")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := db.NewBadger(dir)
+	defer storage.Close()
+
+	srv := httptest.NewServer(server.New(storage, opts))
+	defer srv.Close()
+
+	tests(storage, srv, t)
+}
+
+func request(t *testing.T, method, url, body string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error creating request: %s", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %s", err)
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("Error reading response: %s", err)
+	}
+	return res, string(data)
+}
+
+func TestServer(t *testing.T) {
+	testWrap(t, server.Options{}, func(storage *db.Badger, srv *httptest.Server, t *testing.T) {
+		res, _ := request(t, http.MethodPut, srv.URL+"/kv?key=a%00b", "value")
+		if res.StatusCode != http.StatusNoContent {
+			t.Fatalf("Got status %d setting a key", res.StatusCode)
+		}
+		if value, err := storage.Get("a\x00b"); err != nil || string(value) != "value" {
+			t.Fatalf("Got %q, %v, wanted value", value, err)
+		}
+
+		res, body := request(t, http.MethodGet, srv.URL+"/kv?key=a%00b", "")
+		if res.StatusCode != http.StatusOK || body != "value" {
+			t.Fatalf("Got %d %q, wanted value", res.StatusCode, body)
+		}
+
+		res, body = request(t, http.MethodGet, srv.URL+"/kv?key=missing", "")
+		var e server.Error
+		json.Unmarshal([]byte(body), &e)
+		if res.StatusCode != http.StatusNotFound || e.Code != server.CodeNotFound {
+			t.Fatalf("Got %d %q for a missing key, wanted 404 notFound", res.StatusCode, body)
+		}
+
+		res, body = request(t, http.MethodPost, srv.URL+"/batch",
+			`[{"Key":"a\u0000b","Op":"checkAbsent"},{"Key":"c","Op":"set"}]`)
+		e = server.Error{}
+		json.Unmarshal([]byte(body), &e)
+		if res.StatusCode != http.StatusConflict || e.Code != server.CodePreconditionFailed ||
+			e.Key != "a\x00b" || e.Op != "checkAbsent" {
+			t.Fatalf("Got %d %q, wanted a failed precondition", res.StatusCode, body)
+		}
+
+		res, _ = request(t, http.MethodPost, srv.URL+"/batch", `not json`)
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Got status %d for a bad batch, wanted 400", res.StatusCode)
+		}
+
+		res, _ = request(t, http.MethodDelete, srv.URL+"/count", "")
+		if res.StatusCode != http.StatusMethodNotAllowed {
+			t.Fatalf("Got status %d for a bad method, wanted 405", res.StatusCode)
+		}
+
+		storage.Set("p:1", []byte("x"))
+		storage.Set("p:2", nil)
+		res, body = request(t, http.MethodGet, srv.URL+"/iterate?prefix=p:", "")
+		lines := strings.Split(strings.TrimSpace(body), "\n")
+		if res.StatusCode != http.StatusOK || len(lines) != 2 {
+			t.Fatalf("Got %d %q, wanted 2 lines", res.StatusCode, body)
+		}
+		var kv server.KV
+		if err := json.Unmarshal([]byte(lines[1]), &kv); err != nil || string(kv.Key) != "p:2" {
+			t.Fatalf("Got line %q, wanted p:2", lines[1])
+		}
+	})
+}
+
+func TestServerIteratePages(t *testing.T) {
+	testWrap(t, server.Options{}, func(storage *db.Badger, srv *httptest.Server, t *testing.T) {
+		var batch []*interfaces.Operation
+		for i := 0; i < 2500; i++ {
+			batch = append(batch, &interfaces.Operation{Key: fmt.Sprintf("p:%04d", i), Op: interfaces.OpSet})
+		}
+		if err := storage.ProcessBatch(batch); err != nil {
+			t.Fatalf("Error processing batch: %s", err)
+		}
+
+		tests := []struct {
+			url         string
+			first, last string
+			count       int
+		}{
+			{"/iterate?prefix=p:", "p:0000", "p:2499", 2500},
+			{"/iterate?prefix=p:&limit=1500", "p:0000", "p:1499", 1500},
+			{"/iterate?prefix=p:&from=p:0500&limit=2000", "p:0500", "p:2499", 2000},
+			{"/range?start=p:&end=q:", "p:0000", "p:2499", 2500},
+			{"/range?start=p:0100&end=p:2400&reverse=true&limit=1200", "p:2399", "p:1200", 1200},
+			{"/range?start=p:0100&end=p:2400&excludeStart=true&keysOnly=true", "p:0101", "p:2399", 2299},
+		}
+		for _, tst := range tests {
+			res, body := request(t, http.MethodGet, srv.URL+tst.url, "")
+			lines := strings.Split(strings.TrimSpace(body), "\n")
+			if res.StatusCode != http.StatusOK || len(lines) != tst.count {
+				t.Fatalf("Got %d with %d lines for %s, wanted %d", res.StatusCode, len(lines), tst.url, tst.count)
+			}
+
+			var first, last server.KV
+			json.Unmarshal([]byte(lines[0]), &first)
+			json.Unmarshal([]byte(lines[len(lines)-1]), &last)
+			if string(first.Key) != tst.first || string(last.Key) != tst.last {
+				t.Fatalf("Got keys %s to %s for %s, wanted %s to %s", first.Key, last.Key, tst.url, tst.first,
+					tst.last)
+			}
+		}
+	})
+}
+
+func TestServerAuth(t *testing.T) {
+	opts := server.Options{
+		Auth: func(r *http.Request, write bool) error {
+			if write && r.Header.Get("Authorization") != "Bearer secret" {
+				return errors.New("read only")
+			}
+			return nil
+		},
+	}
+
+	testWrap(t, opts, func(storage *db.Badger, srv *httptest.Server, t *testing.T) {
+		storage.Set("a", []byte("1"))
+
+		res, _ := request(t, http.MethodGet, srv.URL+"/kv?key=a", "")
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("Got status %d reading, wanted 200", res.StatusCode)
+		}
+
+		res, body := request(t, http.MethodDelete, srv.URL+"/kv?key=a", "")
+		if res.StatusCode != http.StatusForbidden || !strings.Contains(body, server.CodeForbidden) {
+			t.Fatalf("Got %d %q deleting, wanted 403", res.StatusCode, body)
+		}
+
+		remote, err := db.NewRemote(srv.URL, db.RemoteOptions{
+			Authorize: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer secret")
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error creating remote: %s", err)
+		}
+		if err = remote.Del("a"); err != nil {
+			t.Fatalf("Error deleting with credentials: %s", err)
+		}
+	})
+}
+
+func TestServerMaxBodySize(t *testing.T) {
+	testWrap(t, server.Options{MaxBodySize: 10}, func(storage *db.Badger, srv *httptest.Server, t *testing.T) {
+		res, _ := request(t, http.MethodPut, srv.URL+"/kv?key=a", strings.Repeat("x", 11))
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Got status %d for a value over the limit, wanted 400", res.StatusCode)
+		}
+		if _, err := storage.Get("a"); err == nil {
+			t.Fatalf("Value over the limit was stored")
+		}
+	})
+}
@@ -0,0 +1,383 @@
+// Package stream implements append-only logs on any DbStorage, read by consumer groups from committed offsets
+//
+// A stream keeps its entries under "stream:<name>:log:" keyed by their offset, so reads are a single prefix
+// iteration from an offset. Offsets start at 1 and are drawn in the transaction appending the entry, entries
+// become visible in offset order and readers never skip an entry committed late. Entries are removed by
+// retention, the oldest first, or by compaction, which keeps the last entry of each key.
+package stream
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/interfaces"
+)
+
+// removeBatch is the number of entries removed per transaction
+const removeBatch = 500
+
+// errBadEntry is returned when a stored entry or the stats of a stream can't be decoded
+var errBadEntry = errors.New("invalid stream data")
+
+// Options allows you to change the retention of a stream
+// Trim removes the oldest entries while there are more than MaxEntries, they hold more than MaxBytes of keys
+// and values, or they are older than MaxAge, zero values don't limit the stream. Now replaces the clock,
+// time.Now by default.
+type Options struct {
+	MaxEntries uint64
+	MaxBytes   uint64
+	MaxAge     time.Duration
+	Now        func() time.Time
+}
+
+// Entry is an entry of a stream, Key is optional and only used by compaction
+type Entry struct {
+	Offset uint64
+	Key    []byte
+	Value  []byte
+	Time   time.Time
+}
+
+// Stats describes the entries of a stream, Last is the offset of the last entry appended
+type Stats struct {
+	Last    uint64
+	Entries uint64
+	Bytes   uint64
+}
+
+// Stream is a named append-only log stored in a DbStorage
+type Stream struct {
+	storage interfaces.DbStorage
+	opts    Options
+
+	// appending serializes the appends of the stream, they all write its head
+	appending sync.Mutex
+
+	headKey string
+	log     string
+	groups  string
+}
+
+// New returns the stream name of storage, streams are created as entries are appended
+func New(storage interfaces.DbStorage, name string, opts Options) *Stream {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	prefix := "stream:" + name + ":"
+	return &Stream{
+		storage: storage,
+		opts:    opts,
+
+		headKey: prefix + "head",
+		log:     prefix + "log:",
+		groups:  prefix + "group:",
+	}
+}
+
+// Append adds an entry with key and value to the end of the stream and returns its offset
+// Appends made through other Streams of the storage conflict with each other and are retried.
+func (s *Stream) Append(key, value []byte) (uint64, error) {
+	s.appending.Lock()
+	defer s.appending.Unlock()
+
+	entry := &Entry{Key: key, Value: value, Time: s.opts.Now()}
+	err := db.RetryTxn(s.storage, 0, func(txn interfaces.Txn) error {
+		head, err := s.head(txn)
+		if err != nil {
+			return err
+		}
+
+		head.Last++
+		head.Entries++
+		head.Bytes += entrySize(entry)
+		entry.Offset = head.Last
+
+		err = txn.Set(s.entryKey(entry.Offset), encodeEntry(entry))
+		if err != nil {
+			return err
+		}
+		return txn.Set(s.headKey, encodeStats(head))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return entry.Offset, nil
+}
+
+// Read returns up to limit entries from offset on, a zero limit reads every entry
+// Entries removed by retention or compaction are skipped, reading from zero reads the oldest entry kept.
+func (s *Stream) Read(offset uint64, limit uint64) ([]*Entry, error) {
+	var entries []*Entry
+	var err error
+	s.storage.IterateByPrefixFrom([]byte(s.log), []byte(s.entryKey(offset)), limit, func(key []byte, value []byte) {
+		entry, decodeErr := decodeEntry(key[len(s.log):], value)
+		if decodeErr != nil {
+			err = decodeErr
+			return
+		}
+		entries = append(entries, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Committed returns the offset the consumer group reads from next, zero if it never committed one
+func (s *Stream) Committed(group string) (uint64, error) {
+	value, err := s.storage.Get(s.groups + group)
+	if err == badger.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	offset, err := db.DecodeInt64(value)
+	return uint64(offset), err
+}
+
+// Commit sets the offset the consumer group reads from next, the offset following the last entry it handled
+func (s *Stream) Commit(group string, offset uint64) error {
+	return s.storage.Set(s.groups+group, db.EncodeInt64(int64(offset)))
+}
+
+// Poll reads up to limit entries from the offset committed by the consumer group, commit the offset following
+// the entries handled to read the next ones
+func (s *Stream) Poll(group string, limit uint64) ([]*Entry, error) {
+	offset, err := s.Committed(group)
+	if err != nil {
+		return nil, err
+	}
+	return s.Read(offset, limit)
+}
+
+// Stats returns the last offset, number of entries and size of the stream
+func (s *Stream) Stats() (Stats, error) {
+	value, err := s.storage.Get(s.headKey)
+	if err == badger.ErrKeyNotFound {
+		return Stats{}, nil
+	}
+	if err != nil {
+		return Stats{}, err
+	}
+	return decodeStats(value)
+}
+
+// Trim removes the oldest entries beyond the retention of the stream and returns how many it removed
+func (s *Stream) Trim() (int, error) {
+	removed := 0
+	for {
+		stats, err := s.Stats()
+		if err != nil {
+			return removed, err
+		}
+		expired := s.opts.Now().Add(-s.opts.MaxAge)
+
+		var offsets []uint64
+		done := false
+		s.storage.IterateByPrefix([]byte(s.log), removeBatch, func(key []byte, value []byte) {
+			if done {
+				return
+			}
+
+			entry, decodeErr := decodeEntry(key[len(s.log):], value)
+			if decodeErr != nil {
+				err = decodeErr
+				done = true
+				return
+			}
+			if s.retained(stats, entry, expired) {
+				done = true
+				return
+			}
+
+			offsets = append(offsets, entry.Offset)
+			stats.Entries--
+			stats.Bytes -= entrySize(entry)
+		})
+		if err != nil {
+			return removed, err
+		}
+		if len(offsets) == 0 {
+			return removed, nil
+		}
+
+		err = s.remove(offsets)
+		if err != nil {
+			return removed, err
+		}
+		removed += len(offsets)
+		if done {
+			return removed, nil
+		}
+	}
+}
+
+// retained reports whether the oldest entry of a stream described by stats is within its retention, entries
+// appended before expired are too old
+func (s *Stream) retained(stats Stats, entry *Entry, expired time.Time) bool {
+	if s.opts.MaxEntries != 0 && stats.Entries > s.opts.MaxEntries {
+		return false
+	}
+	if s.opts.MaxBytes != 0 && stats.Bytes > s.opts.MaxBytes {
+		return false
+	}
+	return s.opts.MaxAge == 0 || !entry.Time.Before(expired)
+}
+
+// Compact removes the entries followed by an entry with the same key and returns how many it removed
+// Entries without a key are kept.
+func (s *Stream) Compact() (int, error) {
+	last := make(map[string]uint64)
+	var offsets []uint64
+	var err error
+	s.storage.IterateByPrefix([]byte(s.log), 0, func(key []byte, value []byte) {
+		entry, decodeErr := decodeEntry(key[len(s.log):], value)
+		if decodeErr != nil {
+			err = decodeErr
+			return
+		}
+		if entry.Key == nil {
+			return
+		}
+
+		if offset, ok := last[string(entry.Key)]; ok {
+			offsets = append(offsets, offset)
+		}
+		last[string(entry.Key)] = entry.Offset
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for start := 0; start < len(offsets); start += removeBatch {
+		end := start + removeBatch
+		if end > len(offsets) {
+			end = len(offsets)
+		}
+
+		err = s.remove(offsets[start:end])
+		if err != nil {
+			return start, err
+		}
+	}
+	return len(offsets), nil
+}
+
+// remove deletes the entries at offsets and takes them out of the stats of the stream, in one transaction
+func (s *Stream) remove(offsets []uint64) error {
+	return db.RetryTxn(s.storage, 0, func(txn interfaces.Txn) error {
+		head, err := s.head(txn)
+		if err != nil {
+			return err
+		}
+
+		for _, offset := range offsets {
+			key := s.entryKey(offset)
+			value, err := txn.Get(key)
+			if err == badger.ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			entry, err := decodeEntry([]byte(key[len(s.log):]), value)
+			if err != nil {
+				return err
+			}
+			head.Entries--
+			head.Bytes -= entrySize(entry)
+
+			err = txn.Del(key)
+			if err != nil {
+				return err
+			}
+		}
+
+		return txn.Set(s.headKey, encodeStats(head))
+	})
+}
+
+// head reads the stats of the stream within txn
+func (s *Stream) head(txn interfaces.Txn) (Stats, error) {
+	value, err := txn.Get(s.headKey)
+	if err == badger.ErrKeyNotFound {
+		return Stats{}, nil
+	}
+	if err != nil {
+		return Stats{}, err
+	}
+	return decodeStats(value)
+}
+
+func (s *Stream) entryKey(offset uint64) string {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, offset)
+	return s.log + string(b)
+}
+
+func entrySize(entry *Entry) uint64 {
+	return uint64(len(entry.Key) + len(entry.Value))
+}
+
+// encodeEntry encodes an entry as its time and key length followed by its key and value
+// A nil key is stored with a length of 0xffffffff so it can be told from an empty one.
+func encodeEntry(entry *Entry) []byte {
+	b := make([]byte, 12, 12+len(entry.Key)+len(entry.Value))
+	binary.BigEndian.PutUint64(b, uint64(entry.Time.UnixNano()))
+	keyLen := uint32(len(entry.Key))
+	if entry.Key == nil {
+		keyLen = ^uint32(0)
+	}
+	binary.BigEndian.PutUint32(b[8:], keyLen)
+	b = append(b, entry.Key...)
+	return append(b, entry.Value...)
+}
+
+// decodeEntry decodes an entry stored at the offset encoded in offset
+func decodeEntry(offset []byte, b []byte) (*Entry, error) {
+	if len(offset) != 8 || len(b) < 12 {
+		return nil, errBadEntry
+	}
+
+	entry := &Entry{
+		Offset: binary.BigEndian.Uint64(offset),
+		Time:   time.Unix(0, int64(binary.BigEndian.Uint64(b))),
+	}
+	keyLen := binary.BigEndian.Uint32(b[8:])
+	b = b[12:]
+	if keyLen != ^uint32(0) {
+		if uint64(keyLen) > uint64(len(b)) {
+			return nil, errBadEntry
+		}
+		entry.Key = append([]byte{}, b[:keyLen]...)
+		b = b[keyLen:]
+	}
+	entry.Value = append([]byte{}, b...)
+	return entry, nil
+}
+
+func encodeStats(stats Stats) []byte {
+	b := make([]byte, 24)
+	binary.BigEndian.PutUint64(b, stats.Last)
+	binary.BigEndian.PutUint64(b[8:], stats.Entries)
+	binary.BigEndian.PutUint64(b[16:], stats.Bytes)
+	return b
+}
+
+func decodeStats(b []byte) (Stats, error) {
+	if len(b) != 24 {
+		return Stats{}, errBadEntry
+	}
+	return Stats{
+		Last:    binary.BigEndian.Uint64(b),
+		Entries: binary.BigEndian.Uint64(b[8:]),
+		Bytes:   binary.BigEndian.Uint64(b[16:]),
+	}, nil
+}
@@ -0,0 +1,183 @@
+package stream_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xurwxj/kvdb/db"
+	"github.com/xurwxj/kvdb/stream"
+)
+
+func testWrap(t *testing.T, opts stream.Options, tests func(s *stream.Stream, t *testing.T)) {
+	storage := db.NewBadgerInMemory()
+	defer storage.Close()
+
+	tests(stream.New(storage, "events", opts), t)
+}
+
+func appendAll(t *testing.T, s *stream.Stream, values ...string) {
+	t.Helper()
+	for _, value := range values {
+		if _, err := s.Append(nil, []byte(value)); err != nil {
+			t.Fatalf("Error appending: %s", err)
+		}
+	}
+}
+
+// read returns the offsets and values of the entries read from offset
+func read(t *testing.T, s *stream.Stream, offset, limit uint64) string {
+	t.Helper()
+	entries, err := s.Read(offset, limit)
+	if err != nil {
+		t.Fatalf("Error reading: %s", err)
+	}
+	result := ""
+	for _, entry := range entries {
+		result += fmt.Sprintf("%d:%s ", entry.Offset, entry.Value)
+	}
+	return result
+}
+
+func TestStreamRead(t *testing.T) {
+	testWrap(t, stream.Options{}, func(s *stream.Stream, t *testing.T) {
+		appendAll(t, s, "a", "b", "c", "d")
+
+		if got := read(t, s, 0, 0); got != "1:a 2:b 3:c 4:d " {
+			t.Fatalf("Read %q, wanted every entry", got)
+		}
+		if got := read(t, s, 2, 2); got != "2:b 3:c " {
+			t.Fatalf("Read %q, wanted 2 entries from 2", got)
+		}
+		if got := read(t, s, 5, 0); got != "" {
+			t.Fatalf("Read %q past the end", got)
+		}
+
+		stats, err := s.Stats()
+		if err != nil || stats != (stream.Stats{Last: 4, Entries: 4, Bytes: 4}) {
+			t.Fatalf("Got stats %+v, %v, wanted 4 entries", stats, err)
+		}
+	})
+}
+
+func TestStreamConsumerGroups(t *testing.T) {
+	testWrap(t, stream.Options{}, func(s *stream.Stream, t *testing.T) {
+		appendAll(t, s, "a", "b", "c")
+
+		entries, err := s.Poll("billing", 2)
+		if err != nil || len(entries) != 2 {
+			t.Fatalf("Polled %d entries, %v, wanted 2", len(entries), err)
+		}
+		if err = s.Commit("billing", entries[1].Offset+1); err != nil {
+			t.Fatalf("Error committing: %s", err)
+		}
+
+		entries, err = s.Poll("billing", 0)
+		if err != nil || len(entries) != 1 || string(entries[0].Value) != "c" {
+			t.Fatalf("Polled %d entries, %v, wanted c", len(entries), err)
+		}
+
+		// groups have their own offsets
+		entries, err = s.Poll("search", 0)
+		if err != nil || len(entries) != 3 {
+			t.Fatalf("Polled %d entries, %v, wanted 3", len(entries), err)
+		}
+		offset, err := s.Committed("search")
+		if err != nil || offset != 0 {
+			t.Fatalf("Got offset %d, %v, wanted 0", offset, err)
+		}
+	})
+}
+
+func TestStreamRetention(t *testing.T) {
+	clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	opts := stream.Options{
+		MaxEntries: 4,
+		MaxBytes:   6,
+		MaxAge:     time.Hour,
+		Now: func() time.Time {
+			return clock
+		},
+	}
+	testWrap(t, opts, func(s *stream.Stream, t *testing.T) {
+		appendAll(t, s, "a", "b", "c", "d", "e")
+		removed, err := s.Trim()
+		if err != nil || removed != 1 {
+			t.Fatalf("Trimmed %d, %v, wanted 1 entry beyond the count", removed, err)
+		}
+
+		appendAll(t, s, "ff", "gg")
+		removed, err = s.Trim()
+		if err != nil || removed != 2 {
+			t.Fatalf("Trimmed %d, %v, wanted 2 entries beyond the size", removed, err)
+		}
+		if got := read(t, s, 0, 0); got != "4:d 5:e 6:ff 7:gg " {
+			t.Fatalf("Read %q after trimming", got)
+		}
+
+		clock = clock.Add(time.Hour)
+		appendAll(t, s, "h")
+		clock = clock.Add(time.Minute)
+		removed, err = s.Trim()
+		if err != nil || removed != 4 {
+			t.Fatalf("Trimmed %d, %v, wanted 4 entries beyond the age", removed, err)
+		}
+
+		stats, err := s.Stats()
+		if err != nil || stats != (stream.Stats{Last: 8, Entries: 1, Bytes: 1}) {
+			t.Fatalf("Got stats %+v, %v, wanted only h left", stats, err)
+		}
+	})
+}
+
+func TestStreamCompact(t *testing.T) {
+	testWrap(t, stream.Options{}, func(s *stream.Stream, t *testing.T) {
+		for _, kv := range [][2]string{{"x", "1"}, {"y", "1"}, {"x", "2"}, {"", "keyless"}, {"x", "3"}} {
+			var key []byte
+			if kv[0] != "" {
+				key = []byte(kv[0])
+			}
+			if _, err := s.Append(key, []byte(kv[1])); err != nil {
+				t.Fatalf("Error appending: %s", err)
+			}
+		}
+
+		removed, err := s.Compact()
+		if err != nil || removed != 2 {
+			t.Fatalf("Compacted %d, %v, wanted 2", removed, err)
+		}
+		if got := read(t, s, 0, 0); got != "2:1 4:keyless 5:3 " {
+			t.Fatalf("Read %q after compacting", got)
+		}
+	})
+}
+
+func TestStreamConcurrentAppend(t *testing.T) {
+	testWrap(t, stream.Options{}, func(s *stream.Stream, t *testing.T) {
+		var wg sync.WaitGroup
+		for w := 0; w < 5; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 10; i++ {
+					if _, err := s.Append(nil, []byte("x")); err != nil {
+						t.Errorf("Error appending: %s", err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		entries, err := s.Read(0, 0)
+		if err != nil || len(entries) != 50 {
+			t.Fatalf("Read %d entries, %v, wanted 50", len(entries), err)
+		}
+		for i, entry := range entries {
+			if entry.Offset != uint64(i+1) {
+				t.Fatalf("Got offset %d at %d, wanted offsets without gaps", entry.Offset, i)
+			}
+		}
+	})
+}